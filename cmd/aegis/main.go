@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/config"
+	"github.com/randhir/aegis-core/internal/keyring"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := logger.Initialize(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Log.Sync()
+
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		err = runServer(cfg)
+	case "migrate":
+		err = runMigrate(cfg, args)
+	case "help", "-h", "--help":
+		printUsage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		printUsage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		logger.Error("Command failed", zap.String("command", command), zap.Error(err))
+		logger.Log.Sync()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: aegis [command]
+
+Commands:
+  serve                 Start the HTTP server (default)
+  migrate up            Apply all pending schema migrations
+  migrate down [steps]  Revert the last applied migration(s), default 1
+  migrate status        Show applied and pending migrations`)
+}
+
+func runServer(cfg *config.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := repository.ConnectPostgres(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer closePostgres(db)
+
+	if err := prepareSchema(ctx, cfg, db); err != nil {
+		return err
+	}
+
+	redisClient, err := cache.ConnectRedis(cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer closeRedis(redisClient)
+
+	// User token epochs are checked on every request, so they are cached in process
+	redisRevocations := cache.NewRedisTokenRevocationStore(redisClient, cfg.Redis.OperationTimeout)
+	revocations := cache.NewCachedTokenRevocationStore(redisRevocations, cfg.JWT.EpochCacheTTL)
+
+	// Limits are shared through Redis unless this is a single-node deployment
+	var rateLimiter cache.RateLimiter = cache.NewRedisRateLimiter(redisClient, cfg.Redis.OperationTimeout)
+	if cfg.RateLimit.Store == "memory" {
+		rateLimiter = cache.NewMemoryRateLimiter()
+	}
+
+	keys, err := loadKeyring(ctx, cfg, db)
+	if err != nil {
+		return err
+	}
+
+	router, err := setupRouter(cfg, keys, stores{
+		users:         repository.NewPostgresUserStore(db, cfg.Database.QueryTimeout),
+		refreshTokens: repository.NewPostgresRefreshTokenStore(db, cfg.Database.QueryTimeout),
+		sessions:      repository.NewPostgresSessionStore(db, cfg.Database.QueryTimeout),
+		revocations:   revocations,
+		references:    cache.NewRedisReferenceTokenStore(redisClient, cfg.Redis.OperationTimeout),
+		rotations:     cache.NewRedisRefreshRotationCache(redisClient, cfg.Redis.OperationTimeout),
+		events:        repository.NewPostgresSecurityEventStore(db, cfg.Database.QueryTimeout),
+		mfa:           repository.NewPostgresMFAStore(db, cfg.Database.QueryTimeout),
+		challenges:    cache.NewRedisMFAChallengeStore(redisClient, cfg.Redis.OperationTimeout),
+		passkeys:      repository.NewPostgresPasskeyStore(db, cfg.Database.QueryTimeout),
+		webauthn:      cache.NewRedisWebAuthnChallengeStore(redisClient, cfg.Redis.OperationTimeout),
+		loginAttempts: cache.NewRedisLoginAttemptStore(redisClient, cfg.Redis.OperationTimeout),
+		rateLimiter:   rateLimiter,
+	})
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: router,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("Server starting", zap.String("addr", server.Addr))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			return fmt.Errorf("server failed: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutdown signal received, draining in-flight requests",
+		zap.Duration("timeout", cfg.Server.ShutdownTimeout),
+	)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server gracefully: %w", err)
+	}
+
+	logger.Info("Server stopped")
+	return nil
+}
+
+// loadKeyring loads the signing keyring and keeps it fresh until ctx is done.
+// It returns nil when no keyring encryption key is configured, leaving the
+// configured secrets and key file as the only signing keys.
+func loadKeyring(ctx context.Context, cfg *config.Config, db *sql.DB) (utils.KeyProvider, error) {
+	if cfg.Keyring.EncryptionKey == "" {
+		return nil, nil
+	}
+
+	keys, err := keyring.New(repository.NewPostgresSigningKeyStore(db, cfg.Database.QueryTimeout), cfg.Keyring.EncryptionKey, cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
+	if err := keys.Load(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load keyring: %w", err)
+	}
+
+	go keys.Watch(ctx, cfg.Keyring.ReloadInterval)
+	return keys, nil
+}
+
+func closePostgres(db *sql.DB) {
+	if err := repository.ClosePostgres(db); err != nil {
+		logger.Error("Failed to close PostgreSQL connection", zap.Error(err))
+		return
+	}
+	logger.Info("PostgreSQL connection closed")
+}
+
+func closeRedis(client *redis.Client) {
+	if err := cache.CloseRedis(client); err != nil {
+		logger.Error("Failed to close Redis connection", zap.Error(err))
+		return
+	}
+	logger.Info("Redis connection closed")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/randhir/aegis-core/internal/config"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/migrate"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/migrations"
+	"go.uber.org/zap"
+)
+
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return fmt.Errorf("missing migrate subcommand")
+	}
+
+	db, err := repository.ConnectPostgres(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer closePostgres(db)
+
+	migrator, err := migrate.NewMigrator(db, migrations.Files)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s), schema at version %d\n", applied, migrator.LatestVersion())
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d migration(s)\n", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format("2006-01-02T15:04:05Z07:00")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		printUsage()
+		return fmt.Errorf("unknown migrate subcommand %q", args[0])
+	}
+
+	return nil
+}
+
+// prepareSchema optionally applies pending migrations, then refuses to continue
+// if the database is behind the version the repository layer expects.
+func prepareSchema(ctx context.Context, cfg *config.Config, db *sql.DB) error {
+	migrator, err := migrate.NewMigrator(db, migrations.Files)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Database.AutoMigrate {
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("Schema migrations applied on startup", zap.Int("applied", applied))
+	}
+
+	return migrator.EnsureVersion(ctx, repository.SchemaVersion)
+}
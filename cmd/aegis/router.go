@@ -0,0 +1,165 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/config"
+	"github.com/randhir/aegis-core/internal/handlers"
+	"github.com/randhir/aegis-core/internal/middleware"
+	"github.com/randhir/aegis-core/internal/notify"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/service"
+	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/randhir/aegis-core/internal/webauthn"
+)
+
+// stores groups the persistence backends the router is built on
+type stores struct {
+	users         repository.UserStore
+	refreshTokens repository.RefreshTokenStore
+	sessions      repository.SessionStore
+	revocations   cache.TokenRevocationStore
+	references    cache.ReferenceTokenStore
+	rotations     cache.RefreshRotationCache
+	events        repository.SecurityEventStore
+	mfa           repository.MFAStore
+	challenges    cache.MFAChallengeStore
+	passkeys      repository.PasskeyStore
+	webauthn      cache.WebAuthnChallengeStore
+	loginAttempts cache.LoginAttemptStore
+	rateLimiter   cache.RateLimiter
+}
+
+func setupRouter(cfg *config.Config, keys utils.KeyProvider, stores stores) (*gin.Engine, error) {
+	jwtManager, err := utils.NewJWTManager(cfg.JWT, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without an MFA encryption key users can't enroll
+	var mfaSecrets *utils.MFASecrets
+	if cfg.MFA.EncryptionKey != "" {
+		mfaSecrets, err = utils.NewMFASecrets(cfg.MFA.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Without an RP ID passkeys are disabled
+	var relyingParty *webauthn.RelyingParty
+	if cfg.WebAuthn.RPID != "" {
+		origins := cfg.WebAuthn.Origins
+		if len(origins) == 0 {
+			origins = []string{"https://" + cfg.WebAuthn.RPID}
+		}
+		relyingParty = webauthn.NewRelyingParty(cfg.WebAuthn.RPID, cfg.WebAuthn.RPName, origins)
+	}
+
+	passkeyService := service.NewPasskeyService(stores.users, stores.passkeys, stores.webauthn, stores.challenges, stores.events, relyingParty, cfg.WebAuthn.ChallengeTTL)
+	lockoutService := service.NewLockoutService(stores.users, stores.loginAttempts, stores.events, cfg.Lockout.MaxAccountFailures, cfg.Lockout.MaxIPFailures, cfg.Lockout.FailureWindow, cfg.Lockout.BaseLockout, cfg.Lockout.MaxLockout)
+	mfaService := service.NewMFAService(stores.users, stores.mfa, stores.challenges, stores.events, passkeyService, mfaSecrets, cfg.MFA.Issuer, cfg.MFA.ChallengeTTL, cfg.MFA.MaxAttempts)
+	var notifier notify.Notifier
+	if cfg.Registration.EnumerationSafe {
+		notifier = notify.NewLogNotifier()
+	}
+	passwordHashers, err := utils.NewPasswordHashers(cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+	passwordPool := utils.NewPasswordPool(passwordHashers, cfg.Password.HashWorkers, cfg.Password.HashQueueSize)
+	authService := service.NewAuthService(stores.users, stores.refreshTokens, stores.sessions, stores.references, mfaService, passkeyService, lockoutService, notifier, passwordPool, jwtManager)
+	tokenService := service.NewTokenService(stores.users, stores.refreshTokens, stores.sessions, stores.revocations, stores.references, stores.rotations, stores.events, jwtManager, cfg.JWT.RotationGracePeriod)
+	sessionService := service.NewSessionService(stores.users, stores.sessions, stores.refreshTokens, stores.revocations, jwtManager)
+
+	metrics := new(expvar.Map).Init()
+	metrics.Set("password_hashing", passwordPool.Metrics())
+
+	healthHandler := handlers.NewHealthHandler()
+	metricsHandler := handlers.NewMetricsHandler(metrics)
+	jwksHandler := handlers.NewJWKSHandler(jwtManager)
+	authHandler := handlers.NewAuthHandler(authService)
+	tokenHandler := handlers.NewTokenHandler(tokenService)
+	userHandler := handlers.NewUserHandler(stores.users)
+	sessionHandler := handlers.NewSessionHandler(sessionService)
+	mfaHandler := handlers.NewMFAHandler(mfaService)
+	passkeyHandler := handlers.NewPasskeyHandler(passkeyService, authService)
+	lockoutHandler := handlers.NewLockoutHandler(lockoutService)
+
+	requireAuth := middleware.AuthMiddleware(jwtManager, stores.revocations, stores.references)
+
+	rateLimits := make(map[string]gin.HandlerFunc, len(cfg.RateLimit.Routes))
+	for route, rule := range cfg.RateLimit.Routes {
+		key, err := middleware.RateLimitKey(rule.By, jwtManager.KnownClient)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit for %s: %w", route, err)
+		}
+		rateLimits[route] = middleware.RateLimit(stores.rateLimiter, route, rule.Limit, rule.Window, key)
+	}
+	// Routes without a configured limit are not rate limited
+	rateLimit := func(route string) gin.HandlerFunc {
+		if handler, ok := rateLimits[route]; ok {
+			return handler
+		}
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, err
+	}
+	router.Use(gin.Recovery())
+	router.Use(middleware.ErrorHandler())
+	router.Use(middleware.RequestTimeout(cfg.Server.RequestTimeout))
+
+	router.GET("/health", healthHandler.Health)
+	router.GET("/.well-known/jwks.json", jwksHandler.JWKS)
+
+	auth := router.Group("/auth")
+	{
+		auth.POST("/register", rateLimit("register"), authHandler.Register)
+		auth.POST("/login", rateLimit("login"), authHandler.Login)
+		auth.POST("/mfa/verify", rateLimit("mfa"), authHandler.VerifyMFA)
+		auth.POST("/mfa/passkey/begin", rateLimit("mfa"), passkeyHandler.BeginSecondFactor)
+		auth.POST("/passkeys/login/begin", rateLimit("passkey"), passkeyHandler.BeginLogin)
+		auth.POST("/passkeys/login/finish", rateLimit("passkey"), passkeyHandler.FinishLogin)
+		auth.POST("/refresh", rateLimit("refresh"), tokenHandler.Refresh)
+		auth.POST("/logout", tokenHandler.Logout)
+	}
+
+	router.GET("/profile", requireAuth, userHandler.GetProfile)
+
+	sessions := router.Group("/sessions", requireAuth)
+	{
+		sessions.GET("", sessionHandler.ListSessions)
+		sessions.DELETE("", sessionHandler.RevokeOtherSessions)
+		sessions.DELETE("/:id", sessionHandler.RevokeSession)
+	}
+
+	mfa := router.Group("/mfa", requireAuth)
+	{
+		mfa.POST("/totp/enroll", mfaHandler.EnrollTOTP)
+		mfa.POST("/totp/confirm", mfaHandler.ConfirmTOTP)
+	}
+
+	passkeys := router.Group("/passkeys", requireAuth)
+	{
+		passkeys.GET("", passkeyHandler.ListPasskeys)
+		passkeys.POST("/register/begin", passkeyHandler.BeginRegistration)
+		passkeys.POST("/register/finish", passkeyHandler.FinishRegistration)
+		passkeys.DELETE("/:id", passkeyHandler.DeletePasskey)
+	}
+
+	admin := router.Group("/admin", requireAuth, middleware.RequireRole("ADMIN"))
+	{
+		admin.GET("/metrics", metricsHandler.Metrics)
+		admin.GET("/users", userHandler.ListUsers)
+		admin.POST("/users/:id/revoke-tokens", sessionHandler.RevokeUserTokens)
+		admin.DELETE("/users/:id/mfa", mfaHandler.ResetMFA)
+		admin.POST("/users/:id/unlock", lockoutHandler.UnlockUser)
+	}
+
+	return router, nil
+}
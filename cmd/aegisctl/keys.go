@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/randhir/aegis-core/internal/keyring"
+	"github.com/randhir/aegis-core/internal/utils"
+)
+
+func listKeys(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := requireKeyring(env)
+	if err != nil {
+		return err
+	}
+
+	entries, err := keys.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tPURPOSE\tALGORITHM\tSTATUS\tCREATED AT\tACTIVATED AT\tDEACTIVATED AT")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.ID,
+			entry.Purpose,
+			entry.Algorithm,
+			entry.Status,
+			entry.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			formatOptionalTime(entry.ActivatedAt),
+			formatOptionalTime(entry.DeactivatedAt),
+		)
+	}
+	return w.Flush()
+}
+
+func stageKey(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	purpose := fs.String("purpose", "", "what the key is for (access, refresh or encryption)")
+	algorithm := fs.String("algorithm", "", "HS256, RS256, ES256 or EdDSA for signing keys, dir, RSA-OAEP or RSA-OAEP-256 for encryption keys (default RS256 for access, HS256 for refresh, dir for encryption)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := requireKeyring(env)
+	if err != nil {
+		return err
+	}
+
+	switch *purpose {
+	case utils.KeyPurposeAccess:
+		if *algorithm == "" {
+			*algorithm = utils.AlgorithmRS256
+		}
+	case utils.KeyPurposeRefresh:
+		// Refresh tokens are only ever verified by this service
+		if *algorithm == "" {
+			*algorithm = utils.AlgorithmHS256
+		}
+	case utils.KeyPurposeEncryption:
+		// Access tokens are only ever decrypted by this service
+		if *algorithm == "" {
+			*algorithm = utils.EncryptionDirect
+		}
+	default:
+		return errors.New("-purpose must be access, refresh or encryption")
+	}
+
+	entry, err := keys.Stage(ctx, *purpose, *algorithm)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("staged %s %s key %s\n", entry.Purpose, entry.Algorithm, entry.ID)
+	fmt.Println("promote it once every instance has reloaded the keyring and JWKS caches have refreshed")
+	return nil
+}
+
+func promoteKey(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	kid := fs.String("kid", "", "ID of the staged key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := requireKeyring(env)
+	if err != nil {
+		return err
+	}
+
+	if *kid == "" {
+		return errors.New("-kid is required")
+	}
+
+	if err := keys.Promote(ctx, *kid); err != nil {
+		return err
+	}
+
+	fmt.Printf("promoted key %s; the previous key keeps verifying the tokens it signed\n", *kid)
+	return nil
+}
+
+func retireKey(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	kid := fs.String("kid", "", "ID of the staged or inactive key")
+	force := fs.Bool("force", false, "retire even if tokens it signed may not have expired yet")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := requireKeyring(env)
+	if err != nil {
+		return err
+	}
+
+	if *kid == "" {
+		return errors.New("-kid is required")
+	}
+
+	if err := keys.Retire(ctx, *kid, *force); err != nil {
+		return err
+	}
+
+	fmt.Printf("retired key %s\n", *kid)
+	return nil
+}
+
+func requireKeyring(env *environment) (*keyring.Keyring, error) {
+	if env.keyring == nil {
+		return nil, errors.New("the keyring is not configured (set KEYRING_ENCRYPTION_KEY)")
+	}
+	return env.keyring, nil
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("2006-01-02T15:04:05Z07:00")
+}
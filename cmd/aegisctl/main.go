@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/config"
+	"github.com/randhir/aegis-core/internal/keyring"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error
+}
+
+// environment holds the stores the subcommands operate on
+type environment struct {
+	users         repository.UserStore
+	refreshTokens repository.RefreshTokenStore
+	sessions      repository.SessionStore
+	revocations   cache.TokenRevocationStore
+	events        repository.SecurityEventStore
+	jwt           *utils.JWTManager
+	passwords     *utils.PasswordHashers
+	// keyring is nil when KEYRING_ENCRYPTION_KEY is not set
+	keyring *keyring.Keyring
+}
+
+// tokenHashBackfiller is implemented by refresh token stores that may hold raw
+// tokens written before refresh tokens were stored hashed
+type tokenHashBackfiller interface {
+	BackfillTokenHashes(ctx context.Context, hash func(token string) (string, error), batchSize int) (int, error)
+}
+
+var commands = []command{
+	{"create-user", "-email EMAIL [-role USER|ADMIN] [-password PASSWORD]", "Create a user with the given role", createUser},
+	{"promote", "-email EMAIL", "Grant the ADMIN role to a user", promote},
+	{"demote", "-email EMAIL", "Reset a user's role to USER", demote},
+	{"set-role", "-email EMAIL -role ROLE", "Set a user's role", setRole},
+	{"reset-password", "-email EMAIL [-password PASSWORD]", "Set a new password and end all of the user's sessions", resetPassword},
+	{"revoke-tokens", "-email EMAIL", "End all sessions of a user, revoking their refresh tokens", revokeTokens},
+	{"list-users", "", "List all users", listUsers},
+	{"hash-refresh-tokens", "[-batch-size N]", "Replace raw refresh tokens stored before hashing with their keyed hashes", hashRefreshTokens},
+	{"list-security-events", "[-since DURATION]", "List recorded security events, newest first", listSecurityEvents},
+	{"list-keys", "", "List the token signing and encryption keys in the keyring", listKeys},
+	{"stage-key", "-purpose access|refresh|encryption [-algorithm ALG]", "Generate a key that verifies or decrypts tokens but does not sign or encrypt yet", stageKey},
+	{"promote-key", "-kid KID", "Start signing tokens with a staged key", promoteKey},
+	{"retire-key", "-kid KID [-force]", "Stop accepting tokens signed with a key once they have expired", retireKey},
+}
+
+var validRoles = map[string]bool{
+	models.RoleUser:  true,
+	models.RoleAdmin: true,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage()
+		return
+	}
+
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+
+	cfg, db, redisClient, err := setup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var keys *keyring.Keyring
+	var keyProvider utils.KeyProvider
+	if cfg.Keyring.EncryptionKey != "" {
+		keys, err = keyring.New(repository.NewPostgresSigningKeyStore(db, cfg.Database.QueryTimeout), cfg.Keyring.EncryptionKey, cfg.JWT)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		keyProvider = keys
+	}
+
+	jwtManager, err := utils.NewJWTManager(cfg.JWT, keyProvider)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	passwords, err := utils.NewPasswordHashers(cfg.Password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	env := &environment{
+		users:         repository.NewPostgresUserStore(db, cfg.Database.QueryTimeout),
+		refreshTokens: repository.NewPostgresRefreshTokenStore(db, cfg.Database.QueryTimeout),
+		sessions:      repository.NewPostgresSessionStore(db, cfg.Database.QueryTimeout),
+		revocations:   cache.NewRedisTokenRevocationStore(redisClient, cfg.Redis.OperationTimeout),
+		events:        repository.NewPostgresSecurityEventStore(db, cfg.Database.QueryTimeout),
+		jwt:           jwtManager,
+		passwords:     passwords,
+		keyring:       keys,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = cmd.run(ctx, env, newFlagSet(cmd), args)
+	stop()
+	repository.ClosePostgres(db)
+	cache.CloseRedis(redisClient)
+	logger.Log.Sync()
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func setup() (*config.Config, *sql.DB, *redis.Client, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if err := logger.Initialize(); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+
+	db, err := repository.ConnectPostgres(cfg.Database)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Revoking a user's access tokens needs the Redis copy of their token epoch
+	redisClient, err := cache.ConnectRedis(cfg.Redis)
+	if err != nil {
+		repository.ClosePostgres(db)
+		return nil, nil, nil, err
+	}
+
+	return cfg, db, redisClient, nil
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: aegisctl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.usage, cmd.description)
+	}
+	w.Flush()
+}
+
+func newFlagSet(cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: aegisctl %s %s\n", cmd.name, cmd.usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func createUser(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	email := fs.String("email", "", "email address of the new user")
+	password := fs.String("password", "", "password (read from stdin if omitted)")
+	role := fs.String("role", models.RoleUser, "role to assign (USER or ADMIN)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	normalizedEmail, err := normalizeEmail(*email)
+	if err != nil {
+		return err
+	}
+
+	normalizedRole, err := normalizeRole(*role)
+	if err != nil {
+		return err
+	}
+
+	passwordHash, err := readAndHashPassword(env.passwords, *password)
+	if err != nil {
+		return err
+	}
+
+	user, err := env.users.CreateUser(ctx, normalizedEmail, passwordHash, normalizedRole)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created user %s (%s) with role %s\n", user.Email, user.ID, user.Role)
+	return nil
+}
+
+func promote(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	return changeRole(ctx, env, fs, args, models.RoleAdmin)
+}
+
+func demote(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	return changeRole(ctx, env, fs, args, models.RoleUser)
+}
+
+func setRole(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	return changeRole(ctx, env, fs, args, "")
+}
+
+// changeRole updates a user's role; if role is empty it is taken from the -role flag
+func changeRole(ctx context.Context, env *environment, fs *flag.FlagSet, args []string, role string) error {
+	email := fs.String("email", "", "email address of the user")
+	var roleFlag *string
+	if role == "" {
+		roleFlag = fs.String("role", "", "role to assign (USER or ADMIN)")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if roleFlag != nil {
+		role = *roleFlag
+	}
+
+	normalizedRole, err := normalizeRole(role)
+	if err != nil {
+		return err
+	}
+
+	user, err := lookupUser(ctx, env, *email)
+	if err != nil {
+		return err
+	}
+
+	if user.Role == normalizedRole {
+		fmt.Printf("user %s already has role %s\n", user.Email, user.Role)
+		return nil
+	}
+
+	if err := env.users.UpdateUserRole(ctx, user.ID, normalizedRole); err != nil {
+		return err
+	}
+
+	fmt.Printf("changed role of %s from %s to %s\n", user.Email, user.Role, normalizedRole)
+
+	// Access tokens carry the role, so a downgrade must not wait for them to expire
+	if user.Role == models.RoleAdmin {
+		revoked, err := endSessions(ctx, env, user.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("ended %d session(s) so the old role stops working immediately\n", revoked)
+	} else {
+		fmt.Println("note: existing access tokens keep the old role until they expire")
+	}
+
+	return nil
+}
+
+func resetPassword(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	email := fs.String("email", "", "email address of the user")
+	password := fs.String("password", "", "new password (read from stdin if omitted)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := lookupUser(ctx, env, *email)
+	if err != nil {
+		return err
+	}
+
+	passwordHash, err := readAndHashPassword(env.passwords, *password)
+	if err != nil {
+		return err
+	}
+
+	if err := env.users.UpdateUserPassword(ctx, user.ID, passwordHash); err != nil {
+		return err
+	}
+
+	revoked, err := endSessions(ctx, env, user.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("reset password for %s and ended %d session(s)\n", user.Email, revoked)
+	return nil
+}
+
+func revokeTokens(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	email := fs.String("email", "", "email address of the user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := lookupUser(ctx, env, *email)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := endSessions(ctx, env, user.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("ended %d session(s) for %s\n", revoked, user.Email)
+	return nil
+}
+
+// endSessions deletes every session and refresh token of a user, rejects every
+// access token issued to them until now, and returns the number of sessions ended
+func endSessions(ctx context.Context, env *environment, userID uuid.UUID) (int64, error) {
+	revokedAt := time.Now()
+	if err := env.users.RevokeUserTokens(ctx, userID, revokedAt); err != nil {
+		return 0, err
+	}
+
+	err := env.revocations.RevokeUserTokens(ctx, userID.String(), revokedAt, revokedAt.Add(env.jwt.MaxAccessTokenTTL()))
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := env.refreshTokens.DeleteRefreshTokensByUserID(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	return env.sessions.DeleteSessionsByUserID(ctx, userID)
+}
+
+func listUsers(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	users, err := env.users.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEMAIL\tROLE\tCREATED AT")
+	for _, user := range users {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			user.ID,
+			user.Email,
+			user.Role,
+			user.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		)
+	}
+	return w.Flush()
+}
+
+func hashRefreshTokens(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	batchSize := fs.Int("batch-size", 500, "number of tokens to hash per transaction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *batchSize < 1 {
+		return errors.New("-batch-size must be positive")
+	}
+
+	backfiller, ok := env.refreshTokens.(tokenHashBackfiller)
+	if !ok {
+		return errors.New("refresh token store does not support hash backfill")
+	}
+
+	total := 0
+	for {
+		hashed, err := backfiller.BackfillTokenHashes(ctx, env.jwt.HashRefreshToken, *batchSize)
+		if err != nil {
+			return err
+		}
+		if hashed == 0 {
+			break
+		}
+		total += hashed
+	}
+
+	fmt.Printf("hashed %d refresh token(s)\n", total)
+	return nil
+}
+
+func listSecurityEvents(ctx context.Context, env *environment, fs *flag.FlagSet, args []string) error {
+	since := fs.Duration("since", 24*time.Hour, "how far back to list events")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	events, err := env.events.ListSecurityEvents(ctx, time.Now().Add(-*since))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED AT\tTYPE\tUSER ID\tDETAILS")
+	for _, event := range events {
+		userID := "-"
+		if event.UserID != nil {
+			userID = event.UserID.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			event.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			event.Type,
+			userID,
+			event.Details,
+		)
+	}
+	return w.Flush()
+}
+
+func lookupUser(ctx context.Context, env *environment, email string) (*models.User, error) {
+	normalizedEmail, err := normalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	return env.users.GetUserByEmail(ctx, normalizedEmail)
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(strings.ToLower(email))
+	if email == "" {
+		return "", errors.New("-email is required")
+	}
+	if !utils.ValidateEmail(email) {
+		return "", errors.New("invalid email format")
+	}
+	return email, nil
+}
+
+func normalizeRole(role string) (string, error) {
+	role = strings.TrimSpace(strings.ToUpper(role))
+	if !validRoles[role] {
+		return "", fmt.Errorf("invalid role %q (expected USER or ADMIN)", role)
+	}
+	return role, nil
+}
+
+// readAndHashPassword validates the password, reading it from stdin when not
+// passed as a flag so it doesn't end up in shell history.
+func readAndHashPassword(passwords *utils.PasswordHashers, password string) (string, error) {
+	if password == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		password = line
+	}
+
+	password = strings.TrimSpace(password)
+	if !utils.ValidatePassword(password) {
+		return "", errors.New("password must be at least 8 characters long")
+	}
+
+	passwordHash, err := passwords.Hash(password)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return passwordHash, nil
+}
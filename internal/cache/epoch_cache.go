@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxCachedEpochs bounds the local epoch cache; expired entries are swept once it is reached
+const maxCachedEpochs = 10000
+
+type cachedEpoch struct {
+	revokedAt time.Time
+	fetchedAt time.Time
+}
+
+// CachedTokenRevocationStore remembers user token epochs in process for ttl so the
+// per-request epoch check doesn't need a round trip to the underlying store.
+// Revocations made through this instance apply locally at once; those made
+// elsewhere take up to ttl to be seen.
+type CachedTokenRevocationStore struct {
+	TokenRevocationStore
+	ttl    time.Duration
+	mu     sync.Mutex
+	epochs map[string]cachedEpoch
+}
+
+func NewCachedTokenRevocationStore(store TokenRevocationStore, ttl time.Duration) *CachedTokenRevocationStore {
+	return &CachedTokenRevocationStore{
+		TokenRevocationStore: store,
+		ttl:                  ttl,
+		epochs:               make(map[string]cachedEpoch),
+	}
+}
+
+func (s *CachedTokenRevocationStore) RevokeUserTokens(ctx context.Context, userID string, revokedAt, expiryTime time.Time) error {
+	if err := s.TokenRevocationStore.RevokeUserTokens(ctx, userID, revokedAt, expiryTime); err != nil {
+		return err
+	}
+
+	s.remember(userID, revokedAt)
+	return nil
+}
+
+func (s *CachedTokenRevocationStore) UserTokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	if s.ttl <= 0 {
+		return s.TokenRevocationStore.UserTokensRevokedAt(ctx, userID)
+	}
+
+	s.mu.Lock()
+	epoch, exists := s.epochs[userID]
+	s.mu.Unlock()
+
+	if exists && time.Since(epoch.fetchedAt) < s.ttl {
+		return epoch.revokedAt, nil
+	}
+
+	revokedAt, err := s.TokenRevocationStore.UserTokensRevokedAt(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.remember(userID, revokedAt)
+	return revokedAt, nil
+}
+
+func (s *CachedTokenRevocationStore) remember(userID string, revokedAt time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.epochs) >= maxCachedEpochs {
+		for key, epoch := range s.epochs {
+			if now.Sub(epoch.fetchedAt) >= s.ttl {
+				delete(s.epochs, key)
+			}
+		}
+	}
+
+	// Never let a stale read replace a newer local revocation
+	if current, exists := s.epochs[userID]; exists && current.revokedAt.After(revokedAt) {
+		revokedAt = current.revokedAt
+	}
+
+	s.epochs[userID] = cachedEpoch{revokedAt: revokedAt, fetchedAt: now}
+}
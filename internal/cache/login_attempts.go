@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginFailuresPrefix = "login:failures:"
+	loginLockoutPrefix  = "login:lockout:"
+)
+
+// LoginAttemptStore counts failed logins per subject, such as an account or a
+// source IP, and holds the lockouts they lead to. Subjects are keyed by their
+// SHA-256 hash so emails and addresses don't appear in Redis.
+type LoginAttemptStore interface {
+	// ReserveLoginAttempt atomically counts an attempt as a failure before its
+	// password is checked, so parallel guesses can't all slip in before the
+	// first failure is recorded. A locked-out subject is refused without
+	// counting. The attempt that brings the failures to the policy's
+	// threshold locks the subject right away.
+	ReserveLoginAttempt(ctx context.Context, subject string, policy LoginLockoutPolicy) (*LoginAttemptReservation, error)
+	// ReleaseLoginAttempt takes back a reservation whose attempt did not fail,
+	// lifting the lockout it set
+	ReleaseLoginAttempt(ctx context.Context, subject string, reservation LoginAttemptReservation) error
+	// ClearLoginFailures forgets the subject's failures and lifts its lockout
+	ClearLoginFailures(ctx context.Context, subject string) error
+}
+
+// LoginLockoutPolicy locks a subject out once it has Threshold failures. The
+// first lockout lasts BaseLockout and every further failure doubles it, up to
+// MaxLockout. Failures are forgotten Window after the last one or after the
+// lockout ends.
+type LoginLockoutPolicy struct {
+	Threshold   int
+	Window      time.Duration
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+// LockoutDuration is the lockout for a subject with failures failures: none
+// below the threshold, then BaseLockout doubled per failure past it
+func (p LoginLockoutPolicy) LockoutDuration(failures int64) time.Duration {
+	if failures < int64(p.Threshold) {
+		return 0
+	}
+
+	duration := p.BaseLockout
+	for i := int64(p.Threshold); i < failures && duration < p.MaxLockout; i++ {
+		duration *= 2
+	}
+	return min(duration, p.MaxLockout)
+}
+
+// LoginAttemptReservation is the outcome of ReserveLoginAttempt
+type LoginAttemptReservation struct {
+	Allowed bool
+	// Remaining is how much longer a refused subject stays locked out
+	Remaining time.Duration
+	// Failures counts this attempt
+	Failures int64
+	// Lockout is set when this attempt locked the subject
+	Lockout time.Duration
+}
+
+// reserveLoginAttemptScript refuses locked subjects and otherwise counts the
+// attempt, locking the subject once it reaches the threshold
+var reserveLoginAttemptScript = redis.NewScript(`
+local remaining = redis.call('PTTL', KEYS[2])
+if remaining > 0 then
+	return {0, remaining, 0, 0}
+end
+
+local threshold = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local base = tonumber(ARGV[3])
+local max = tonumber(ARGV[4])
+
+local failures = redis.call('INCR', KEYS[1])
+local lockout = 0
+if failures >= threshold then
+	lockout = base
+	for i = 1, failures - threshold do
+		if lockout >= max then
+			break
+		end
+		lockout = lockout * 2
+	end
+	if lockout > max then
+		lockout = max
+	end
+	redis.call('SET', KEYS[2], '1', 'PX', lockout)
+end
+redis.call('PEXPIRE', KEYS[1], lockout + window)
+return {1, 0, failures, lockout}
+`)
+
+// releaseLoginAttemptScript uncounts a reserved attempt
+var releaseLoginAttemptScript = redis.NewScript(`
+local failures = redis.call('DECR', KEYS[1])
+if failures <= 0 then
+	redis.call('DEL', KEYS[1])
+end
+if ARGV[1] == '1' then
+	redis.call('DEL', KEYS[2])
+end
+return failures
+`)
+
+// RedisLoginAttemptStore keeps login failure counters and lockouts in Redis,
+// so they hold across replicas
+type RedisLoginAttemptStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisLoginAttemptStore(client *redis.Client, timeout time.Duration) *RedisLoginAttemptStore {
+	return &RedisLoginAttemptStore{client: client, timeout: timeout}
+}
+
+func (s *RedisLoginAttemptStore) ReserveLoginAttempt(ctx context.Context, subject string, policy LoginLockoutPolicy) (*LoginAttemptReservation, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	failuresKey, lockoutKey := loginAttemptKeys(subject)
+	values, err := reserveLoginAttemptScript.Run(ctx, s.client, []string{failuresKey, lockoutKey},
+		policy.Threshold, policy.Window.Milliseconds(), policy.BaseLockout.Milliseconds(), policy.MaxLockout.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve login attempt: %w", err)
+	}
+	if len(values) != 4 {
+		return nil, fmt.Errorf("unexpected login attempt reply %v", values)
+	}
+
+	return &LoginAttemptReservation{
+		Allowed:   values[0] == 1,
+		Remaining: time.Duration(values[1]) * time.Millisecond,
+		Failures:  values[2],
+		Lockout:   time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+func (s *RedisLoginAttemptStore) ReleaseLoginAttempt(ctx context.Context, subject string, reservation LoginAttemptReservation) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	liftLockout := "0"
+	if reservation.Lockout > 0 {
+		liftLockout = "1"
+	}
+
+	failuresKey, lockoutKey := loginAttemptKeys(subject)
+	if err := releaseLoginAttemptScript.Run(ctx, s.client, []string{failuresKey, lockoutKey}, liftLockout).Err(); err != nil {
+		return fmt.Errorf("failed to release login attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisLoginAttemptStore) ClearLoginFailures(ctx context.Context, subject string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	failuresKey, lockoutKey := loginAttemptKeys(subject)
+	if err := s.client.Del(ctx, failuresKey, lockoutKey).Err(); err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+
+	return nil
+}
+
+// loginAttemptKeys returns the keys of the subject's failure counter and lockout
+func loginAttemptKeys(subject string) (string, string) {
+	digest := sha256.Sum256([]byte(subject))
+	hash := hex.EncodeToString(digest[:])
+	return loginFailuresPrefix + hash, loginLockoutPrefix + hash
+}
+
+var (
+	_ LoginAttemptStore = (*RedisLoginAttemptStore)(nil)
+	_ LoginAttemptStore = (*MemoryLoginAttemptStore)(nil)
+)
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryTokenRevocationStore is an in-process TokenRevocationStore for tests and single-node development
+type MemoryTokenRevocationStore struct {
+	mu       sync.Mutex
+	revoked  map[string]time.Time
+	legacy   map[string]time.Time
+	families map[string]time.Time
+	users    map[string]userEpoch
+}
+
+type userEpoch struct {
+	revokedAt  time.Time
+	expiryTime time.Time
+}
+
+func NewMemoryTokenRevocationStore() *MemoryTokenRevocationStore {
+	return &MemoryTokenRevocationStore{
+		revoked:  make(map[string]time.Time),
+		legacy:   make(map[string]time.Time),
+		families: make(map[string]time.Time),
+		users:    make(map[string]userEpoch),
+	}
+}
+
+func (s *MemoryTokenRevocationStore) BlacklistAccessToken(ctx context.Context, tokenID string, expiryTime time.Time) error {
+	return s.blacklist(ctx, s.revoked, tokenID, expiryTime)
+}
+
+func (s *MemoryTokenRevocationStore) IsAccessTokenBlacklisted(ctx context.Context, tokenID string) (bool, error) {
+	return s.isBlacklisted(ctx, s.revoked, tokenID)
+}
+
+func (s *MemoryTokenRevocationStore) BlacklistLegacyAccessToken(ctx context.Context, tokenString string, expiryTime time.Time) error {
+	return s.blacklist(ctx, s.legacy, tokenString, expiryTime)
+}
+
+func (s *MemoryTokenRevocationStore) IsLegacyAccessTokenBlacklisted(ctx context.Context, tokenString string) (bool, error) {
+	return s.isBlacklisted(ctx, s.legacy, tokenString)
+}
+
+func (s *MemoryTokenRevocationStore) blacklist(ctx context.Context, entries map[string]time.Time, key string, expiryTime time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !time.Now().Before(expiryTime) {
+		// Token already expired, no need to blacklist
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeExpired()
+	entries[key] = expiryTime
+	return nil
+}
+
+func (s *MemoryTokenRevocationStore) isBlacklisted(ctx context.Context, entries map[string]time.Time, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return isLive(entries, key), nil
+}
+
+func (s *MemoryTokenRevocationStore) RevokeTokenFamily(ctx context.Context, familyID string, expiryTime time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !time.Now().Before(expiryTime) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeExpired()
+	s.families[familyID] = expiryTime
+	return nil
+}
+
+func (s *MemoryTokenRevocationStore) IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return isLive(s.families, familyID), nil
+}
+
+func (s *MemoryTokenRevocationStore) RevokeUserTokens(ctx context.Context, userID string, revokedAt, expiryTime time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !time.Now().Before(expiryTime) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeExpired()
+	s.users[userID] = userEpoch{revokedAt: revokedAt, expiryTime: expiryTime}
+	return nil
+}
+
+func (s *MemoryTokenRevocationStore) UserTokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	epoch, exists := s.users[userID]
+	if !exists || !time.Now().Before(epoch.expiryTime) {
+		delete(s.users, userID)
+		return time.Time{}, nil
+	}
+
+	return epoch.revokedAt, nil
+}
+
+// isLive reports whether key is present and unexpired, dropping it if it has expired
+func isLive(entries map[string]time.Time, key string) bool {
+	expiryTime, exists := entries[key]
+	if !exists {
+		return false
+	}
+
+	if !time.Now().Before(expiryTime) {
+		delete(entries, key)
+		return false
+	}
+
+	return true
+}
+
+// purgeExpired drops entries past their expiry, mirroring Redis TTL cleanup
+func (s *MemoryTokenRevocationStore) purgeExpired() {
+	now := time.Now()
+	for _, entries := range []map[string]time.Time{s.revoked, s.legacy, s.families} {
+		for key, expiryTime := range entries {
+			if !now.Before(expiryTime) {
+				delete(entries, key)
+			}
+		}
+	}
+	for userID, epoch := range s.users {
+		if !now.Before(epoch.expiryTime) {
+			delete(s.users, userID)
+		}
+	}
+}
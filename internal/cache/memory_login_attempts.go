@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryLoginAttemptSweepInterval is how often expired subjects are dropped
+const memoryLoginAttemptSweepInterval = time.Minute
+
+type loginAttempts struct {
+	failures    int64
+	expiresAt   time.Time
+	lockedUntil time.Time
+}
+
+// MemoryLoginAttemptStore is an in-process LoginAttemptStore for tests and single-node development
+type MemoryLoginAttemptStore struct {
+	mu        sync.Mutex
+	subjects  map[string]*loginAttempts
+	nextSweep time.Time
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		subjects: make(map[string]*loginAttempts),
+	}
+}
+
+func (s *MemoryLoginAttemptStore) ReserveLoginAttempt(ctx context.Context, subject string, policy LoginLockoutPolicy) (*LoginAttemptReservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	key, _ := loginAttemptKeys(subject)
+	attempts, exists := s.subjects[key]
+	if !exists || !now.Before(attempts.expiresAt) {
+		attempts = &loginAttempts{}
+		s.subjects[key] = attempts
+	}
+
+	if remaining := attempts.lockedUntil.Sub(now); remaining > 0 {
+		return &LoginAttemptReservation{Remaining: remaining}, nil
+	}
+
+	attempts.failures++
+	lockout := policy.LockoutDuration(attempts.failures)
+	if lockout > 0 {
+		attempts.lockedUntil = now.Add(lockout)
+	}
+	attempts.expiresAt = now.Add(lockout + policy.Window)
+
+	return &LoginAttemptReservation{
+		Allowed:  true,
+		Failures: attempts.failures,
+		Lockout:  lockout,
+	}, nil
+}
+
+func (s *MemoryLoginAttemptStore) ReleaseLoginAttempt(ctx context.Context, subject string, reservation LoginAttemptReservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, _ := loginAttemptKeys(subject)
+	attempts, exists := s.subjects[key]
+	if !exists {
+		return nil
+	}
+
+	attempts.failures--
+	if reservation.Lockout > 0 {
+		attempts.lockedUntil = time.Time{}
+	}
+	if attempts.failures <= 0 {
+		delete(s.subjects, key)
+	}
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) ClearLoginFailures(ctx context.Context, subject string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, _ := loginAttemptKeys(subject)
+	delete(s.subjects, key)
+	return nil
+}
+
+// sweep drops expired subjects at most once per interval, so an attempt
+// usually only touches its own subject; callers hold s.mu
+func (s *MemoryLoginAttemptStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(memoryLoginAttemptSweepInterval)
+
+	for key, attempts := range s.subjects {
+		if !now.Before(attempts.expiresAt) {
+			delete(s.subjects, key)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLoginAttemptStoreLocksAtThreshold(t *testing.T) {
+	store := NewMemoryLoginAttemptStore()
+	ctx := context.Background()
+	policy := LoginLockoutPolicy{Threshold: 2, Window: time.Minute, BaseLockout: time.Minute, MaxLockout: time.Hour}
+
+	first, err := store.ReserveLoginAttempt(ctx, "account:user@example.com", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Allowed || first.Lockout != 0 {
+		t.Fatalf("first reservation = %+v, want allowed without lockout", first)
+	}
+
+	second, err := store.ReserveLoginAttempt(ctx, "account:user@example.com", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Allowed || second.Lockout != time.Minute {
+		t.Fatalf("second reservation = %+v, want allowed with a one minute lockout", second)
+	}
+
+	third, err := store.ReserveLoginAttempt(ctx, "account:user@example.com", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Allowed || third.Remaining <= 0 {
+		t.Fatalf("third reservation = %+v, want refused during lockout", third)
+	}
+
+	if err := store.ReleaseLoginAttempt(ctx, "account:user@example.com", *second); err != nil {
+		t.Fatal(err)
+	}
+	again, err := store.ReserveLoginAttempt(ctx, "account:user@example.com", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Allowed || again.Failures != 2 {
+		t.Fatalf("reservation after release = %+v, want allowed at 2 failures", again)
+	}
+}
+
+func TestLoginLockoutPolicyDoubles(t *testing.T) {
+	policy := LoginLockoutPolicy{Threshold: 3, BaseLockout: time.Minute, MaxLockout: 5 * time.Minute}
+	for failures, want := range map[int64]time.Duration{
+		2: 0,
+		3: time.Minute,
+		4: 2 * time.Minute,
+		5: 4 * time.Minute,
+		6: 5 * time.Minute,
+		9: 5 * time.Minute,
+	} {
+		if got := policy.LockoutDuration(failures); got != want {
+			t.Errorf("LockoutDuration(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryMFAChallengeStore is an in-process MFAChallengeStore for tests and single-node development
+type MemoryMFAChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]MFAChallenge
+	failures   map[string]int64
+}
+
+func NewMemoryMFAChallengeStore() *MemoryMFAChallengeStore {
+	return &MemoryMFAChallengeStore{
+		challenges: make(map[string]MFAChallenge),
+		failures:   make(map[string]int64),
+	}
+}
+
+func (s *MemoryMFAChallengeStore) StoreMFAChallenge(ctx context.Context, token string, challenge MFAChallenge) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !time.Now().Before(challenge.ExpiresAt) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, stored := range s.challenges {
+		if !now.Before(stored.ExpiresAt) {
+			delete(s.challenges, key)
+			delete(s.failures, key)
+		}
+	}
+
+	key, _ := mfaChallengeKeys(token)
+	s.challenges[key] = challenge
+	return nil
+}
+
+func (s *MemoryMFAChallengeStore) GetMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, _ := mfaChallengeKeys(token)
+	return s.current(key), nil
+}
+
+func (s *MemoryMFAChallengeStore) ReserveMFAAttempt(ctx context.Context, token string) (*MFAChallenge, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, _ := mfaChallengeKeys(token)
+	challenge := s.current(key)
+	if challenge == nil {
+		return nil, 0, nil
+	}
+
+	s.failures[key]++
+	return challenge, s.failures[key], nil
+}
+
+func (s *MemoryMFAChallengeStore) ConsumeMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, _ := mfaChallengeKeys(token)
+	challenge := s.current(key)
+	delete(s.challenges, key)
+	delete(s.failures, key)
+	return challenge, nil
+}
+
+func (s *MemoryMFAChallengeStore) DeleteMFAChallenge(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, _ := mfaChallengeKeys(token)
+	delete(s.challenges, key)
+	delete(s.failures, key)
+	return nil
+}
+
+// current returns the unexpired challenge stored under key; callers hold mu
+func (s *MemoryMFAChallengeStore) current(key string) *MFAChallenge {
+	challenge, exists := s.challenges[key]
+	if !exists {
+		return nil
+	}
+
+	if !time.Now().Before(challenge.ExpiresAt) {
+		delete(s.challenges, key)
+		delete(s.failures, key)
+		return nil
+	}
+
+	return &challenge
+}
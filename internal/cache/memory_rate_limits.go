@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryRateLimitSweepInterval is how often keys whose window has passed are dropped
+const memoryRateLimitSweepInterval = time.Minute
+
+type memoryRateLimitWindow struct {
+	requests []time.Time
+	window   time.Duration
+}
+
+// MemoryRateLimiter is an in-process RateLimiter for tests and single-node
+// deployments; each replica running it enforces its own limits
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryRateLimitWindow
+	nextSweep time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		windows: make(map[string]*memoryRateLimitWindow),
+	}
+}
+
+func (l *MemoryRateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*RateLimitResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	stored := rateLimitKey(key)
+	entry, ok := l.windows[stored]
+	if !ok {
+		entry = &memoryRateLimitWindow{}
+		l.windows[stored] = entry
+	}
+	entry.window = window
+
+	start := now.Add(-window)
+	for len(entry.requests) > 0 && !entry.requests[0].After(start) {
+		entry.requests = entry.requests[1:]
+	}
+
+	allowed := len(entry.requests) < limit
+	if allowed {
+		entry.requests = append(entry.requests, now)
+	}
+	if len(entry.requests) == 0 {
+		delete(l.windows, stored)
+	}
+
+	reset := window
+	if len(entry.requests) > 0 {
+		reset = entry.requests[0].Add(window).Sub(now)
+	}
+
+	return &RateLimitResult{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: max(limit-len(entry.requests), 0),
+		Reset:     reset,
+	}, nil
+}
+
+// sweep drops keys whose latest request has left its window. It runs at most
+// once per interval, so a request usually only touches its own key.
+func (l *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(memoryRateLimitSweepInterval)
+
+	for stored, entry := range l.windows {
+		if len(entry.requests) == 0 || !entry.requests[len(entry.requests)-1].Add(entry.window).After(now) {
+			delete(l.windows, stored)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimiterSlidingWindow(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "login:ip:a", 2, 50*time.Millisecond)
+		if err != nil || !result.Allowed {
+			t.Fatalf("request %d: allowed=%v err=%v", i, result != nil && result.Allowed, err)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "login:ip:a", 2, 50*time.Millisecond)
+	if err != nil || result.Allowed || result.Remaining != 0 {
+		t.Fatalf("third request: %+v, %v", result, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	result, err = limiter.Allow(ctx, "login:ip:a", 2, 50*time.Millisecond)
+	if err != nil || !result.Allowed {
+		t.Fatalf("after the window: %+v, %v", result, err)
+	}
+}
+
+func TestMemoryRateLimiterSweepKeepsLongerWindows(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	ctx := context.Background()
+
+	if _, err := limiter.Allow(ctx, "register:ip:a", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limiter.Allow(ctx, "login:ip:b", 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	limiter.mu.Lock()
+	limiter.nextSweep = time.Time{}
+	limiter.mu.Unlock()
+
+	if _, err := limiter.Allow(ctx, "login:ip:c", 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	limiter.mu.Lock()
+	_, registerKept := limiter.windows[rateLimitKey("register:ip:a")]
+	_, loginKept := limiter.windows[rateLimitKey("login:ip:b")]
+	limiter.mu.Unlock()
+	if !registerKept || loginKept {
+		t.Fatalf("registerKept=%v loginKept=%v, want only the expired login key swept", registerKept, loginKept)
+	}
+
+	result, err := limiter.Allow(ctx, "register:ip:a", 1, time.Hour)
+	if err != nil || result.Allowed {
+		t.Fatalf("register limit was forgotten: %+v, %v", result, err)
+	}
+}
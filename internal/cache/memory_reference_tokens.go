@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryReferenceTokenStore is an in-process ReferenceTokenStore for tests and single-node development
+type MemoryReferenceTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]ReferenceToken
+}
+
+func NewMemoryReferenceTokenStore() *MemoryReferenceTokenStore {
+	return &MemoryReferenceTokenStore{
+		tokens: make(map[string]ReferenceToken),
+	}
+}
+
+func (s *MemoryReferenceTokenStore) StoreReferenceToken(ctx context.Context, token string, claims ReferenceToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !time.Now().Before(claims.ExpiresAt) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, stored := range s.tokens {
+		if !now.Before(stored.ExpiresAt) {
+			delete(s.tokens, key)
+		}
+	}
+
+	s.tokens[referenceTokenKey(token)] = claims
+	return nil
+}
+
+func (s *MemoryReferenceTokenStore) GetReferenceToken(ctx context.Context, token string) (*ReferenceToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := referenceTokenKey(token)
+	claims, exists := s.tokens[key]
+	if !exists {
+		return nil, nil
+	}
+
+	if !time.Now().Before(claims.ExpiresAt) {
+		delete(s.tokens, key)
+		return nil, nil
+	}
+
+	return &claims, nil
+}
+
+func (s *MemoryReferenceTokenStore) DeleteReferenceToken(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, referenceTokenKey(token))
+	return nil
+}
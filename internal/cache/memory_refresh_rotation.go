@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type rotationEntry struct {
+	pair       RotatedTokenPair
+	expiryTime time.Time
+}
+
+// MemoryRefreshRotationCache is an in-process RefreshRotationCache for tests and single-node development
+type MemoryRefreshRotationCache struct {
+	mu      sync.Mutex
+	entries map[string]rotationEntry
+}
+
+func NewMemoryRefreshRotationCache() *MemoryRefreshRotationCache {
+	return &MemoryRefreshRotationCache{
+		entries: make(map[string]rotationEntry),
+	}
+}
+
+func (c *MemoryRefreshRotationCache) StoreRotation(ctx context.Context, oldTokenHash string, pair RotatedTokenPair, expiryTime time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !time.Now().Before(expiryTime) {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiryTime) {
+			delete(c.entries, key)
+		}
+	}
+
+	c.entries[oldTokenHash] = rotationEntry{pair: pair, expiryTime: expiryTime}
+	return nil
+}
+
+func (c *MemoryRefreshRotationCache) GetRotation(ctx context.Context, oldTokenHash string) (*RotatedTokenPair, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[oldTokenHash]
+	if !exists {
+		return nil, nil
+	}
+
+	if !time.Now().Before(entry.expiryTime) {
+		delete(c.entries, oldTokenHash)
+		return nil, nil
+	}
+
+	pair := entry.pair
+	return &pair, nil
+}
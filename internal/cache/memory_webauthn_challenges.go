@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryWebAuthnChallengeStore is an in-process WebAuthnChallengeStore for tests and single-node development
+type MemoryWebAuthnChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]WebAuthnSession
+}
+
+func NewMemoryWebAuthnChallengeStore() *MemoryWebAuthnChallengeStore {
+	return &MemoryWebAuthnChallengeStore{
+		challenges: make(map[string]WebAuthnSession),
+	}
+}
+
+func (s *MemoryWebAuthnChallengeStore) StoreWebAuthnChallenge(ctx context.Context, challenge string, session WebAuthnSession) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, stored := range s.challenges {
+		if !now.Before(stored.ExpiresAt) {
+			delete(s.challenges, key)
+		}
+	}
+
+	s.challenges[webAuthnChallengeKey(challenge)] = session
+	return nil
+}
+
+func (s *MemoryWebAuthnChallengeStore) ConsumeWebAuthnChallenge(ctx context.Context, challenge string) (*WebAuthnSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := webAuthnChallengeKey(challenge)
+	session, exists := s.challenges[key]
+	if !exists {
+		return nil, nil
+	}
+	delete(s.challenges, key)
+
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil, nil
+	}
+
+	return &session, nil
+}
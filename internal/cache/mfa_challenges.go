@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	mfaChallengePrefix         = "mfa:challenge:"
+	mfaChallengeAttemptsPrefix = "mfa:challenge_attempts:"
+)
+
+// MFAChallenge is a login that passed the password check and waits for a
+// second factor. It carries what is needed to start the session afterwards.
+type MFAChallenge struct {
+	UserID     string    `json:"user_id"`
+	ClientID   string    `json:"client_id,omitempty"`
+	DeviceName string    `json:"device_name,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	ExpiresAt  time.Time `json:"exp"`
+}
+
+// MFAChallengeStore holds pending MFA logins until they expire. Challenge
+// tokens are keyed by their SHA-256 hash, like reference tokens.
+type MFAChallengeStore interface {
+	StoreMFAChallenge(ctx context.Context, token string, challenge MFAChallenge) error
+	// GetMFAChallenge returns nil without an error for unknown and expired challenges
+	GetMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error)
+	// ReserveMFAAttempt atomically counts an attempt at the challenge before
+	// its factor is checked, and returns the challenge with the number of
+	// attempts so far. Unknown and expired challenges return nil.
+	ReserveMFAAttempt(ctx context.Context, token string) (*MFAChallenge, int64, error)
+	// ConsumeMFAChallenge removes the challenge and returns it, so only one
+	// request can complete it; it returns nil if it is already gone
+	ConsumeMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error)
+	DeleteMFAChallenge(ctx context.Context, token string) error
+}
+
+// reserveMFAAttemptScript counts an attempt only while the challenge exists;
+// the counter expires with the challenge it belongs to
+var reserveMFAAttemptScript = redis.NewScript(`
+local challenge = redis.call('GET', KEYS[1])
+if not challenge then
+	return false
+end
+local attempts = redis.call('INCR', KEYS[2])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return {challenge, attempts}
+`)
+
+// RedisMFAChallengeStore keeps pending MFA logins in Redis
+type RedisMFAChallengeStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisMFAChallengeStore(client *redis.Client, timeout time.Duration) *RedisMFAChallengeStore {
+	return &RedisMFAChallengeStore{client: client, timeout: timeout}
+}
+
+func (s *RedisMFAChallengeStore) StoreMFAChallenge(ctx context.Context, token string, challenge MFAChallenge) error {
+	ttl := time.Until(challenge.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(challenge)
+	if err != nil {
+		return fmt.Errorf("failed to encode mfa challenge: %w", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	key, _ := mfaChallengeKeys(token)
+	if err := s.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store mfa challenge: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisMFAChallengeStore) GetMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	key, _ := mfaChallengeKeys(token)
+	value, err := s.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get mfa challenge: %w", err)
+	}
+
+	var challenge MFAChallenge
+	if err := json.Unmarshal(value, &challenge); err != nil {
+		return nil, fmt.Errorf("failed to decode mfa challenge: %w", err)
+	}
+
+	return &challenge, nil
+}
+
+func (s *RedisMFAChallengeStore) ReserveMFAAttempt(ctx context.Context, token string) (*MFAChallenge, int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	key, attemptsKey := mfaChallengeKeys(token)
+	reply, err := reserveMFAAttemptScript.Run(ctx, s.client, []string{key, attemptsKey}).Slice()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to reserve mfa attempt: %w", err)
+	}
+
+	if len(reply) != 2 {
+		return nil, 0, fmt.Errorf("unexpected mfa attempt reply %v", reply)
+	}
+	value, ok := reply[0].(string)
+	attempts, okAttempts := reply[1].(int64)
+	if !ok || !okAttempts {
+		return nil, 0, fmt.Errorf("unexpected mfa attempt reply %v", reply)
+	}
+
+	var challenge MFAChallenge
+	if err := json.Unmarshal([]byte(value), &challenge); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode mfa challenge: %w", err)
+	}
+
+	return &challenge, attempts, nil
+}
+
+func (s *RedisMFAChallengeStore) ConsumeMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	key, attemptsKey := mfaChallengeKeys(token)
+	pipe := s.client.TxPipeline()
+	get := pipe.GetDel(ctx, key)
+	pipe.Del(ctx, attemptsKey)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to consume mfa challenge: %w", err)
+	}
+
+	value, err := get.Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume mfa challenge: %w", err)
+	}
+
+	var challenge MFAChallenge
+	if err := json.Unmarshal(value, &challenge); err != nil {
+		return nil, fmt.Errorf("failed to decode mfa challenge: %w", err)
+	}
+
+	return &challenge, nil
+}
+
+func (s *RedisMFAChallengeStore) DeleteMFAChallenge(ctx context.Context, token string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	key, attemptsKey := mfaChallengeKeys(token)
+	if err := s.client.Del(ctx, key, attemptsKey).Err(); err != nil {
+		return fmt.Errorf("failed to delete mfa challenge: %w", err)
+	}
+
+	return nil
+}
+
+// mfaChallengeKeys returns the keys of the challenge and of its failure counter
+func mfaChallengeKeys(token string) (string, string) {
+	digest := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(digest[:])
+	return mfaChallengePrefix + hash, mfaChallengeAttemptsPrefix + hash
+}
+
+var (
+	_ MFAChallengeStore = (*RedisMFAChallengeStore)(nil)
+	_ MFAChallengeStore = (*MemoryMFAChallengeStore)(nil)
+)
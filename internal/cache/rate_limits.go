@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const rateLimitPrefix = "ratelimit:"
+
+// RateLimitResult describes a subject's quota after a request was counted
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the oldest counted request leaves the window,
+	// freeing a slot
+	Reset time.Duration
+}
+
+// RateLimiter counts requests per key in a sliding window. Keys are stored by
+// their SHA-256 hash so IP addresses and client IDs don't appear in Redis.
+type RateLimiter interface {
+	// Allow counts a request against key unless limit requests were already
+	// counted in the last window, in which case it is refused and not counted
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (*RateLimitResult, error)
+}
+
+// slidingWindowScript keeps one sorted-set member per counted request, scored
+// by its time in milliseconds, so the window slides instead of resetting
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+// RedisRateLimiter keeps request windows in Redis, so limits hold across replicas
+type RedisRateLimiter struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisRateLimiter(client *redis.Client, timeout time.Duration) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client, timeout: timeout}
+}
+
+func (l *RedisRateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*RateLimitResult, error) {
+	// Requests in the same millisecond still need distinct members
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate rate limit nonce: %w", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, l.timeout)
+	defer cancel()
+
+	now := time.Now().UnixMilli()
+	member := strconv.FormatInt(now, 10) + "-" + hex.EncodeToString(nonce)
+	values, err := slidingWindowScript.Run(ctx, l.client, []string{rateLimitKey(key)},
+		now, window.Milliseconds(), limit, member,
+	).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", values)
+	}
+
+	return &RateLimitResult{
+		Allowed:   values[0] == 1,
+		Limit:     limit,
+		Remaining: max(limit-int(values[1]), 0),
+		Reset:     time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+func rateLimitKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return rateLimitPrefix + hex.EncodeToString(digest[:])
+}
+
+var (
+	_ RateLimiter = (*RedisRateLimiter)(nil)
+	_ RateLimiter = (*MemoryRateLimiter)(nil)
+)
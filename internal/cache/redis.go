@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
@@ -11,33 +12,39 @@ import (
 	"github.com/randhir/aegis-core/internal/logger"
 )
 
-var Client *redis.Client
-
-func ConnectRedis() error {
-	cfg := config.AppConfig.Redis
-
-	Client = redis.NewClient(&redis.Options{
+func ConnectRedis(cfg config.RedisConfig) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
 		Addr:     cfg.Addr,
 		Password: cfg.Password,
 		DB:       0,
 	})
 
-	ctx := context.Background()
-	if err := Client.Ping(ctx).Err(); err != nil {
-		return fmt.Errorf("failed to ping redis: %w", err)
+	ctx, cancel := withTimeout(context.Background(), cfg.OperationTimeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
 	}
 
 	logger.Info("Redis connected successfully",
 		zap.String("addr", cfg.Addr),
 	)
 
-	return nil
+	return client, nil
 }
 
-func CloseRedis() error {
-	if Client != nil {
-		return Client.Close()
+func CloseRedis(client *redis.Client) error {
+	if client != nil {
+		return client.Close()
 	}
 	return nil
 }
 
+// withTimeout bounds a single Redis operation; a zero timeout only inherits the caller's deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const referenceTokenPrefix = "reference:access_token:"
+
+// ReferenceToken holds the claims behind an opaque access token, which carries
+// none of them itself
+type ReferenceToken struct {
+	TokenID   string    `json:"jti"`
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	FamilyID  string    `json:"family_id,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// ReferenceTokenStore maps opaque access tokens to their claims until they
+// expire. Tokens are keyed by their SHA-256 hash, so bearer tokens never appear
+// in key names.
+type ReferenceTokenStore interface {
+	StoreReferenceToken(ctx context.Context, token string, claims ReferenceToken) error
+	// GetReferenceToken returns nil without an error for unknown, revoked and expired tokens
+	GetReferenceToken(ctx context.Context, token string) (*ReferenceToken, error)
+	DeleteReferenceToken(ctx context.Context, token string) error
+}
+
+// RedisReferenceTokenStore keeps opaque access tokens in Redis
+type RedisReferenceTokenStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisReferenceTokenStore(client *redis.Client, timeout time.Duration) *RedisReferenceTokenStore {
+	return &RedisReferenceTokenStore{client: client, timeout: timeout}
+}
+
+func (s *RedisReferenceTokenStore) StoreReferenceToken(ctx context.Context, token string, claims ReferenceToken) error {
+	ttl := time.Until(claims.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(claims)
+	if err != nil {
+		return fmt.Errorf("failed to encode reference token: %w", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	err = s.client.Set(ctx, referenceTokenKey(token), value, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store reference token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisReferenceTokenStore) GetReferenceToken(ctx context.Context, token string) (*ReferenceToken, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	value, err := s.client.Get(ctx, referenceTokenKey(token)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get reference token: %w", err)
+	}
+
+	var claims ReferenceToken
+	if err := json.Unmarshal(value, &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode reference token: %w", err)
+	}
+
+	return &claims, nil
+}
+
+func (s *RedisReferenceTokenStore) DeleteReferenceToken(ctx context.Context, token string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, referenceTokenKey(token)).Err(); err != nil {
+		return fmt.Errorf("failed to delete reference token: %w", err)
+	}
+
+	return nil
+}
+
+func referenceTokenKey(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return referenceTokenPrefix + hex.EncodeToString(digest[:])
+}
+
+var (
+	_ ReferenceTokenStore = (*RedisReferenceTokenStore)(nil)
+	_ ReferenceTokenStore = (*MemoryReferenceTokenStore)(nil)
+)
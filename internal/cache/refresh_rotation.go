@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const rotationPrefix = "rotation:refresh_token:"
+
+// RotatedTokenPair is the access and refresh token issued when a refresh token was rotated
+type RotatedTokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshRotationCache briefly remembers the pair issued for a rotated refresh token,
+// keyed by the old token's hash, so concurrent duplicate refreshes get the same answer
+type RefreshRotationCache interface {
+	StoreRotation(ctx context.Context, oldTokenHash string, pair RotatedTokenPair, expiryTime time.Time) error
+	// GetRotation returns nil without an error when no rotation is remembered
+	GetRotation(ctx context.Context, oldTokenHash string) (*RotatedTokenPair, error)
+}
+
+// RedisRefreshRotationCache keeps rotation results in Redis
+type RedisRefreshRotationCache struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisRefreshRotationCache(client *redis.Client, timeout time.Duration) *RedisRefreshRotationCache {
+	return &RedisRefreshRotationCache{client: client, timeout: timeout}
+}
+
+func (c *RedisRefreshRotationCache) StoreRotation(ctx context.Context, oldTokenHash string, pair RotatedTokenPair, expiryTime time.Time) error {
+	ttl := time.Until(expiryTime)
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(pair)
+	if err != nil {
+		return fmt.Errorf("failed to encode rotated token pair: %w", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
+	err = c.client.Set(ctx, rotationPrefix+oldTokenHash, value, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store rotated token pair: %w", err)
+	}
+
+	return nil
+}
+
+func (c *RedisRefreshRotationCache) GetRotation(ctx context.Context, oldTokenHash string) (*RotatedTokenPair, error) {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
+	value, err := c.client.Get(ctx, rotationPrefix+oldTokenHash).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get rotated token pair: %w", err)
+	}
+
+	var pair RotatedTokenPair
+	if err := json.Unmarshal(value, &pair); err != nil {
+		return nil, fmt.Errorf("failed to decode rotated token pair: %w", err)
+	}
+
+	return &pair, nil
+}
+
+var (
+	_ RefreshRotationCache = (*RedisRefreshRotationCache)(nil)
+	_ RefreshRotationCache = (*MemoryRefreshRotationCache)(nil)
+)
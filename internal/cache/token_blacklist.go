@@ -4,20 +4,68 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
-const blacklistPrefix = "blacklist:access_token:"
+const (
+	blacklistPrefix       = "blacklist:jti:"
+	legacyBlacklistPrefix = "blacklist:access_token:"
+	revokedFamilyPrefix   = "revoked:token_family:"
+	userEpochPrefix       = "revoked:user_tokens:"
+)
 
-// BlacklistAccessToken adds an access token to the Redis blacklist with TTL equal to token expiry
-func BlacklistAccessToken(tokenString string, expiryTime time.Time) error {
-	if Client == nil {
-		return errors.New("redis client not initialized")
-	}
+// TokenRevocationStore tracks access tokens that were revoked before they expired
+type TokenRevocationStore interface {
+	// BlacklistAccessToken revokes a single access token by its jti
+	BlacklistAccessToken(ctx context.Context, tokenID string, expiryTime time.Time) error
+	IsAccessTokenBlacklisted(ctx context.Context, tokenID string) (bool, error)
+	// BlacklistLegacyAccessToken revokes an access token keyed by the full token
+	// string, for tokens issued before access tokens carried a jti
+	BlacklistLegacyAccessToken(ctx context.Context, tokenString string, expiryTime time.Time) error
+	IsLegacyAccessTokenBlacklisted(ctx context.Context, tokenString string) (bool, error)
+	RevokeTokenFamily(ctx context.Context, familyID string, expiryTime time.Time) error
+	IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error)
+	// RevokeUserTokens rejects every access token issued to the user up to revokedAt.
+	// The record may be dropped at expiryTime, once all such tokens have expired.
+	RevokeUserTokens(ctx context.Context, userID string, revokedAt, expiryTime time.Time) error
+	// UserTokensRevokedAt returns the user's latest revocation time, or the zero time if none is live
+	UserTokensRevokedAt(ctx context.Context, userID string) (time.Time, error)
+}
+
+// RedisTokenRevocationStore keeps the access token blacklist in Redis
+type RedisTokenRevocationStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisTokenRevocationStore(client *redis.Client, timeout time.Duration) *RedisTokenRevocationStore {
+	return &RedisTokenRevocationStore{client: client, timeout: timeout}
+}
 
-	ctx := context.Background()
-	key := blacklistPrefix + tokenString
+// BlacklistAccessToken adds an access token's jti to the Redis blacklist with TTL equal to token expiry
+func (s *RedisTokenRevocationStore) BlacklistAccessToken(ctx context.Context, tokenID string, expiryTime time.Time) error {
+	return s.blacklist(ctx, blacklistPrefix+tokenID, expiryTime)
+}
+
+// IsAccessTokenBlacklisted checks if an access token's jti is in the Redis blacklist
+func (s *RedisTokenRevocationStore) IsAccessTokenBlacklisted(ctx context.Context, tokenID string) (bool, error) {
+	return s.isBlacklisted(ctx, blacklistPrefix+tokenID)
+}
+
+// BlacklistLegacyAccessToken adds a full access token to the Redis blacklist with TTL equal to token expiry
+func (s *RedisTokenRevocationStore) BlacklistLegacyAccessToken(ctx context.Context, tokenString string, expiryTime time.Time) error {
+	return s.blacklist(ctx, legacyBlacklistPrefix+tokenString, expiryTime)
+}
+
+// IsLegacyAccessTokenBlacklisted checks if a full access token is in the Redis blacklist
+func (s *RedisTokenRevocationStore) IsLegacyAccessTokenBlacklisted(ctx context.Context, tokenString string) (bool, error) {
+	return s.isBlacklisted(ctx, legacyBlacklistPrefix+tokenString)
+}
 
+func (s *RedisTokenRevocationStore) blacklist(ctx context.Context, key string, expiryTime time.Time) error {
 	// Calculate TTL from now until expiry
 	ttl := time.Until(expiryTime)
 	if ttl <= 0 {
@@ -25,7 +73,10 @@ func BlacklistAccessToken(tokenString string, expiryTime time.Time) error {
 		return nil
 	}
 
-	err := Client.Set(ctx, key, "1", ttl).Err()
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	err := s.client.Set(ctx, key, "1", ttl).Err()
 	if err != nil {
 		return fmt.Errorf("failed to blacklist token: %w", err)
 	}
@@ -33,20 +84,99 @@ func BlacklistAccessToken(tokenString string, expiryTime time.Time) error {
 	return nil
 }
 
-// IsAccessTokenBlacklisted checks if an access token is in the Redis blacklist
-func IsAccessTokenBlacklisted(tokenString string) (bool, error) {
-	if Client == nil {
-		return false, errors.New("redis client not initialized")
+func (s *RedisTokenRevocationStore) isBlacklisted(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	exists, err := s.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check token blacklist: %w", err)
 	}
 
-	ctx := context.Background()
-	key := blacklistPrefix + tokenString
+	return exists > 0, nil
+}
+
+// RevokeTokenFamily rejects every access token issued to a refresh token family until expiryTime,
+// which should be the latest expiry of any access token issued to it
+func (s *RedisTokenRevocationStore) RevokeTokenFamily(ctx context.Context, familyID string, expiryTime time.Time) error {
+	key := revokedFamilyPrefix + familyID
 
-	exists, err := Client.Exists(ctx, key).Result()
+	ttl := time.Until(expiryTime)
+	if ttl <= 0 {
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	err := s.client.Set(ctx, key, "1", ttl).Err()
 	if err != nil {
-		return false, fmt.Errorf("failed to check token blacklist: %w", err)
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+
+	return nil
+}
+
+// IsTokenFamilyRevoked checks if a refresh token family has been revoked
+func (s *RedisTokenRevocationStore) IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	key := revokedFamilyPrefix + familyID
+
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	exists, err := s.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check token family revocation: %w", err)
 	}
 
 	return exists > 0, nil
 }
 
+// RevokeUserTokens stores the revocation time as the user's token epoch
+func (s *RedisTokenRevocationStore) RevokeUserTokens(ctx context.Context, userID string, revokedAt, expiryTime time.Time) error {
+	key := userEpochPrefix + userID
+
+	ttl := time.Until(expiryTime)
+	if ttl <= 0 {
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	err := s.client.Set(ctx, key, strconv.FormatInt(revokedAt.UnixNano(), 10), ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+
+	return nil
+}
+
+// UserTokensRevokedAt reads the user's token epoch from Redis
+func (s *RedisTokenRevocationStore) UserTokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	key := userEpochPrefix + userID
+
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	value, err := s.client.Get(ctx, key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get user token epoch: %w", err)
+	}
+
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid user token epoch %q: %w", value, err)
+	}
+
+	return time.Unix(0, nanos), nil
+}
+
+var (
+	_ TokenRevocationStore = (*RedisTokenRevocationStore)(nil)
+	_ TokenRevocationStore = (*MemoryTokenRevocationStore)(nil)
+	_ TokenRevocationStore = (*CachedTokenRevocationStore)(nil)
+)
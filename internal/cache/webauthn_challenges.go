@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const webAuthnChallengePrefix = "webauthn:challenge:"
+
+// WebAuthn ceremonies a challenge can be issued for
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnLogin        = "login"
+	WebAuthnMFA          = "mfa"
+)
+
+// WebAuthnSession records what a WebAuthn challenge was issued for. UserID is
+// empty for passwordless logins, where the credential names the user.
+type WebAuthnSession struct {
+	Purpose   string    `json:"purpose"`
+	UserID    string    `json:"user_id,omitempty"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// WebAuthnChallengeStore holds issued WebAuthn challenges until they are
+// answered or expire. Each challenge can be consumed only once.
+type WebAuthnChallengeStore interface {
+	StoreWebAuthnChallenge(ctx context.Context, challenge string, session WebAuthnSession) error
+	// ConsumeWebAuthnChallenge removes and returns the session, or nil without
+	// an error for unknown and expired challenges
+	ConsumeWebAuthnChallenge(ctx context.Context, challenge string) (*WebAuthnSession, error)
+}
+
+// RedisWebAuthnChallengeStore keeps issued WebAuthn challenges in Redis
+type RedisWebAuthnChallengeStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisWebAuthnChallengeStore(client *redis.Client, timeout time.Duration) *RedisWebAuthnChallengeStore {
+	return &RedisWebAuthnChallengeStore{client: client, timeout: timeout}
+}
+
+func (s *RedisWebAuthnChallengeStore) StoreWebAuthnChallenge(ctx context.Context, challenge string, session WebAuthnSession) error {
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode webauthn challenge: %w", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if err := s.client.Set(ctx, webAuthnChallengeKey(challenge), value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store webauthn challenge: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisWebAuthnChallengeStore) ConsumeWebAuthnChallenge(ctx context.Context, challenge string) (*WebAuthnSession, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	value, err := s.client.GetDel(ctx, webAuthnChallengeKey(challenge)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to consume webauthn challenge: %w", err)
+	}
+
+	var session WebAuthnSession
+	if err := json.Unmarshal(value, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode webauthn challenge: %w", err)
+	}
+
+	return &session, nil
+}
+
+func webAuthnChallengeKey(challenge string) string {
+	digest := sha256.Sum256([]byte(challenge))
+	return webAuthnChallengePrefix + hex.EncodeToString(digest[:])
+}
+
+var (
+	_ WebAuthnChallengeStore = (*RedisWebAuthnChallengeStore)(nil)
+	_ WebAuthnChallengeStore = (*MemoryWebAuthnChallengeStore)(nil)
+)
@@ -1,44 +1,257 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	JWT      JWTConfig
+	Server       ServerConfig
+	Database     DatabaseConfig
+	Redis        RedisConfig
+	JWT          JWTConfig
+	Keyring      KeyringConfig
+	MFA          MFAConfig
+	WebAuthn     WebAuthnConfig
+	Lockout      LockoutConfig
+	RateLimit    RateLimitConfig
+	Registration RegistrationConfig
+	Password     PasswordConfig
 }
 
 type ServerConfig struct {
-	Port string
+	Port            string
+	ShutdownTimeout time.Duration
+	RequestTimeout  time.Duration
+	// TrustedProxies lists the proxy IPs or CIDRs whose X-Forwarded-For is
+	// believed; with none, the client IP is the connection's remote address
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	Name     string
+	Host         string
+	Port         string
+	User         string
+	Password     string
+	Name         string
+	AutoMigrate  bool
+	QueryTimeout time.Duration
 }
 
 type RedisConfig struct {
-	Addr     string
-	Password string
+	Addr             string
+	Password         string
+	OperationTimeout time.Duration
 }
 
 type JWTConfig struct {
-	AccessSecret  string
-	RefreshSecret string
+	// AccessAlgorithm is HS256 (with AccessSecret), RS256, ES256 or EdDSA
+	// (with a PEM private key in AccessPrivateKeyFile)
+	AccessAlgorithm      string
+	AccessPrivateKeyFile string
+	AccessSecret         string
+	// AccessTokenEncryption wraps access tokens in JWE: "dir" (A256GCM with the
+	// base64 AccessTokenEncryptionKey) or "RSA-OAEP"/"RSA-OAEP-256" (with a PEM
+	// private key in AccessTokenEncryptionKeyFile); empty leaves them unencrypted
+	AccessTokenEncryption        string
+	AccessTokenEncryptionKey     string
+	AccessTokenEncryptionKeyFile string
+	RefreshSecret                string
+	RefreshTokenHashKey          string
+	// RotationGracePeriod lets a duplicate refresh of a just-rotated token
+	// receive the same new pair instead of tripping reuse detection; 0 disables it
+	RotationGracePeriod time.Duration
+	// EpochCacheTTL is how long each instance caches a user's token revocation
+	// epoch, and so how long a revocation takes to reach other instances
+	EpochCacheTTL time.Duration
+	// Sessions is the token lifetime policy for users without a role override
+	Sessions SessionPolicy
+	// RolePolicies overrides parts of the session policy per role
+	RolePolicies map[string]SessionPolicy
+	// Issuer is stamped into every token as iss and required when validating
+	Issuer string
+	// Audience identifies this service's API; every access token carries it
+	// and it is required when validating them
+	Audience string
+	// ClientAudiences lists, per client ID, the additional services its access
+	// tokens are intended for
+	ClientAudiences map[string][]string
+	// TokenCodec is the format tokens are issued in: "jwt", "paseto-v4-local"
+	// or "paseto-v4-public" (access tokens only; refresh tokens use v4.local)
+	TokenCodec string
+	// AcceptedTokenCodecs are additional formats still accepted, for switching
+	// TokenCodec without invalidating tokens in flight
+	AcceptedTokenCodecs []string
+	// AccessTokenFormat is "jwt" for self-contained access tokens or "opaque"
+	// for random reference tokens whose claims stay in Redis
+	AccessTokenFormat string
+	// ClientAccessTokenFormats overrides AccessTokenFormat per client ID
+	ClientAccessTokenFormats map[string]string
+	// Leeway tolerates clock skew between nodes when checking exp, nbf and iat
+	Leeway time.Duration
+	// LegacyClaimsUntil accepts tokens issued without iss and aud until this
+	// time, so tokens issued before they were stamped stay valid during an
+	// upgrade; by default for the longest refresh token lifetime after startup
+	LegacyClaimsUntil time.Time
+	// LegacyBlacklistUntil keeps writing and checking the old full-token blacklist
+	// keys for every access token until this time, for rolling upgrades
+	LegacyBlacklistUntil time.Time
 }
 
-var AppConfig *Config
+// SessionPolicy bounds how long tokens and the sessions they belong to last
+type SessionPolicy struct {
+	// AccessTokenTTL is how long an access token stays valid after issue
+	AccessTokenTTL time.Duration
+	// IdleTimeout ends a session that hasn't refreshed for this long; each
+	// refresh token is valid for this long after issue
+	IdleTimeout time.Duration
+	// MaxLifetime ends a session this long after login, however often it
+	// refreshes; 0 disables the limit
+	MaxLifetime time.Duration
+}
+
+// SessionPolicy returns the policy for role, falling back to the default
+// policy for any lifetime the role doesn't override
+func (c JWTConfig) SessionPolicy(role string) SessionPolicy {
+	policy := c.Sessions
+	override, ok := c.RolePolicies[role]
+	if !ok {
+		return policy
+	}
+
+	if override.AccessTokenTTL > 0 {
+		policy.AccessTokenTTL = override.AccessTokenTTL
+	}
+	if override.IdleTimeout > 0 {
+		policy.IdleTimeout = override.IdleTimeout
+	}
+	if override.MaxLifetime > 0 {
+		policy.MaxLifetime = override.MaxLifetime
+	}
+	return policy
+}
+
+// MaxAccessTokenTTL is the longest any access token can stay valid, which is
+// how long revocations of access tokens must be kept
+func (c JWTConfig) MaxAccessTokenTTL() time.Duration {
+	longest := c.Sessions.AccessTokenTTL
+	for role := range c.RolePolicies {
+		longest = max(longest, c.SessionPolicy(role).AccessTokenTTL)
+	}
+	return longest
+}
+
+// MaxRefreshTokenTTL is the longest any refresh token can stay valid
+func (c JWTConfig) MaxRefreshTokenTTL() time.Duration {
+	longest := c.Sessions.IdleTimeout
+	for role := range c.RolePolicies {
+		longest = max(longest, c.SessionPolicy(role).IdleTimeout)
+	}
+	return longest
+}
+
+type KeyringConfig struct {
+	// EncryptionKey (base64, 32 bytes) seals key material stored in the database;
+	// the keyring is disabled when it is empty
+	EncryptionKey string
+	// ReloadInterval is how often each instance picks up staged, promoted and
+	// retired keys
+	ReloadInterval time.Duration
+}
+
+type MFAConfig struct {
+	// EncryptionKey (base64, 32 bytes) seals TOTP secrets and keys recovery code
+	// hashes; users can't enroll while it is empty
+	EncryptionKey string
+	// Issuer labels the account in authenticator apps
+	Issuer string
+	// ChallengeTTL is how long a login that passed the password check may take
+	// to supply its second factor
+	ChallengeTTL time.Duration
+	// MaxAttempts is how many wrong codes end a pending login
+	MaxAttempts int
+}
+
+type WebAuthnConfig struct {
+	// RPID is the domain passkeys are bound to; passkeys are disabled when it
+	// is empty
+	RPID string
+	// RPName is shown by authenticators when a passkey is created
+	RPName string
+	// Origins are the web origins allowed to use passkeys, https://<RPID> when
+	// none are listed
+	Origins []string
+	// ChallengeTTL is how long a passkey ceremony may take
+	ChallengeTTL time.Duration
+}
+
+type LockoutConfig struct {
+	// MaxAccountFailures and MaxIPFailures are how many failed logins lock out
+	// an account or a source IP; zero disables that lockout
+	MaxAccountFailures int
+	MaxIPFailures      int
+	// FailureWindow is how long failures are remembered after the last one
+	FailureWindow time.Duration
+	// BaseLockout is the first lockout; it doubles with every further failure
+	// up to MaxLockout
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+type RateLimitConfig struct {
+	// Store is "redis", shared by all replicas, or "memory" for single-node
+	// deployments
+	Store string
+	// Routes maps route names (register, login, refresh) to their limits
+	Routes map[string]RateLimitRule
+}
 
-func Load() error {
+// RateLimitRule allows Limit requests per Window for each IP, email or client;
+// a zero Limit disables it
+type RateLimitRule struct {
+	Limit  int
+	Window time.Duration
+	// By is what requests are counted by: "ip", "email" or "client"
+	By string
+}
+
+type RegistrationConfig struct {
+	// EnumerationSafe makes registration answer the same whether or not the
+	// email is taken; the owner learns the outcome from a notice instead
+	EnumerationSafe bool
+}
+
+type PasswordConfig struct {
+	// Algorithm hashes new passwords: "argon2id", "bcrypt" or "scrypt". Hashes
+	// made with another algorithm or weaker parameters are replaced at login.
+	Algorithm string
+	// Argon2Time is the number of passes, Argon2MemoryKiB the memory per hash
+	// and Argon2Threads the lanes
+	Argon2Time      int
+	Argon2MemoryKiB int
+	Argon2Threads   int
+	BcryptCost      int
+	// ScryptLogN is log2 of the CPU/memory cost N
+	ScryptLogN int
+	ScryptR    int
+	ScryptP    int
+	// HashWorkers is how many passwords are hashed at once; zero means half
+	// the CPUs
+	HashWorkers int
+	// HashQueueSize is how many hashes may wait for a worker before requests
+	// are refused with 503
+	HashQueueSize int
+}
+
+// Load reads configuration from .env and the environment
+func Load() (*Config, error) {
 	viper.SetConfigType("env")
 	viper.SetConfigName(".env")
 	viper.AddConfigPath(".")
@@ -49,32 +262,121 @@ func Load() error {
 
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return fmt.Errorf("error reading config file: %w", err)
+			return nil, fmt.Errorf("error reading config file: %w", err)
 		}
 	}
 
-	AppConfig = &Config{
+	var errs []error
+	cfg := &Config{
 		Server: ServerConfig{
-			Port: getEnvOrDefault("SERVER_PORT", "8080"),
+			Port:            getEnvOrDefault("SERVER_PORT", "8080"),
+			ShutdownTimeout: getDurationOrDefault(&errs, "SERVER_SHUTDOWN_TIMEOUT", 15*time.Second),
+			RequestTimeout:  getDurationOrDefault(&errs, "SERVER_REQUEST_TIMEOUT", 10*time.Second),
+			TrustedProxies:  getList("SERVER_TRUSTED_PROXIES"),
 		},
 		Database: DatabaseConfig{
-			Host:     getEnvOrDefault("DB_HOST", "localhost"),
-			Port:     getEnvOrDefault("DB_PORT", "5432"),
-			User:     getEnvOrDefault("DB_USER", "postgres"),
-			Password: getEnvOrDefault("DB_PASSWORD", ""),
-			Name:     getEnvOrDefault("DB_NAME", "aegis_core"),
+			Host:         getEnvOrDefault("DB_HOST", "localhost"),
+			Port:         getEnvOrDefault("DB_PORT", "5432"),
+			User:         getEnvOrDefault("DB_USER", "postgres"),
+			Password:     getEnvOrDefault("DB_PASSWORD", ""),
+			Name:         getEnvOrDefault("DB_NAME", "aegis_core"),
+			AutoMigrate:  getBoolOrDefault(&errs, "DB_AUTO_MIGRATE", false),
+			QueryTimeout: getDurationOrDefault(&errs, "DB_QUERY_TIMEOUT", 3*time.Second),
 		},
 		Redis: RedisConfig{
-			Addr:     getEnvOrDefault("REDIS_ADDR", "localhost:6379"),
-			Password: getEnvOrDefault("REDIS_PASSWORD", ""),
+			Addr:             getEnvOrDefault("REDIS_ADDR", "localhost:6379"),
+			Password:         getEnvOrDefault("REDIS_PASSWORD", ""),
+			OperationTimeout: getDurationOrDefault(&errs, "REDIS_OPERATION_TIMEOUT", time.Second),
 		},
 		JWT: JWTConfig{
-			AccessSecret:  getEnvOrDefault("JWT_ACCESS_SECRET", ""),
-			RefreshSecret: getEnvOrDefault("JWT_REFRESH_SECRET", ""),
+			AccessAlgorithm:              getEnvOrDefault("JWT_ACCESS_ALGORITHM", "HS256"),
+			AccessPrivateKeyFile:         getEnvOrDefault("JWT_ACCESS_PRIVATE_KEY_FILE", ""),
+			AccessSecret:                 getEnvOrDefault("JWT_ACCESS_SECRET", ""),
+			AccessTokenEncryption:        getEnvOrDefault("ACCESS_TOKEN_ENCRYPTION", ""),
+			AccessTokenEncryptionKey:     getEnvOrDefault("ACCESS_TOKEN_ENCRYPTION_KEY", ""),
+			AccessTokenEncryptionKeyFile: getEnvOrDefault("ACCESS_TOKEN_ENCRYPTION_KEY_FILE", ""),
+			RefreshSecret:                getEnvOrDefault("JWT_REFRESH_SECRET", ""),
+			RefreshTokenHashKey:          getEnvOrDefault("REFRESH_TOKEN_HASH_KEY", ""),
+			RotationGracePeriod:          getDurationOrDefault(&errs, "REFRESH_ROTATION_GRACE_PERIOD", 0),
+			EpochCacheTTL:                getDurationOrDefault(&errs, "TOKEN_EPOCH_CACHE_TTL", 5*time.Second),
+			Sessions: SessionPolicy{
+				AccessTokenTTL: getDurationOrDefault(&errs, "ACCESS_TOKEN_TTL", 15*time.Minute),
+				IdleTimeout:    getDurationOrDefault(&errs, "SESSION_IDLE_TIMEOUT", 7*24*time.Hour),
+				MaxLifetime:    getDurationOrDefault(&errs, "SESSION_MAX_LIFETIME", 30*24*time.Hour),
+			},
+			RolePolicies:             getRolePolicies(&errs, "SESSION_ROLE_POLICIES"),
+			Issuer:                   getEnvOrDefault("JWT_ISSUER", "aegis-core"),
+			Audience:                 getEnvOrDefault("JWT_AUDIENCE", "aegis-core-api"),
+			ClientAudiences:          getClientAudiences("JWT_CLIENT_AUDIENCES"),
+			TokenCodec:               getEnvOrDefault("TOKEN_CODEC", "jwt"),
+			AcceptedTokenCodecs:      getList("TOKEN_CODECS_ACCEPTED"),
+			AccessTokenFormat:        getEnvOrDefault("ACCESS_TOKEN_FORMAT", "jwt"),
+			ClientAccessTokenFormats: getStringMap("CLIENT_ACCESS_TOKEN_FORMATS"),
+			Leeway:                   getDurationOrDefault(&errs, "JWT_LEEWAY", 30*time.Second),
+			LegacyClaimsUntil:        getTimeOrDefault(&errs, "JWT_LEGACY_CLAIMS_UNTIL", time.Time{}),
+			LegacyBlacklistUntil:     getTimeOrDefault(&errs, "LEGACY_BLACKLIST_UNTIL", time.Time{}),
+		},
+		Keyring: KeyringConfig{
+			EncryptionKey:  getEnvOrDefault("KEYRING_ENCRYPTION_KEY", ""),
+			ReloadInterval: getDurationOrDefault(&errs, "KEYRING_RELOAD_INTERVAL", 30*time.Second),
+		},
+		MFA: MFAConfig{
+			EncryptionKey: getEnvOrDefault("MFA_ENCRYPTION_KEY", ""),
+			Issuer:        getEnvOrDefault("MFA_ISSUER", "AegisCore"),
+			ChallengeTTL:  getDurationOrDefault(&errs, "MFA_CHALLENGE_TTL", 5*time.Minute),
+			MaxAttempts:   getIntOrDefault(&errs, "MFA_MAX_ATTEMPTS", 5),
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID:         getEnvOrDefault("WEBAUTHN_RP_ID", ""),
+			RPName:       getEnvOrDefault("WEBAUTHN_RP_NAME", "AegisCore"),
+			Origins:      getList("WEBAUTHN_ORIGINS"),
+			ChallengeTTL: getDurationOrDefault(&errs, "WEBAUTHN_CHALLENGE_TTL", 5*time.Minute),
+		},
+		Lockout: LockoutConfig{
+			MaxAccountFailures: getIntOrDefault(&errs, "LOGIN_MAX_ACCOUNT_FAILURES", 5),
+			MaxIPFailures:      getIntOrDefault(&errs, "LOGIN_MAX_IP_FAILURES", 20),
+			FailureWindow:      getDurationOrDefault(&errs, "LOGIN_FAILURE_WINDOW", 15*time.Minute),
+			BaseLockout:        getDurationOrDefault(&errs, "LOGIN_LOCKOUT_BASE", time.Minute),
+			MaxLockout:         getDurationOrDefault(&errs, "LOGIN_LOCKOUT_MAX", time.Hour),
+		},
+		RateLimit: RateLimitConfig{
+			Store: getEnvOrDefault("RATE_LIMIT_STORE", "redis"),
+			Routes: getRateLimits(&errs, "RATE_LIMITS", map[string]RateLimitRule{
+				"register": {Limit: 10, Window: time.Hour, By: "ip"},
+				"login":    {Limit: 20, Window: time.Minute, By: "ip"},
+				"refresh":  {Limit: 60, Window: time.Minute, By: "ip"},
+				"mfa":      {Limit: 10, Window: time.Minute, By: "ip"},
+				"passkey":  {Limit: 30, Window: time.Minute, By: "ip"},
+			}),
+		},
+		Registration: RegistrationConfig{
+			EnumerationSafe: getBoolOrDefault(&errs, "REGISTRATION_ENUMERATION_SAFE", false),
 		},
+		Password: PasswordConfig{
+			Algorithm:       getEnvOrDefault("PASSWORD_HASH_ALGORITHM", "argon2id"),
+			Argon2Time:      getIntOrDefault(&errs, "PASSWORD_ARGON2_TIME", 3),
+			Argon2MemoryKiB: getIntOrDefault(&errs, "PASSWORD_ARGON2_MEMORY_KIB", 64*1024),
+			Argon2Threads:   getIntOrDefault(&errs, "PASSWORD_ARGON2_THREADS", 2),
+			BcryptCost:      getIntOrDefault(&errs, "PASSWORD_BCRYPT_COST", 12),
+			ScryptLogN:      getIntOrDefault(&errs, "PASSWORD_SCRYPT_LOG_N", 15),
+			ScryptR:         getIntOrDefault(&errs, "PASSWORD_SCRYPT_R", 8),
+			ScryptP:         getIntOrDefault(&errs, "PASSWORD_SCRYPT_P", 1),
+			HashWorkers:     getIntOrDefault(&errs, "PASSWORD_HASH_WORKERS", 0),
+			HashQueueSize:   getIntOrDefault(&errs, "PASSWORD_HASH_QUEUE_SIZE", 64),
+		},
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	return nil
+	// Unless set, tokens from before iss and aud were stamped stay valid for
+	// as long as the longest-lived of them, a refresh token, could still be
+	if getEnvOrDefault("JWT_LEGACY_CLAIMS_UNTIL", "") == "" {
+		cfg.JWT.LegacyClaimsUntil = time.Now().Add(cfg.JWT.MaxRefreshTokenTTL())
+	}
+
+	return cfg, nil
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -87,3 +389,208 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// The parsing helpers fall back to the default when a setting is unset, and
+// add to errs when it is set but invalid, so Load reports every bad setting
+// instead of quietly running with the default
+
+func getDurationOrDefault(errs *[]error, key string, defaultValue time.Duration) time.Duration {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return defaultValue
+	}
+	return duration
+}
+
+func getTimeOrDefault(errs *[]error, key string, defaultValue time.Time) time.Time {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return defaultValue
+	}
+	return parsed
+}
+
+func getIntOrDefault(errs *[]error, key string, defaultValue int) int {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return defaultValue
+	}
+	return parsed
+}
+
+func getBoolOrDefault(errs *[]error, key string, defaultValue bool) bool {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return defaultValue
+	}
+	return parsed
+}
+
+// getClientAudiences parses "client=aud1|aud2,other=aud3" into audiences per client
+func getClientAudiences(key string) map[string][]string {
+	audiences := make(map[string][]string)
+	for _, entry := range strings.Split(getEnvOrDefault(key, ""), ",") {
+		clientID, list, found := strings.Cut(entry, "=")
+		clientID = strings.TrimSpace(clientID)
+		if clientID == "" {
+			continue
+		}
+
+		audiences[clientID] = []string{}
+		if !found {
+			continue
+		}
+		for _, audience := range strings.Split(list, "|") {
+			if audience = strings.TrimSpace(audience); audience != "" {
+				audiences[clientID] = append(audiences[clientID], audience)
+			}
+		}
+	}
+	return audiences
+}
+
+// getList parses a comma-separated list
+func getList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnvOrDefault(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getStringMap parses "key=value,other=value" into a map
+func getStringMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, entry := range strings.Split(getEnvOrDefault(key, ""), ",") {
+		name, value, _ := strings.Cut(entry, "=")
+		if name = strings.TrimSpace(name); name != "" {
+			values[name] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// getRolePolicies parses "ADMIN=access:5m|idle:1h|max:8h,OTHER=idle:24h" into
+// policy overrides per role; lifetimes that are left out are not overridden
+func getRolePolicies(errs *[]error, key string) map[string]SessionPolicy {
+	policies := make(map[string]SessionPolicy)
+	for _, entry := range strings.Split(getEnvOrDefault(key, ""), ",") {
+		role, list, _ := strings.Cut(entry, "=")
+		role = strings.ToUpper(strings.TrimSpace(role))
+		if role == "" {
+			continue
+		}
+
+		var policy SessionPolicy
+		for _, setting := range strings.Split(list, "|") {
+			if strings.TrimSpace(setting) == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(setting, ":")
+			duration, err := time.ParseDuration(strings.TrimSpace(value))
+			if err == nil && duration <= 0 {
+				err = errors.New("must be positive")
+			}
+			if err != nil {
+				*errs = append(*errs, fmt.Errorf("%s: %s %q: %w", key, role, strings.TrimSpace(setting), err))
+				continue
+			}
+
+			switch strings.TrimSpace(name) {
+			case "access":
+				policy.AccessTokenTTL = duration
+			case "idle":
+				policy.IdleTimeout = duration
+			case "max":
+				policy.MaxLifetime = duration
+			default:
+				*errs = append(*errs, fmt.Errorf("%s: %s: unknown lifetime %q", key, role, strings.TrimSpace(name)))
+			}
+		}
+		policies[role] = policy
+	}
+	return policies
+}
+
+// rateLimitKeys lists the rate-limited routes and what each can count requests
+// by. Emails and client IDs come from the request body, so only routes whose
+// body carries them can use them.
+var rateLimitKeys = map[string][]string{
+	"register": {"ip", "email"},
+	"login":    {"ip", "email", "client"},
+	"refresh":  {"ip"},
+	"mfa":      {"ip"},
+	"passkey":  {"ip", "client"},
+}
+
+// getRateLimits parses "login=10/1m:ip,passkey=30/1m:client,register=0" over
+// the defaults; the key type is optional and defaults to ip, and a limit of 0
+// disables the route's rule
+func getRateLimits(errs *[]error, key string, defaults map[string]RateLimitRule) map[string]RateLimitRule {
+	rules := make(map[string]RateLimitRule, len(defaults))
+	for route, rule := range defaults {
+		rules[route] = rule
+	}
+
+	for _, entry := range strings.Split(getEnvOrDefault(key, ""), ",") {
+		route, value, _ := strings.Cut(entry, "=")
+		route = strings.ToLower(strings.TrimSpace(route))
+		if route == "" {
+			continue
+		}
+		if _, ok := rateLimitKeys[route]; !ok {
+			*errs = append(*errs, fmt.Errorf("%s: unknown route %q", key, route))
+			continue
+		}
+
+		value, by, _ := strings.Cut(strings.TrimSpace(value), ":")
+		limit, window, _ := strings.Cut(value, "/")
+		count, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || count < 0 {
+			*errs = append(*errs, fmt.Errorf("%s: %s: invalid limit %q", key, route, strings.TrimSpace(limit)))
+			continue
+		}
+		if count == 0 {
+			rules[route] = RateLimitRule{}
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(window))
+		if err != nil || duration <= 0 {
+			*errs = append(*errs, fmt.Errorf("%s: %s: invalid window %q", key, route, strings.TrimSpace(window)))
+			continue
+		}
+
+		by = strings.ToLower(strings.TrimSpace(by))
+		if by == "" {
+			by = "ip"
+		}
+		if !slices.Contains(rateLimitKeys[route], by) {
+			*errs = append(*errs, fmt.Errorf("%s: %s: can't count requests by %q", key, route, by))
+			continue
+		}
+
+		rules[route] = RateLimitRule{Limit: count, Window: duration, By: by}
+	}
+	return rules
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{"SESSION_IDLE_TIMEOUT", "1d"},
+		{"SESSION_ROLE_POLICIES", "ADMIN=idle:1d"},
+		{"SESSION_ROLE_POLICIES", "ADMIN=idle:-1h"},
+		{"SESSION_ROLE_POLICIES", "ADMIN=idel:1h"},
+		{"MFA_MAX_ATTEMPTS", "five"},
+		{"DB_AUTO_MIGRATE", "sometimes"},
+		{"JWT_LEGACY_CLAIMS_UNTIL", "2024-01-01"},
+		{"RATE_LIMITS", "login=10/1d"},
+		{"RATE_LIMITS", "login=ten/1m"},
+		{"RATE_LIMITS", "login=10/1m:host"},
+		{"RATE_LIMITS", "login=10/1m:user"},
+		{"RATE_LIMITS", "refresh=10/1m:email"},
+		{"RATE_LIMITS", "logn=10/1m"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key+"="+test.value, func(t *testing.T) {
+			t.Setenv(test.key, test.value)
+
+			_, err := Load()
+			if err == nil {
+				t.Fatalf("Load() accepted %s=%s", test.key, test.value)
+			}
+			if !strings.Contains(err.Error(), test.key) {
+				t.Fatalf("Load() error %q does not name %s", err, test.key)
+			}
+		})
+	}
+}
+
+func TestLoadParsesValidSettings(t *testing.T) {
+	t.Setenv("SESSION_IDLE_TIMEOUT", "24h")
+	t.Setenv("SESSION_ROLE_POLICIES", "admin=access:5m|idle:1h|max:8h,SUPPORT=max:12h")
+	t.Setenv("RATE_LIMITS", "login=10/1m:client,register=0,passkey=5/1m:client")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.JWT.Sessions.IdleTimeout != 24*time.Hour {
+		t.Errorf("IdleTimeout = %s, want 24h", cfg.JWT.Sessions.IdleTimeout)
+	}
+	if got := cfg.JWT.RolePolicies["ADMIN"]; got != (SessionPolicy{AccessTokenTTL: 5 * time.Minute, IdleTimeout: time.Hour, MaxLifetime: 8 * time.Hour}) {
+		t.Errorf("ADMIN policy = %+v", got)
+	}
+	if got := cfg.JWT.RolePolicies["SUPPORT"]; got != (SessionPolicy{MaxLifetime: 12 * time.Hour}) {
+		t.Errorf("SUPPORT policy = %+v", got)
+	}
+	if got := cfg.RateLimit.Routes["login"]; got != (RateLimitRule{Limit: 10, Window: time.Minute, By: "client"}) {
+		t.Errorf("login rate limit = %+v", got)
+	}
+	if got := cfg.RateLimit.Routes["register"]; got != (RateLimitRule{}) {
+		t.Errorf("register rate limit = %+v, want disabled", got)
+	}
+	if got := cfg.RateLimit.Routes["passkey"]; got != (RateLimitRule{Limit: 5, Window: time.Minute, By: "client"}) {
+		t.Errorf("passkey rate limit = %+v", got)
+	}
+}
+
+func TestLoadDefaultsLegacyClaimsToRefreshTokenLifetime(t *testing.T) {
+	t.Setenv("SESSION_IDLE_TIMEOUT", "24h")
+	t.Setenv("SESSION_ROLE_POLICIES", "ADMIN=idle:48h")
+
+	before := time.Now()
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Tokens issued without iss and aud right before startup must still work
+	if cfg.JWT.LegacyClaimsUntil.Before(before.Add(48 * time.Hour)) {
+		t.Errorf("LegacyClaimsUntil = %s, want at least 48h after startup", cfg.JWT.LegacyClaimsUntil)
+	}
+	if cfg.JWT.Issuer == cfg.JWT.Audience {
+		t.Errorf("Issuer and Audience both default to %q; refresh tokens are for the issuer, access tokens for the audience", cfg.JWT.Issuer)
+	}
+
+	t.Setenv("JWT_LEGACY_CLAIMS_UNTIL", "2024-01-01T00:00:00Z")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.JWT.LegacyClaimsUntil.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("LegacyClaimsUntil = %s, want the configured time", cfg.JWT.LegacyClaimsUntil)
+	}
+}
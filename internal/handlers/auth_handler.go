@@ -8,6 +8,7 @@ import (
 	"github.com/randhir/aegis-core/internal/middleware"
 	"github.com/randhir/aegis-core/internal/service"
 	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/randhir/aegis-core/internal/webauthn"
 	"go.uber.org/zap"
 )
 
@@ -27,8 +28,10 @@ type RegisterRequest struct {
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Email      string `json:"email" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	DeviceName string `json:"device_name"`
+	ClientID   string `json:"client_id"`
 }
 
 type LoginResponse struct {
@@ -36,6 +39,22 @@ type LoginResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+// MFARequiredResponse is returned by login instead of tokens when the user has
+// MFA enabled
+type MFARequiredResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// VerifyMFARequest takes one of a TOTP code, a recovery code or a passkey
+// assertion answering /auth/mfa/passkey/begin
+type VerifyMFARequest struct {
+	MFAToken     string                      `json:"mfa_token" binding:"required"`
+	Code         string                      `json:"code"`
+	RecoveryCode string                      `json:"recovery_code"`
+	Passkey      *webauthn.AssertionResponse `json:"passkey"`
+}
+
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -55,7 +74,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	err := h.authService.Register(req.Email, req.Password)
+	err := h.authService.Register(c.Request.Context(), req.Email, req.Password)
 	if err != nil {
 		logger.Warn("User registration failed",
 			zap.String("email", req.Email),
@@ -79,7 +98,10 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	accessToken, refreshToken, err := h.authService.Login(req.Email, req.Password)
+	client := clientInfo(c, req.DeviceName)
+	client.ClientID = req.ClientID
+
+	result, err := h.authService.Login(c.Request.Context(), req.Email, req.Password, client)
 	if err != nil {
 		logger.Warn("Login failed",
 			zap.String("email", req.Email),
@@ -89,13 +111,63 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	if result.MFAToken != "" {
+		logger.Info("Login awaiting second factor",
+			zap.String("email", req.Email),
+		)
+		c.JSON(http.StatusOK, MFARequiredResponse{MFARequired: true, MFAToken: result.MFAToken})
+		return
+	}
+
 	logger.Info("User logged in successfully",
 		zap.String("email", req.Email),
 	)
 
 	c.JSON(http.StatusOK, LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
 	})
 }
 
+// VerifyMFA exchanges the challenge token from login and a second factor for a token pair
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req VerifyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.ErrorResponse(c, utils.ErrInvalidRequest)
+		return
+	}
+
+	factors := 0
+	for _, given := range []bool{req.Code != "", req.RecoveryCode != "", req.Passkey != nil} {
+		if given {
+			factors++
+		}
+	}
+	if factors != 1 {
+		middleware.ErrorResponse(c, &utils.AppError{Message: "exactly one of code, recovery_code and passkey is required", StatusCode: http.StatusBadRequest})
+		return
+	}
+
+	result, err := h.authService.VerifyMFA(c.Request.Context(), req.MFAToken, service.SecondFactor{
+		Code:         req.Code,
+		RecoveryCode: req.RecoveryCode,
+		Passkey:      req.Passkey,
+	})
+	if err != nil {
+		logger.Warn("MFA verification failed",
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("User logged in with second factor",
+		zap.Bool("recovery_code", req.RecoveryCode != ""),
+		zap.Bool("passkey", req.Passkey != nil),
+	)
+
+	c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+	})
+}
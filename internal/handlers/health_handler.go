@@ -17,4 +17,3 @@ func (h *HealthHandler) Health(c *gin.Context) {
 		"status": "ok",
 	})
 }
-
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/randhir/aegis-core/internal/utils"
+)
+
+type JWKSHandler struct {
+	jwt *utils.JWTManager
+}
+
+func NewJWKSHandler(jwt *utils.JWTManager) *JWKSHandler {
+	return &JWKSHandler{
+		jwt: jwt,
+	}
+}
+
+// JWKS publishes the public keys resource servers use to verify access tokens
+func (h *JWKSHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.jwt.JWKS())
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/middleware"
+	"github.com/randhir/aegis-core/internal/service"
+	"github.com/randhir/aegis-core/internal/utils"
+	"go.uber.org/zap"
+)
+
+type LockoutHandler struct {
+	lockoutService *service.LockoutService
+}
+
+func NewLockoutHandler(lockoutService *service.LockoutService) *LockoutHandler {
+	return &LockoutHandler{
+		lockoutService: lockoutService,
+	}
+}
+
+// UnlockUser lets an admin lift a user's login lockout before it expires
+func (h *LockoutHandler) UnlockUser(c *gin.Context) {
+	authContext, exists := middleware.GetAuthContext(c)
+	if !exists {
+		middleware.ErrorResponse(c, utils.ErrUnauthorized)
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.ErrorResponse(c, utils.ErrUserNotFound)
+		return
+	}
+
+	if err := h.lockoutService.Unlock(c.Request.Context(), userID); err != nil {
+		logger.Warn("Account unlock failed",
+			zap.String("admin_id", authContext.UserID),
+			zap.String("user_id", userID.String()),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("Account unlocked",
+		zap.String("admin_id", authContext.UserID),
+		zap.String("user_id", userID.String()),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MetricsHandler struct {
+	metrics *expvar.Map
+}
+
+func NewMetricsHandler(metrics *expvar.Map) *MetricsHandler {
+	return &MetricsHandler{metrics: metrics}
+}
+
+// Metrics returns the service's runtime counters as JSON
+func (h *MetricsHandler) Metrics(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(h.metrics.String()))
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/middleware"
+	"github.com/randhir/aegis-core/internal/service"
+	"github.com/randhir/aegis-core/internal/utils"
+	"go.uber.org/zap"
+)
+
+type MFAHandler struct {
+	mfaService *service.MFAService
+}
+
+func NewMFAHandler(mfaService *service.MFAService) *MFAHandler {
+	return &MFAHandler{
+		mfaService: mfaService,
+	}
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// EnrollTOTP starts TOTP enrollment for the caller
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.mfaService.EnrollTOTP(c.Request.Context(), userID)
+	if err != nil {
+		logger.Warn("TOTP enrollment failed",
+			zap.String("user_id", authContext.UserID),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("TOTP enrollment started",
+		zap.String("user_id", authContext.UserID),
+	)
+
+	c.JSON(http.StatusOK, TOTPEnrollmentResponse{
+		Secret:          enrollment.Secret,
+		ProvisioningURI: enrollment.ProvisioningURI,
+	})
+}
+
+// ConfirmTOTP enables MFA with a first code from the authenticator and returns
+// the recovery codes
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	var req ConfirmTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.ErrorResponse(c, utils.ErrInvalidRequest)
+		return
+	}
+
+	codes, err := h.mfaService.ConfirmTOTP(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		logger.Warn("TOTP confirmation failed",
+			zap.String("user_id", authContext.UserID),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("MFA enabled",
+		zap.String("user_id", authContext.UserID),
+	)
+
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// ResetMFA lets an admin remove a user's MFA, e.g. when they lost both their
+// authenticator and their recovery codes
+func (h *MFAHandler) ResetMFA(c *gin.Context) {
+	authContext, exists := middleware.GetAuthContext(c)
+	if !exists {
+		middleware.ErrorResponse(c, utils.ErrUnauthorized)
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.ErrorResponse(c, utils.ErrUserNotFound)
+		return
+	}
+
+	if err := h.mfaService.Reset(c.Request.Context(), authContext.UserID, userID); err != nil {
+		logger.Warn("MFA reset failed",
+			zap.String("admin_id", authContext.UserID),
+			zap.String("user_id", userID.String()),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("MFA reset",
+		zap.String("admin_id", authContext.UserID),
+		zap.String("user_id", userID.String()),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"message": "mfa reset"})
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/middleware"
+	"github.com/randhir/aegis-core/internal/service"
+	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/randhir/aegis-core/internal/webauthn"
+	"go.uber.org/zap"
+)
+
+type PasskeyHandler struct {
+	passkeyService *service.PasskeyService
+	authService    *service.AuthService
+}
+
+func NewPasskeyHandler(passkeyService *service.PasskeyService, authService *service.AuthService) *PasskeyHandler {
+	return &PasskeyHandler{
+		passkeyService: passkeyService,
+		authService:    authService,
+	}
+}
+
+type FinishPasskeyRegistrationRequest struct {
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential" binding:"required"`
+}
+
+type FinishPasskeyLoginRequest struct {
+	Credential webauthn.AssertionResponse `json:"credential" binding:"required"`
+	DeviceName string                     `json:"device_name"`
+	ClientID   string                     `json:"client_id"`
+}
+
+type BeginPasskeySecondFactorRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+type PasskeyResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+}
+
+// BeginRegistration returns the options to pass to navigator.credentials.create()
+func (h *PasskeyHandler) BeginRegistration(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	options, err := h.passkeyService.BeginRegistration(c.Request.Context(), userID)
+	if err != nil {
+		logger.Warn("Passkey registration could not start",
+			zap.String("user_id", authContext.UserID),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishRegistration stores the passkey created by the authenticator
+func (h *PasskeyHandler) FinishRegistration(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	var req FinishPasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.ErrorResponse(c, utils.ErrInvalidRequest)
+		return
+	}
+
+	passkey, err := h.passkeyService.FinishRegistration(c.Request.Context(), userID, req.Name, req.Credential)
+	if err != nil {
+		logger.Warn("Passkey registration failed",
+			zap.String("user_id", authContext.UserID),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("Passkey registered",
+		zap.String("user_id", authContext.UserID),
+		zap.String("credential_id", webauthn.EncodeID(passkey.ID)),
+	)
+
+	c.JSON(http.StatusCreated, PasskeyResponse{
+		ID:        webauthn.EncodeID(passkey.ID),
+		Name:      passkey.Name,
+		CreatedAt: passkey.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	})
+}
+
+func (h *PasskeyHandler) ListPasskeys(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	passkeys, err := h.passkeyService.List(c.Request.Context(), userID)
+	if err != nil {
+		logger.Error("Failed to fetch passkeys",
+			zap.String("user_id", authContext.UserID),
+			zap.Error(err),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	response := make([]PasskeyResponse, len(passkeys))
+	for i, passkey := range passkeys {
+		response[i] = PasskeyResponse{
+			ID:        webauthn.EncodeID(passkey.ID),
+			Name:      passkey.Name,
+			CreatedAt: passkey.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		}
+		if passkey.LastUsedAt != nil {
+			response[i].LastUsedAt = passkey.LastUsedAt.Format("2006-01-02T15:04:05Z07:00")
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *PasskeyHandler) DeletePasskey(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	credentialID, err := webauthn.DecodeID(c.Param("id"))
+	if err != nil {
+		middleware.ErrorResponse(c, utils.ErrPasskeyNotFound)
+		return
+	}
+
+	if err := h.passkeyService.Delete(c.Request.Context(), userID, credentialID); err != nil {
+		logger.Warn("Passkey deletion failed",
+			zap.String("user_id", authContext.UserID),
+			zap.String("credential_id", c.Param("id")),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("Passkey deleted",
+		zap.String("user_id", authContext.UserID),
+		zap.String("credential_id", c.Param("id")),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"message": "passkey deleted"})
+}
+
+// BeginLogin returns the options to pass to navigator.credentials.get() for a
+// passwordless login
+func (h *PasskeyHandler) BeginLogin(c *gin.Context) {
+	options, err := h.passkeyService.BeginLogin(c.Request.Context())
+	if err != nil {
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishLogin exchanges a passkey assertion for a token pair
+func (h *PasskeyHandler) FinishLogin(c *gin.Context) {
+	var req FinishPasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.ErrorResponse(c, utils.ErrInvalidRequest)
+		return
+	}
+
+	client := clientInfo(c, req.DeviceName)
+	client.ClientID = req.ClientID
+
+	result, err := h.authService.LoginWithPasskey(c.Request.Context(), req.Credential, client)
+	if err != nil {
+		logger.Warn("Passkey login failed",
+			zap.String("credential_id", req.Credential.ID),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("User logged in with passkey",
+		zap.String("credential_id", req.Credential.ID),
+	)
+
+	c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+	})
+}
+
+// BeginSecondFactor returns the options for answering a pending MFA login with
+// a passkey; the assertion then goes to /auth/mfa/verify
+func (h *PasskeyHandler) BeginSecondFactor(c *gin.Context) {
+	var req BeginPasskeySecondFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.ErrorResponse(c, utils.ErrInvalidRequest)
+		return
+	}
+
+	options, err := h.passkeyService.BeginSecondFactor(c.Request.Context(), req.MFAToken)
+	if err != nil {
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
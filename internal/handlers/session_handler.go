@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/middleware"
+	"github.com/randhir/aegis-core/internal/service"
+	"github.com/randhir/aegis-core/internal/utils"
+	"go.uber.org/zap"
+)
+
+type SessionHandler struct {
+	sessionService *service.SessionService
+}
+
+func NewSessionHandler(sessionService *service.SessionService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+	}
+}
+
+type SessionResponse struct {
+	ID         string `json:"id"`
+	DeviceName string `json:"device_name"`
+	UserAgent  string `json:"user_agent"`
+	IPAddress  string `json:"ip_address"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
+
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.sessionService.ListSessions(c.Request.Context(), userID)
+	if err != nil {
+		logger.Error("Failed to fetch sessions",
+			zap.String("user_id", authContext.UserID),
+			zap.Error(err),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{
+			ID:         session.ID.String(),
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			LastUsedAt: session.LastUsedAt.Format("2006-01-02T15:04:05Z07:00"),
+			ExpiresAt:  session.ExpiresAt.Format("2006-01-02T15:04:05Z07:00"),
+			Current:    session.ID.String() == authContext.SessionID,
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.ErrorResponse(c, utils.ErrSessionNotFound)
+		return
+	}
+
+	err = h.sessionService.RevokeSession(c.Request.Context(), userID, sessionID)
+	if err != nil {
+		logger.Warn("Session revocation failed",
+			zap.String("user_id", authContext.UserID),
+			zap.String("session_id", sessionID.String()),
+			zap.String("error", err.Error()),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("Session revoked",
+		zap.String("user_id", authContext.UserID),
+		zap.String("session_id", sessionID.String()),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeOtherSessions ends every session of the caller except the one making the request
+func (h *SessionHandler) RevokeOtherSessions(c *gin.Context) {
+	authContext, userID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	// Tokens issued before sessions existed have no session ID; nothing is spared then
+	currentSessionID, _ := uuid.Parse(authContext.SessionID)
+
+	revoked, err := h.sessionService.RevokeOtherSessions(c.Request.Context(), userID, currentSessionID)
+	if err != nil {
+		logger.Error("Failed to revoke sessions",
+			zap.String("user_id", authContext.UserID),
+			zap.Int("revoked", revoked),
+			zap.Error(err),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("Other sessions revoked",
+		zap.String("user_id", authContext.UserID),
+		zap.Int("revoked", revoked),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"message": "other sessions revoked", "revoked": revoked})
+}
+
+// RevokeUserTokens lets an admin end every session of a user and reject all of
+// their outstanding access tokens, e.g. for a compromised account
+func (h *SessionHandler) RevokeUserTokens(c *gin.Context) {
+	authContext, exists := middleware.GetAuthContext(c)
+	if !exists {
+		middleware.ErrorResponse(c, utils.ErrUnauthorized)
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.ErrorResponse(c, utils.ErrUserNotFound)
+		return
+	}
+
+	revoked, err := h.sessionService.RevokeAllSessions(c.Request.Context(), userID)
+	if err != nil {
+		logger.Error("Failed to revoke user tokens",
+			zap.String("admin_id", authContext.UserID),
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+		middleware.ErrorResponse(c, err)
+		return
+	}
+
+	logger.Info("User tokens revoked",
+		zap.String("admin_id", authContext.UserID),
+		zap.String("user_id", userID.String()),
+		zap.Int64("revoked_sessions", revoked),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"message": "user tokens revoked", "revoked_sessions": revoked})
+}
+
+// sessionOwner returns the caller's auth context and parsed user ID, writing an
+// error response if either is missing
+func sessionOwner(c *gin.Context) (*middleware.AuthContext, uuid.UUID, bool) {
+	authContext, exists := middleware.GetAuthContext(c)
+	if !exists {
+		middleware.ErrorResponse(c, utils.ErrUnauthorized)
+		return nil, uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(authContext.UserID)
+	if err != nil {
+		middleware.ErrorResponse(c, utils.ErrUnauthorized)
+		return nil, uuid.Nil, false
+	}
+
+	return authContext, userID, true
+}
+
+// clientInfo describes the device making the request
+func clientInfo(c *gin.Context, deviceName string) service.ClientInfo {
+	return service.ClientInfo{
+		DeviceName: deviceName,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+	}
+}
@@ -42,7 +42,7 @@ func (h *TokenHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	accessToken, refreshToken, err := h.tokenService.Refresh(req.RefreshToken)
+	accessToken, refreshToken, err := h.tokenService.Refresh(c.Request.Context(), req.RefreshToken, clientInfo(c, ""))
 	if err != nil {
 		logger.Warn("Token refresh failed",
 			zap.String("error", err.Error()),
@@ -75,7 +75,7 @@ func (h *TokenHandler) Logout(c *gin.Context) {
 		}
 	}
 
-	err := h.tokenService.Logout(req.RefreshToken, accessToken)
+	err := h.tokenService.Logout(c.Request.Context(), req.RefreshToken, accessToken)
 	if err != nil {
 		logger.Warn("Logout failed",
 			zap.String("error", err.Error()),
@@ -87,4 +87,3 @@ func (h *TokenHandler) Logout(c *gin.Context) {
 	logger.Info("User logged out successfully")
 	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
 }
-
@@ -11,10 +11,14 @@ import (
 	"go.uber.org/zap"
 )
 
-type UserHandler struct{}
+type UserHandler struct {
+	users repository.UserStore
+}
 
-func NewUserHandler() *UserHandler {
-	return &UserHandler{}
+func NewUserHandler(users repository.UserStore) *UserHandler {
+	return &UserHandler{
+		users: users,
+	}
 }
 
 type ProfileResponse struct {
@@ -56,13 +60,13 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 		return
 	}
 
-	users, err := repository.ListUsers()
+	users, err := h.users.ListUsers(c.Request.Context())
 	if err != nil {
 		logger.Error("Failed to fetch users",
 			zap.String("admin_id", authContext.UserID),
 			zap.Error(err),
 		)
-		middleware.ErrorResponse(c, utils.ErrInternalError)
+		middleware.ErrorResponse(c, utils.FromStoreError(err))
 		return
 	}
 
@@ -83,4 +87,3 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
-
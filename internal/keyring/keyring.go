@@ -0,0 +1,399 @@
+package keyring
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/config"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+	"go.uber.org/zap"
+)
+
+// ErrKeyStillInUse is returned when retiring a key that may have signed tokens
+// which have not expired yet
+var ErrKeyStillInUse = errors.New("signing key may still have unexpired tokens")
+
+// Keyring holds the rotating token signing keys of every purpose. Key material
+// is stored encrypted with AES-256-GCM; running servers pick up changes made by
+// other instances or aegisctl on each reload.
+type Keyring struct {
+	store repository.SigningKeyStore
+	aead  cipher.AEAD
+	// jwt bounds how long tokens signed by a deactivated key stay valid
+	jwt config.JWTConfig
+
+	mu      sync.RWMutex
+	keys    map[string]loadedKey
+	signing map[string]*utils.SigningKey
+	// encrypting is the active access token encryption key, if any
+	encrypting *utils.EncryptionKey
+}
+
+// loadedKey holds a signing key, or an encryption key for KeyPurposeEncryption
+type loadedKey struct {
+	purpose    string
+	key        *utils.SigningKey
+	encryption *utils.EncryptionKey
+}
+
+// New creates a keyring sealed with encryptionKey, given base64-encoded (32 bytes)
+func New(store repository.SigningKeyStore, encryptionKey string, jwtCfg config.JWTConfig) (*Keyring, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyring encryption key: %w", err)
+	}
+	if len(rawKey) != 32 {
+		return nil, fmt.Errorf("keyring encryption key must be 32 bytes, got %d", len(rawKey))
+	}
+
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keyring cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keyring cipher: %w", err)
+	}
+
+	return &Keyring{
+		store:   store,
+		aead:    aead,
+		jwt:     jwtCfg,
+		keys:    make(map[string]loadedKey),
+		signing: make(map[string]*utils.SigningKey),
+	}, nil
+}
+
+// Load replaces the in-memory keys with the staged, active and inactive keys in the store
+func (k *Keyring) Load(ctx context.Context) error {
+	stored, err := k.store.ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]loadedKey)
+	signing := make(map[string]*utils.SigningKey)
+	var encrypting *utils.EncryptionKey
+	for _, entry := range stored {
+		if entry.Status == models.SigningKeyRetired {
+			continue
+		}
+
+		loaded, err := k.open(entry)
+		if err != nil {
+			logger.Error("Failed to load signing key",
+				zap.String("kid", entry.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		keys[entry.ID] = loaded
+		if entry.Status == models.SigningKeyActive {
+			if loaded.encryption != nil {
+				encrypting = loaded.encryption
+			} else {
+				signing[entry.Purpose] = loaded.key
+			}
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.signing = signing
+	k.encrypting = encrypting
+	k.mu.Unlock()
+
+	return nil
+}
+
+// Watch reloads the keyring every interval until ctx is done
+func (k *Keyring) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Load(ctx); err != nil && ctx.Err() == nil {
+				logger.Error("Failed to reload keyring", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Stage generates a new key for purpose. It is published and accepted for
+// verification (or decryption) right away but only signs (or encrypts) tokens
+// once promoted.
+func (k *Keyring) Stage(ctx context.Context, purpose, algorithm string) (*models.SigningKey, error) {
+	switch purpose {
+	case utils.KeyPurposeAccess, utils.KeyPurposeRefresh:
+		if !signingAlgorithms[algorithm] {
+			return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+		}
+	case utils.KeyPurposeEncryption:
+		if !encryptionAlgorithms[algorithm] {
+			return nil, fmt.Errorf("unsupported encryption algorithm %q", algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unknown key purpose %q", purpose)
+	}
+
+	material, err := generateKeyMaterial(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := models.SigningKey{
+		ID:        uuid.NewString(),
+		Purpose:   purpose,
+		Algorithm: algorithm,
+		Status:    models.SigningKeyStaged,
+	}
+	entry.EncryptedKey = k.seal(entry, material)
+
+	if err := k.store.CreateSigningKey(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// Promote makes a staged key sign new tokens; the previous signing key of the
+// same purpose keeps verifying the tokens it issued. A signing key whose
+// algorithm the configured TOKEN_CODEC can't use is refused, since every token
+// it would sign would fail.
+func (k *Keyring) Promote(ctx context.Context, keyID string) error {
+	entry, err := k.store.GetSigningKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+
+	if entry.Purpose != utils.KeyPurposeEncryption {
+		if err := utils.CheckSigningAlgorithm(k.jwt.TokenCodec, entry.Purpose, entry.Algorithm); err != nil {
+			return fmt.Errorf("cannot promote key %s: %w", keyID, err)
+		}
+	}
+
+	return k.store.PromoteSigningKey(ctx, keyID)
+}
+
+// Retire stops accepting tokens signed with a staged or inactive key. Unless
+// force is set, an inactive key is only retired once every token it could have
+// signed has expired.
+func (k *Keyring) Retire(ctx context.Context, keyID string, force bool) error {
+	entry, err := k.store.GetSigningKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+
+	if entry.Status == models.SigningKeyInactive && entry.DeactivatedAt != nil && !force {
+		safeAt := entry.DeactivatedAt.Add(k.maxTokenLifetime(entry.Purpose))
+		if time.Now().Before(safeAt) {
+			return fmt.Errorf("%w: retire after %s or force it", ErrKeyStillInUse, safeAt.Format(time.RFC3339))
+		}
+	}
+
+	return k.store.RetireSigningKey(ctx, keyID)
+}
+
+// List returns every key in the store, including retired ones
+func (k *Keyring) List(ctx context.Context) ([]models.SigningKey, error) {
+	return k.store.ListSigningKeys(ctx)
+}
+
+// EncryptionKeySource returns the access token encryption keys, using fallback
+// to encrypt while the keyring has no active encryption key
+func (k *Keyring) EncryptionKeySource(fallback utils.EncryptionKeySource) utils.EncryptionKeySource {
+	return &encryptionKeySource{keyring: k, fallback: fallback}
+}
+
+// KeySource returns the keys for purpose, using fallback to sign while the
+// keyring has no active key and to verify tokens with a kid it doesn't know
+func (k *Keyring) KeySource(purpose string, fallback utils.KeySource) utils.KeySource {
+	return &keySource{keyring: k, purpose: purpose, fallback: fallback}
+}
+
+type keySource struct {
+	keyring  *Keyring
+	purpose  string
+	fallback utils.KeySource
+}
+
+func (s *keySource) SigningKey() (*utils.SigningKey, error) {
+	s.keyring.mu.RLock()
+	key := s.keyring.signing[s.purpose]
+	s.keyring.mu.RUnlock()
+
+	if key != nil {
+		return key, nil
+	}
+	return s.fallback.SigningKey()
+}
+
+func (s *keySource) VerificationKey(kid string) (*utils.SigningKey, error) {
+	s.keyring.mu.RLock()
+	loaded, exists := s.keyring.keys[kid]
+	s.keyring.mu.RUnlock()
+
+	if exists {
+		if loaded.purpose != s.purpose || loaded.key == nil {
+			return nil, fmt.Errorf("signing key %q is not for %s tokens", kid, s.purpose)
+		}
+		return loaded.key, nil
+	}
+	return s.fallback.VerificationKey(kid)
+}
+
+func (s *keySource) VerificationKeys() []*utils.SigningKey {
+	s.keyring.mu.RLock()
+	var keys []*utils.SigningKey
+	for _, loaded := range s.keyring.keys {
+		if loaded.purpose == s.purpose {
+			keys = append(keys, loaded.key)
+		}
+	}
+	s.keyring.mu.RUnlock()
+
+	return append(keys, s.fallback.VerificationKeys()...)
+}
+
+type encryptionKeySource struct {
+	keyring  *Keyring
+	fallback utils.EncryptionKeySource
+}
+
+func (s *encryptionKeySource) EncryptionKey() (*utils.EncryptionKey, error) {
+	s.keyring.mu.RLock()
+	key := s.keyring.encrypting
+	s.keyring.mu.RUnlock()
+
+	if key != nil {
+		return key, nil
+	}
+	return s.fallback.EncryptionKey()
+}
+
+func (s *encryptionKeySource) DecryptionKey(kid string) (*utils.EncryptionKey, error) {
+	s.keyring.mu.RLock()
+	loaded, exists := s.keyring.keys[kid]
+	s.keyring.mu.RUnlock()
+
+	if exists {
+		if loaded.encryption == nil {
+			return nil, fmt.Errorf("key %q is not an encryption key", kid)
+		}
+		return loaded.encryption, nil
+	}
+	return s.fallback.DecryptionKey(kid)
+}
+
+// seal encrypts key material, binding it to the key's ID, purpose and algorithm
+func (k *Keyring) seal(entry models.SigningKey, material []byte) []byte {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return k.aead.Seal(nonce, nonce, material, additionalData(entry))
+}
+
+func (k *Keyring) open(entry models.SigningKey) (loadedKey, error) {
+	nonceSize := k.aead.NonceSize()
+	if len(entry.EncryptedKey) < nonceSize {
+		return loadedKey{}, errors.New("encrypted key material is truncated")
+	}
+
+	nonce, sealed := entry.EncryptedKey[:nonceSize], entry.EncryptedKey[nonceSize:]
+	material, err := k.aead.Open(nil, nonce, sealed, additionalData(entry))
+	if err != nil {
+		return loadedKey{}, fmt.Errorf("failed to decrypt key material: %w", err)
+	}
+
+	loaded := loadedKey{purpose: entry.Purpose}
+	if entry.Purpose == utils.KeyPurposeEncryption {
+		loaded.encryption, err = utils.NewEncryptionKey(entry.ID, entry.Algorithm, material)
+	} else {
+		loaded.key, err = utils.NewSigningKey(entry.ID, entry.Algorithm, material)
+	}
+	return loaded, err
+}
+
+func additionalData(entry models.SigningKey) []byte {
+	return []byte(entry.ID + "|" + entry.Purpose + "|" + entry.Algorithm)
+}
+
+var signingAlgorithms = map[string]bool{
+	utils.AlgorithmHS256: true,
+	utils.AlgorithmRS256: true,
+	utils.AlgorithmES256: true,
+	utils.AlgorithmEdDSA: true,
+}
+
+var encryptionAlgorithms = map[string]bool{
+	utils.EncryptionDirect:     true,
+	utils.EncryptionRSAOAEP:    true,
+	utils.EncryptionRSAOAEP256: true,
+}
+
+// generateKeyMaterial returns a random 256-bit secret for HS256 and dir, or a
+// PKCS #8 PEM private key
+func generateKeyMaterial(algorithm string) ([]byte, error) {
+	var privateKey interface{}
+	var err error
+
+	switch algorithm {
+	case utils.AlgorithmHS256, utils.EncryptionDirect:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+		return secret, nil
+	case utils.AlgorithmRS256, utils.EncryptionRSAOAEP, utils.EncryptionRSAOAEP256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case utils.AlgorithmES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case utils.AlgorithmEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", algorithm, err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s key: %w", algorithm, err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// maxTokenLifetime is how long a token of purpose can stay valid after its key
+// stops signing or encrypting; encryption keys only wrap access tokens
+func (k *Keyring) maxTokenLifetime(purpose string) time.Duration {
+	if purpose == utils.KeyPurposeRefresh {
+		return k.jwt.MaxRefreshTokenTTL()
+	}
+	return k.jwt.MaxAccessTokenTTL()
+}
@@ -0,0 +1,69 @@
+package keyring
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/randhir/aegis-core/internal/config"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+)
+
+func newTestKeyring(t *testing.T, tokenCodec string) (*Keyring, *repository.MemorySigningKeyStore) {
+	t.Helper()
+	store := repository.NewMemorySigningKeyStore()
+	keys, err := New(store, base64.StdEncoding.EncodeToString(make([]byte, 32)), config.JWTConfig{TokenCodec: tokenCodec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, store
+}
+
+func TestPromoteChecksTokenCodec(t *testing.T) {
+	tests := []struct {
+		codec     string
+		purpose   string
+		algorithm string
+		allowed   bool
+	}{
+		{utils.TokenCodecJWT, utils.KeyPurposeAccess, utils.AlgorithmES256, true},
+		{utils.TokenCodecPasetoLocal, utils.KeyPurposeAccess, utils.AlgorithmHS256, true},
+		{utils.TokenCodecPasetoLocal, utils.KeyPurposeAccess, utils.AlgorithmES256, false},
+		{utils.TokenCodecPasetoPublic, utils.KeyPurposeAccess, utils.AlgorithmEdDSA, true},
+		{utils.TokenCodecPasetoPublic, utils.KeyPurposeAccess, utils.AlgorithmRS256, false},
+		{utils.TokenCodecPasetoPublic, utils.KeyPurposeRefresh, utils.AlgorithmHS256, true},
+		{utils.TokenCodecPasetoPublic, utils.KeyPurposeRefresh, utils.AlgorithmEdDSA, false},
+		{utils.TokenCodecPasetoLocal, utils.KeyPurposeEncryption, utils.EncryptionDirect, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.codec+"/"+test.purpose+"/"+test.algorithm, func(t *testing.T) {
+			keys, store := newTestKeyring(t, test.codec)
+			ctx := context.Background()
+
+			entry, err := keys.Stage(ctx, test.purpose, test.algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = keys.Promote(ctx, entry.ID)
+			if (err == nil) != test.allowed {
+				t.Fatalf("Promote() error = %v, allowed = %v", err, test.allowed)
+			}
+
+			stored, err := store.GetSigningKey(ctx, entry.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus := models.SigningKeyStaged
+			if test.allowed {
+				wantStatus = models.SigningKeyActive
+			}
+			if stored.Status != wantStatus {
+				t.Fatalf("key status = %s, want %s", stored.Status, wantStatus)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	jwtlib "github.com/golang-jwt/jwt/v5"
 	"github.com/randhir/aegis-core/internal/cache"
 	"github.com/randhir/aegis-core/internal/logger"
 	"github.com/randhir/aegis-core/internal/utils"
@@ -12,14 +13,17 @@ import (
 )
 
 type AuthContext struct {
-	UserID string
-	Email  string
-	Role   string
+	UserID    string
+	Email     string
+	Role      string
+	SessionID string
 }
 
 const AuthContextKey = "auth_context"
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware accepts JWT access tokens and opaque reference tokens, checking
+// both against the same revocations before setting the AuthContext
+func AuthMiddleware(jwt *utils.JWTManager, revocations cache.TokenRevocationStore, references cache.ReferenceTokenStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -37,10 +41,54 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		tokenString := parts[1]
 
+		var claims *utils.AccessTokenClaims
+		if utils.IsReferenceToken(tokenString) {
+			reference, err := references.GetReferenceToken(c.Request.Context(), tokenString)
+			if err != nil {
+				logger.Error("Authorization failed: could not look up reference token",
+					zap.String("path", c.Request.URL.Path),
+					zap.Error(err),
+				)
+				ErrorResponse(c, utils.FromStoreError(err))
+				c.Abort()
+				return
+			}
+			if reference == nil {
+				logger.Warn("Authorization failed: unknown or expired reference token",
+					zap.String("path", c.Request.URL.Path),
+				)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+				c.Abort()
+				return
+			}
+			claims = referenceTokenClaims(reference)
+		} else {
+			var err error
+			claims, err = jwt.ValidateAccessToken(tokenString)
+			if err != nil {
+				logger.Warn("Authorization failed: invalid token",
+					zap.String("path", c.Request.URL.Path),
+					zap.String("error", err.Error()),
+				)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+				c.Abort()
+				return
+			}
+		}
+
 		// Check if token is blacklisted in Redis
-		isBlacklisted, err := cache.IsAccessTokenBlacklisted(tokenString)
-		if err != nil || isBlacklisted {
-			logger.Warn("Authorization failed: token blacklisted or invalid",
+		isBlacklisted, err := isAccessTokenBlacklisted(c, jwt, revocations, tokenString, claims)
+		if err != nil {
+			logger.Error("Authorization failed: could not check token blacklist",
+				zap.String("path", c.Request.URL.Path),
+				zap.Error(err),
+			)
+			ErrorResponse(c, utils.FromStoreError(err))
+			c.Abort()
+			return
+		}
+		if isBlacklisted {
+			logger.Warn("Authorization failed: token blacklisted",
 				zap.String("path", c.Request.URL.Path),
 			)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -48,11 +96,43 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := utils.ValidateAccessToken(tokenString)
+		if claims.FamilyID != "" {
+			familyRevoked, err := revocations.IsTokenFamilyRevoked(c.Request.Context(), claims.FamilyID)
+			if err != nil {
+				logger.Error("Authorization failed: could not check token family revocation",
+					zap.String("path", c.Request.URL.Path),
+					zap.Error(err),
+				)
+				ErrorResponse(c, utils.FromStoreError(err))
+				c.Abort()
+				return
+			}
+			if familyRevoked {
+				logger.Warn("Authorization failed: token family revoked",
+					zap.String("path", c.Request.URL.Path),
+					zap.String("user_id", claims.UserID),
+				)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+				c.Abort()
+				return
+			}
+		}
+
+		// Reject tokens issued before the user's tokens were last revoked as a whole
+		revokedAt, err := revocations.UserTokensRevokedAt(c.Request.Context(), claims.UserID)
 		if err != nil {
-			logger.Warn("Authorization failed: invalid token",
+			logger.Error("Authorization failed: could not check user token revocation",
+				zap.String("path", c.Request.URL.Path),
+				zap.Error(err),
+			)
+			ErrorResponse(c, utils.FromStoreError(err))
+			c.Abort()
+			return
+		}
+		if !revokedAt.IsZero() && utils.IssuedBefore(claims.IssuedAt, revokedAt) {
+			logger.Warn("Authorization failed: user tokens revoked",
 				zap.String("path", c.Request.URL.Path),
-				zap.String("error", err.Error()),
+				zap.String("user_id", claims.UserID),
 			)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			c.Abort()
@@ -60,9 +140,10 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		authContext := AuthContext{
-			UserID: claims.UserID,
-			Email:  claims.Email,
-			Role:   claims.Role,
+			UserID:    claims.UserID,
+			Email:     claims.Email,
+			Role:      claims.Role,
+			SessionID: claims.FamilyID,
 		}
 
 		c.Set(AuthContextKey, authContext)
@@ -70,6 +151,39 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// referenceTokenClaims presents the claims stored for an opaque token like those of a JWT
+func referenceTokenClaims(reference *cache.ReferenceToken) *utils.AccessTokenClaims {
+	return &utils.AccessTokenClaims{
+		UserID:   reference.UserID,
+		Email:    reference.Email,
+		Role:     reference.Role,
+		FamilyID: reference.FamilyID,
+		ClientID: reference.ClientID,
+		RegisteredClaims: jwtlib.RegisteredClaims{
+			ID:        reference.TokenID,
+			IssuedAt:  jwtlib.NewNumericDate(reference.IssuedAt),
+			ExpiresAt: jwtlib.NewNumericDate(reference.ExpiresAt),
+		},
+	}
+}
+
+// isAccessTokenBlacklisted checks the blacklist by jti, and by the full token
+// string for tokens that predate jti or during the compatibility window
+func isAccessTokenBlacklisted(c *gin.Context, jwt *utils.JWTManager, revocations cache.TokenRevocationStore, tokenString string, claims *utils.AccessTokenClaims) (bool, error) {
+	if claims.ID != "" {
+		blacklisted, err := revocations.IsAccessTokenBlacklisted(c.Request.Context(), claims.ID)
+		if err != nil || blacklisted {
+			return blacklisted, err
+		}
+	}
+
+	if jwt.UsesLegacyBlacklist(claims) {
+		return revocations.IsLegacyAccessTokenBlacklisted(c.Request.Context(), tokenString)
+	}
+
+	return false, nil
+}
+
 func GetAuthContext(c *gin.Context) (*AuthContext, bool) {
 	authCtx, exists := c.Get(AuthContextKey)
 	if !exists {
@@ -83,4 +197,3 @@ func GetAuthContext(c *gin.Context) (*AuthContext, bool) {
 
 	return &authContext, true
 }
-
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.Initialize(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newRateLimitedRouter(t *testing.T, by string, trustedProxies []string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	knownClient := func(clientID string) bool { return clientID == "web" }
+
+	key, err := RateLimitKey(by, knownClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	router.POST("/login", RateLimit(cache.NewMemoryRateLimiter(), "login", 2, time.Minute, key), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func postLogin(router *gin.Engine, remoteAddr, forwardedFor, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRateLimitIgnoresForwardedForFromUntrustedPeers(t *testing.T) {
+	router := newRateLimitedRouter(t, "ip", nil)
+
+	codes := []int{
+		postLogin(router, "203.0.113.7:1234", "198.51.100.1", `{}`),
+		postLogin(router, "203.0.113.7:1234", "198.51.100.2", `{}`),
+		postLogin(router, "203.0.113.7:1234", "198.51.100.3", `{}`),
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("codes = %v, want the third request refused", codes)
+	}
+}
+
+func TestRateLimitUsesForwardedForFromTrustedProxy(t *testing.T) {
+	router := newRateLimitedRouter(t, "ip", []string{"10.0.0.0/8"})
+
+	for i := 0; i < 2; i++ {
+		postLogin(router, "10.0.0.1:1234", "198.51.100.1", `{}`)
+	}
+	if code := postLogin(router, "10.0.0.1:1234", "198.51.100.2", `{}`); code != http.StatusOK {
+		t.Fatalf("other client behind the proxy got %d, want 200", code)
+	}
+	if code := postLogin(router, "10.0.0.1:1234", "198.51.100.1", `{}`); code != http.StatusTooManyRequests {
+		t.Fatalf("limited client got %d, want 429", code)
+	}
+}
+
+func TestRateLimitCountsUnknownClientsByIP(t *testing.T) {
+	router := newRateLimitedRouter(t, "client", nil)
+
+	for i := 0; i < 2; i++ {
+		postLogin(router, "203.0.113.7:1234", "", `{"client_id":"made-up-`+string(rune('a'+i))+`"}`)
+	}
+	if code := postLogin(router, "203.0.113.7:1234", "", `{"client_id":"made-up-c"}`); code != http.StatusTooManyRequests {
+		t.Fatalf("unknown client got %d, want 429", code)
+	}
+	if code := postLogin(router, "203.0.113.7:1234", "", `{"client_id":"web"}`); code != http.StatusOK {
+		t.Fatalf("known client got %d, want its own bucket", code)
+	}
+}
+
+func TestRateLimitCountsLoginsByEmail(t *testing.T) {
+	router := newRateLimitedRouter(t, "email", nil)
+
+	postLogin(router, "203.0.113.7:1234", "", `{"email":"victim@example.com"}`)
+	postLogin(router, "198.51.100.1:1234", "", `{"email":" Victim@Example.com"}`)
+	if code := postLogin(router, "198.51.100.2:1234", "", `{"email":"victim@example.com"}`); code != http.StatusTooManyRequests {
+		t.Fatalf("third login for the email from another IP got %d, want 429", code)
+	}
+	if code := postLogin(router, "203.0.113.7:1234", "", `{"email":"other@example.com"}`); code != http.StatusOK {
+		t.Fatalf("other email got %d, want its own bucket", code)
+	}
+}
+
+func TestRateLimitKeyRejectsUnknownKeys(t *testing.T) {
+	if _, err := RateLimitKey("user", nil); err == nil {
+		t.Fatal("RateLimitKey() accepted an unknown key")
+	}
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MFAEnrollment is a user's TOTP authenticator. It only guards logins once
+// confirmed with a first code. LastUsedStep is the latest time step a code was
+// accepted for, so a code can't be replayed.
+type MFAEnrollment struct {
+	UserID          uuid.UUID
+	EncryptedSecret []byte
+	LastUsedStep    int64
+	CreatedAt       time.Time
+	ConfirmedAt     *time.Time
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Passkey is a WebAuthn credential registered by a user. PublicKey is the
+// COSE key the authenticator returned; SignCount is the authenticator's
+// signature counter as of the last sign-in, or 0 if it doesn't keep one.
+type Passkey struct {
+	ID         []byte
+	UserID     uuid.UUID
+	Name       string
+	PublicKey  []byte
+	Algorithm  int64
+	AAGUID     []byte
+	SignCount  int64
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
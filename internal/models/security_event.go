@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	SecurityEventMFAReset          = "mfa_reset"
+	SecurityEventPasskeyCloned     = "passkey_cloned"
+	SecurityEventLoginLockout      = "login_lockout"
+)
+
+// SecurityEvent records suspicious activity that operators may want to alert on
+type SecurityEvent struct {
+	ID        uuid.UUID
+	Type      string
+	UserID    *uuid.UUID
+	Details   string
+	CreatedAt time.Time
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a single login on one device. Its ID is the family ID shared by
+// every refresh token rotated from that login.
+type Session struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+}
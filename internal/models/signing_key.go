@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Signing key lifecycle: a staged key is published and accepted but not yet used
+// to sign; the active key signs new tokens; an inactive key only verifies tokens
+// it signed before; a retired key is gone for good
+const (
+	SigningKeyStaged   = "staged"
+	SigningKeyActive   = "active"
+	SigningKeyInactive = "inactive"
+	SigningKeyRetired  = "retired"
+)
+
+// SigningKey is a keyring entry. EncryptedKey holds the key material sealed
+// with the keyring encryption key and is empty once the key is retired.
+type SigningKey struct {
+	ID            string
+	Purpose       string
+	Algorithm     string
+	Status        string
+	EncryptedKey  []byte
+	CreatedAt     time.Time
+	ActivatedAt   *time.Time
+	DeactivatedAt *time.Time
+	RetiredAt     *time.Time
+}
@@ -6,19 +6,26 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	RoleUser  = "USER"
+	RoleAdmin = "ADMIN"
+)
+
 type User struct {
-	ID           uuid.UUID
-	Email        string
-	PasswordHash string
-	Role         string
-	CreatedAt    time.Time
+	ID              uuid.UUID
+	Email           string
+	PasswordHash    string
+	Role            string
+	CreatedAt       time.Time
+	TokensRevokedAt *time.Time
 }
 
 type RefreshToken struct {
 	ID        uuid.UUID
 	UserID    uuid.UUID
-	Token     string
+	FamilyID  uuid.UUID
+	TokenHash string
 	ExpiresAt time.Time
+	RotatedAt *time.Time
 	CreatedAt time.Time
 }
-
@@ -0,0 +1,45 @@
+// Package notify tells users about events on their account out of band, for
+// example by email, so API responses don't have to reveal them.
+package notify
+
+import (
+	"context"
+
+	"github.com/randhir/aegis-core/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Notifier delivers account notices. Implementations should queue the message
+// rather than send it inline, so responses take as long whichever notice is
+// sent.
+type Notifier interface {
+	// AccountCreated tells the owner of email that their registration succeeded
+	AccountCreated(ctx context.Context, email string) error
+	// AccountExists tells the owner of email that someone tried to register it
+	// again, and how to sign in or reset their password instead
+	AccountExists(ctx context.Context, email string) error
+}
+
+// LogNotifier writes notices to the log instead of delivering them, for
+// development and until a delivery channel is configured
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) AccountCreated(ctx context.Context, email string) error {
+	logger.Info("Notice: account created",
+		zap.String("email", email),
+	)
+	return nil
+}
+
+func (n *LogNotifier) AccountExists(ctx context.Context, email string) error {
+	logger.Info("Notice: registration attempted for existing account",
+		zap.String("email", email),
+	)
+	return nil
+}
+
+var _ Notifier = (*LogNotifier)(nil)
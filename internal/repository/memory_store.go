@@ -0,0 +1,866 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/models"
+)
+
+// MemoryUserStore is an in-process UserStore for tests and single-node development
+type MemoryUserStore struct {
+	mu      sync.RWMutex
+	users   map[uuid.UUID]models.User
+	byEmail map[string]uuid.UUID
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users:   make(map[uuid.UUID]models.User),
+		byEmail: make(map[string]uuid.UUID),
+	}
+}
+
+func (s *MemoryUserStore) CreateUser(ctx context.Context, email, passwordHash, role string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.byEmail[email]; exists {
+		return nil, ErrEmailExists
+	}
+
+	user := models.User{
+		ID:           uuid.New(),
+		Email:        email,
+		PasswordHash: passwordHash,
+		Role:         role,
+		CreatedAt:    time.Now(),
+	}
+	s.users[user.ID] = user
+	s.byEmail[email] = user.ID
+
+	return &user, nil
+}
+
+func (s *MemoryUserStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userID, exists := s.byEmail[email]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	user := s.users[userID]
+	return &user, nil
+}
+
+func (s *MemoryUserStore) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+func (s *MemoryUserStore) UserExistsByEmail(ctx context.Context, email string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.byEmail[email]
+	return exists, nil
+}
+
+func (s *MemoryUserStore) ListUsers(ctx context.Context) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]models.User, 0, len(s.users))
+	for _, user := range s.users {
+		// Match the Postgres store, which does not select password hashes
+		user.PasswordHash = ""
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].CreatedAt.After(users[j].CreatedAt)
+	})
+
+	return users, nil
+}
+
+func (s *MemoryUserStore) UpdateUserRole(ctx context.Context, userID uuid.UUID, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	user.Role = role
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	user.PasswordHash = passwordHash
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateUserPasswordIfUnchanged(ctx context.Context, userID uuid.UUID, oldHash, newHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists || user.PasswordHash != oldHash {
+		return ErrPasswordChanged
+	}
+
+	user.PasswordHash = newHash
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	user.TokensRevokedAt = &revokedAt
+	s.users[userID] = user
+	return nil
+}
+
+// MemoryRefreshTokenStore is an in-process RefreshTokenStore for tests and single-node development
+type MemoryRefreshTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[uuid.UUID]models.RefreshToken
+}
+
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{
+		tokens: make(map[uuid.UUID]models.RefreshToken),
+	}
+}
+
+func (s *MemoryRefreshTokenStore) CreateRefreshToken(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID, familyID uuid.UUID, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refreshToken := models.RefreshToken{
+		ID:        tokenID,
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	s.tokens[tokenID] = refreshToken
+
+	return &refreshToken, nil
+}
+
+func (s *MemoryRefreshTokenStore) GetRefreshTokenByTokenHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, refreshToken := range s.tokens {
+		if refreshToken.TokenHash == tokenHash {
+			return &refreshToken, nil
+		}
+	}
+
+	return nil, ErrRefreshTokenNotFound
+}
+
+func (s *MemoryRefreshTokenStore) GetRefreshTokenByID(ctx context.Context, tokenID uuid.UUID) (*models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	refreshToken, exists := s.tokens[tokenID]
+	if !exists {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	return &refreshToken, nil
+}
+
+// ClaimLegacyRefreshToken never matches: the in-memory store has no rows from before hashing
+func (s *MemoryRefreshTokenStore) ClaimLegacyRefreshToken(ctx context.Context, tokenID uuid.UUID, token string, tokenHash string) (*models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrRefreshTokenNotFound
+}
+
+func (s *MemoryRefreshTokenStore) RotateRefreshToken(ctx context.Context, oldTokenID uuid.UUID, newTokenID uuid.UUID, newTokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldToken, exists := s.tokens[oldTokenID]
+	if !exists {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if oldToken.RotatedAt != nil {
+		return nil, ErrRefreshTokenRotated
+	}
+
+	now := time.Now()
+	oldToken.RotatedAt = &now
+	s.tokens[oldTokenID] = oldToken
+
+	refreshToken := models.RefreshToken{
+		ID:        newTokenID,
+		UserID:    oldToken.UserID,
+		FamilyID:  oldToken.FamilyID,
+		TokenHash: newTokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	s.tokens[newTokenID] = refreshToken
+
+	return &refreshToken, nil
+}
+
+func (s *MemoryRefreshTokenStore) DeleteRefreshToken(ctx context.Context, tokenID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tokens[tokenID]; !exists {
+		return ErrRefreshTokenNotFound
+	}
+
+	delete(s.tokens, tokenID)
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) DeleteRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for tokenID, refreshToken := range s.tokens {
+		if refreshToken.FamilyID == familyID {
+			delete(s.tokens, tokenID)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryRefreshTokenStore) DeleteRefreshTokensByUserID(ctx context.Context, userID uuid.UUID) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for tokenID, refreshToken := range s.tokens {
+		if refreshToken.UserID == userID {
+			delete(s.tokens, tokenID)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// MemorySessionStore is an in-process SessionStore for tests and single-node development
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[uuid.UUID]models.Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[uuid.UUID]models.Session),
+	}
+}
+
+func (s *MemorySessionStore) CreateSession(ctx context.Context, session models.Session) (*models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	s.sessions[session.ID] = session
+
+	return &session, nil
+}
+
+func (s *MemorySessionStore) GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+
+	return &session, nil
+}
+
+func (s *MemorySessionStore) ListSessionsByUserID(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var sessions []models.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *MemorySessionStore) TouchSession(ctx context.Context, sessionID uuid.UUID, ipAddress string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	session.LastUsedAt = time.Now()
+	session.IPAddress = ipAddress
+	session.ExpiresAt = expiresAt
+	s.sessions[sessionID] = session
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[sessionID]; !exists {
+		return ErrSessionNotFound
+	}
+
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteSessionsByUserID(ctx context.Context, userID uuid.UUID) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for sessionID, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, sessionID)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// MemorySigningKeyStore is an in-process SigningKeyStore for tests and single-node development
+type MemorySigningKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]models.SigningKey
+}
+
+func NewMemorySigningKeyStore() *MemorySigningKeyStore {
+	return &MemorySigningKeyStore{
+		keys: make(map[string]models.SigningKey),
+	}
+}
+
+func (s *MemorySigningKeyStore) CreateSigningKey(ctx context.Context, key models.SigningKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.CreatedAt = time.Now()
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *MemorySigningKeyStore) GetSigningKey(ctx context.Context, keyID string) (*models.SigningKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, exists := s.keys[keyID]
+	if !exists {
+		return nil, ErrSigningKeyNotFound
+	}
+
+	return &key, nil
+}
+
+func (s *MemorySigningKeyStore) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]models.SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (s *MemorySigningKeyStore) PromoteSigningKey(ctx context.Context, keyID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, exists := s.keys[keyID]
+	if !exists {
+		return ErrSigningKeyNotFound
+	}
+	if key.Status != models.SigningKeyStaged {
+		return ErrSigningKeyNotStaged
+	}
+
+	now := time.Now()
+	for id, other := range s.keys {
+		if other.Purpose == key.Purpose && other.Status == models.SigningKeyActive {
+			other.Status = models.SigningKeyInactive
+			other.DeactivatedAt = &now
+			s.keys[id] = other
+		}
+	}
+
+	key.Status = models.SigningKeyActive
+	key.ActivatedAt = &now
+	s.keys[keyID] = key
+	return nil
+}
+
+func (s *MemorySigningKeyStore) RetireSigningKey(ctx context.Context, keyID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, exists := s.keys[keyID]
+	if !exists || (key.Status != models.SigningKeyStaged && key.Status != models.SigningKeyInactive) {
+		return ErrSigningKeyNotFound
+	}
+
+	now := time.Now()
+	key.Status = models.SigningKeyRetired
+	key.RetiredAt = &now
+	key.EncryptedKey = nil
+	s.keys[keyID] = key
+	return nil
+}
+
+// MemoryMFAStore is an in-process MFAStore for tests and single-node development
+type MemoryMFAStore struct {
+	mu            sync.Mutex
+	enrollments   map[uuid.UUID]models.MFAEnrollment
+	recoveryCodes map[uuid.UUID]map[string]bool
+}
+
+func NewMemoryMFAStore() *MemoryMFAStore {
+	return &MemoryMFAStore{
+		enrollments:   make(map[uuid.UUID]models.MFAEnrollment),
+		recoveryCodes: make(map[uuid.UUID]map[string]bool),
+	}
+}
+
+func (s *MemoryMFAStore) SaveMFAEnrollment(ctx context.Context, userID uuid.UUID, encryptedSecret []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if enrollment, exists := s.enrollments[userID]; exists && enrollment.ConfirmedAt != nil {
+		return ErrMFAAlreadyEnabled
+	}
+
+	s.enrollments[userID] = models.MFAEnrollment{
+		UserID:          userID,
+		EncryptedSecret: encryptedSecret,
+		CreatedAt:       time.Now(),
+	}
+	return nil
+}
+
+func (s *MemoryMFAStore) GetMFAEnrollment(ctx context.Context, userID uuid.UUID) (*models.MFAEnrollment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, exists := s.enrollments[userID]
+	if !exists {
+		return nil, ErrMFANotEnrolled
+	}
+
+	return &enrollment, nil
+}
+
+func (s *MemoryMFAStore) ConfirmMFAEnrollment(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, exists := s.enrollments[userID]
+	if !exists || enrollment.ConfirmedAt != nil {
+		return ErrMFANotEnrolled
+	}
+
+	now := time.Now()
+	enrollment.ConfirmedAt = &now
+	enrollment.LastUsedStep = step
+	s.enrollments[userID] = enrollment
+
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, codeHash := range recoveryCodeHashes {
+		codes[codeHash] = true
+	}
+	s.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (s *MemoryMFAStore) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollment, exists := s.enrollments[userID]
+	if !exists || enrollment.ConfirmedAt == nil || enrollment.LastUsedStep >= step {
+		return ErrTOTPStepUsed
+	}
+
+	enrollment.LastUsedStep = step
+	s.enrollments[userID] = enrollment
+	return nil
+}
+
+func (s *MemoryMFAStore) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.recoveryCodes[userID][codeHash] {
+		return ErrRecoveryCodeNotFound
+	}
+
+	delete(s.recoveryCodes[userID], codeHash)
+	return nil
+}
+
+func (s *MemoryMFAStore) DeleteMFAEnrollment(ctx context.Context, userID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.enrollments[userID]; !exists {
+		return ErrMFANotEnrolled
+	}
+
+	delete(s.enrollments, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+// MemoryPasskeyStore is an in-process PasskeyStore for tests and single-node development
+type MemoryPasskeyStore struct {
+	mu       sync.Mutex
+	passkeys map[string]models.Passkey
+}
+
+func NewMemoryPasskeyStore() *MemoryPasskeyStore {
+	return &MemoryPasskeyStore{
+		passkeys: make(map[string]models.Passkey),
+	}
+}
+
+func (s *MemoryPasskeyStore) CreatePasskey(ctx context.Context, passkey models.Passkey) (*models.Passkey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.passkeys[string(passkey.ID)]; exists {
+		return nil, ErrPasskeyExists
+	}
+
+	passkey.CreatedAt = time.Now()
+	s.passkeys[string(passkey.ID)] = passkey
+	return &passkey, nil
+}
+
+func (s *MemoryPasskeyStore) GetPasskey(ctx context.Context, credentialID []byte) (*models.Passkey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	passkey, exists := s.passkeys[string(credentialID)]
+	if !exists {
+		return nil, ErrPasskeyNotFound
+	}
+
+	return &passkey, nil
+}
+
+func (s *MemoryPasskeyStore) ListPasskeysByUserID(ctx context.Context, userID uuid.UUID) ([]models.Passkey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var passkeys []models.Passkey
+	for _, passkey := range s.passkeys {
+		if passkey.UserID == userID {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+
+	sort.Slice(passkeys, func(i, j int) bool {
+		return passkeys[i].CreatedAt.Before(passkeys[j].CreatedAt)
+	})
+
+	return passkeys, nil
+}
+
+func (s *MemoryPasskeyStore) UsePasskey(ctx context.Context, credentialID []byte, signCount int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	passkey, exists := s.passkeys[string(credentialID)]
+	if !exists {
+		return ErrPasskeyNotFound
+	}
+	if !(passkey.SignCount < signCount || (passkey.SignCount == 0 && signCount == 0)) {
+		return ErrPasskeySignCount
+	}
+
+	now := time.Now()
+	passkey.SignCount = signCount
+	passkey.LastUsedAt = &now
+	s.passkeys[string(credentialID)] = passkey
+	return nil
+}
+
+func (s *MemoryPasskeyStore) DeletePasskey(ctx context.Context, userID uuid.UUID, credentialID []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	passkey, exists := s.passkeys[string(credentialID)]
+	if !exists || passkey.UserID != userID {
+		return ErrPasskeyNotFound
+	}
+
+	delete(s.passkeys, string(credentialID))
+	return nil
+}
+
+// MemorySecurityEventStore is an in-process SecurityEventStore for tests and single-node development
+type MemorySecurityEventStore struct {
+	mu     sync.RWMutex
+	events []models.SecurityEvent
+}
+
+func NewMemorySecurityEventStore() *MemorySecurityEventStore {
+	return &MemorySecurityEventStore{}
+}
+
+func (s *MemorySecurityEventStore) RecordSecurityEvent(ctx context.Context, eventType string, userID *uuid.UUID, details string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, models.SecurityEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		UserID:    userID,
+		Details:   details,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+func (s *MemorySecurityEventStore) ListSecurityEvents(ctx context.Context, since time.Time) ([]models.SecurityEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.SecurityEvent
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if !s.events[i].CreatedAt.Before(since) {
+			events = append(events, s.events[i])
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/models"
+)
+
+func TestMemoryRotateRefreshTokenOnce(t *testing.T) {
+	store := NewMemoryRefreshTokenStore()
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	oldTokenID := uuid.New()
+	if _, err := store.CreateRefreshToken(ctx, uuid.New(), oldTokenID, uuid.New(), "old-hash", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	var rotated, lost atomic.Int64
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.RotateRefreshToken(ctx, oldTokenID, uuid.New(), uuid.NewString(), expiresAt)
+			switch {
+			case err == nil:
+				rotated.Add(1)
+			case errors.Is(err, ErrRefreshTokenRotated):
+				lost.Add(1)
+			default:
+				t.Errorf("RotateRefreshToken() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rotated.Load() != 1 || lost.Load() != 49 {
+		t.Fatalf("rotated %d times with %d losers, want 1 and 49", rotated.Load(), lost.Load())
+	}
+}
+
+func TestMemoryUpdateUserPasswordIfUnchanged(t *testing.T) {
+	store := NewMemoryUserStore()
+	ctx := context.Background()
+
+	user, err := store.CreateUser(ctx, "user@example.com", "old-hash", models.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateUserPassword(ctx, user.ID, "reset-hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.UpdateUserPasswordIfUnchanged(ctx, user.ID, "old-hash", "rehashed"); !errors.Is(err, ErrPasswordChanged) {
+		t.Fatalf("UpdateUserPasswordIfUnchanged() after a reset error = %v, want ErrPasswordChanged", err)
+	}
+	if err := store.UpdateUserPasswordIfUnchanged(ctx, user.ID, "reset-hash", "rehashed"); err != nil {
+		t.Fatalf("UpdateUserPasswordIfUnchanged() error = %v", err)
+	}
+
+	stored, err := store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.PasswordHash != "rehashed" {
+		t.Fatalf("PasswordHash = %q, want %q", stored.PasswordHash, "rehashed")
+	}
+}
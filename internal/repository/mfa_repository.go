@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/models"
+)
+
+// PostgresMFAStore is an MFAStore backed by the mfa_enrollments and
+// mfa_recovery_codes tables
+type PostgresMFAStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresMFAStore(db *sql.DB, timeout time.Duration) *PostgresMFAStore {
+	return &PostgresMFAStore{db: db, timeout: timeout}
+}
+
+func (s *PostgresMFAStore) SaveMFAEnrollment(ctx context.Context, userID uuid.UUID, encryptedSecret []byte) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO mfa_enrollments (user_id, encrypted_secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET encrypted_secret = EXCLUDED.encrypted_secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+		WHERE mfa_enrollments.confirmed_at IS NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, encryptedSecret)
+	if err != nil {
+		return fmt.Errorf("failed to save mfa enrollment: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+func (s *PostgresMFAStore) GetMFAEnrollment(ctx context.Context, userID uuid.UUID) (*models.MFAEnrollment, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		SELECT user_id, encrypted_secret, last_used_step, created_at, confirmed_at
+		FROM mfa_enrollments
+		WHERE user_id = $1
+	`
+
+	var enrollment models.MFAEnrollment
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&enrollment.UserID,
+		&enrollment.EncryptedSecret,
+		&enrollment.LastUsedStep,
+		&enrollment.CreatedAt,
+		&enrollment.ConfirmedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, fmt.Errorf("failed to get mfa enrollment: %w", contextError(ctx, err))
+	}
+
+	return &enrollment, nil
+}
+
+func (s *PostgresMFAStore) ConfirmMFAEnrollment(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", contextError(ctx, err))
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE mfa_enrollments
+		SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL
+	`, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to confirm mfa enrollment: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFANotEnrolled
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mfa enrollment: %w", contextError(ctx, err))
+	}
+
+	return nil
+}
+
+func (s *PostgresMFAStore) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE mfa_enrollments
+		SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to use totp step: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPStepUsed
+	}
+
+	return nil
+}
+
+func (s *PostgresMFAStore) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresMFAStore) DeleteMFAEnrollment(ctx context.Context, userID uuid.UUID) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM mfa_enrollments
+		WHERE user_id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete mfa enrollment: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFANotEnrolled
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uuid.UUID, codeHashes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", contextError(ctx, err))
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, codeHash,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create recovery code: %w", contextError(ctx, err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/models"
+)
+
+// PostgresPasskeyStore is a PasskeyStore backed by the passkeys table
+type PostgresPasskeyStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresPasskeyStore(db *sql.DB, timeout time.Duration) *PostgresPasskeyStore {
+	return &PostgresPasskeyStore{db: db, timeout: timeout}
+}
+
+func (s *PostgresPasskeyStore) CreatePasskey(ctx context.Context, passkey models.Passkey) (*models.Passkey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO passkeys (id, user_id, name, public_key, algorithm, aaguid, sign_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, user_id, name, public_key, algorithm, aaguid, sign_count, created_at, last_used_at
+	`
+
+	created, err := scanPasskey(s.db.QueryRowContext(ctx, query,
+		passkey.ID,
+		passkey.UserID,
+		passkey.Name,
+		passkey.PublicKey,
+		passkey.Algorithm,
+		passkey.AAGUID,
+		passkey.SignCount,
+	))
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return nil, ErrPasskeyExists
+		}
+		return nil, fmt.Errorf("failed to create passkey: %w", contextError(ctx, err))
+	}
+
+	return created, nil
+}
+
+func (s *PostgresPasskeyStore) GetPasskey(ctx context.Context, credentialID []byte) (*models.Passkey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, name, public_key, algorithm, aaguid, sign_count, created_at, last_used_at
+		FROM passkeys
+		WHERE id = $1
+	`
+
+	passkey, err := scanPasskey(s.db.QueryRowContext(ctx, query, credentialID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPasskeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get passkey: %w", contextError(ctx, err))
+	}
+
+	return passkey, nil
+}
+
+func (s *PostgresPasskeyStore) ListPasskeysByUserID(ctx context.Context, userID uuid.UUID) ([]models.Passkey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, name, public_key, algorithm, aaguid, sign_count, created_at, last_used_at
+		FROM passkeys
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", contextError(ctx, err))
+	}
+	defer rows.Close()
+
+	var passkeys []models.Passkey
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan passkey: %w", contextError(ctx, err))
+		}
+		passkeys = append(passkeys, *passkey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating passkeys: %w", contextError(ctx, err))
+	}
+
+	return passkeys, nil
+}
+
+func (s *PostgresPasskeyStore) UsePasskey(ctx context.Context, credentialID []byte, signCount int64) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// Both parts see the same snapshot, so a passkey deleted concurrently is
+	// reported as missing rather than as a counter that went backwards
+	query := `
+		WITH passkey AS (
+			SELECT id FROM passkeys WHERE id = $1
+		), used AS (
+			UPDATE passkeys
+			SET sign_count = $2, last_used_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))
+			RETURNING id
+		)
+		SELECT EXISTS (SELECT 1 FROM passkey), EXISTS (SELECT 1 FROM used)
+	`
+
+	var found, used bool
+	err := s.db.QueryRowContext(ctx, query, credentialID, signCount).Scan(&found, &used)
+	if err != nil {
+		return fmt.Errorf("failed to use passkey: %w", contextError(ctx, err))
+	}
+
+	if !found {
+		return ErrPasskeyNotFound
+	}
+	if !used {
+		return ErrPasskeySignCount
+	}
+
+	return nil
+}
+
+func (s *PostgresPasskeyStore) DeletePasskey(ctx context.Context, userID uuid.UUID, credentialID []byte) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM passkeys
+		WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := s.db.ExecContext(ctx, query, credentialID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete passkey: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+
+	return nil
+}
+
+func scanPasskey(row rowScanner) (*models.Passkey, error) {
+	var passkey models.Passkey
+	err := row.Scan(
+		&passkey.ID,
+		&passkey.UserID,
+		&passkey.Name,
+		&passkey.PublicKey,
+		&passkey.Algorithm,
+		&passkey.AAGUID,
+		&passkey.SignCount,
+		&passkey.CreatedAt,
+		&passkey.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
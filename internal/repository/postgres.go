@@ -1,8 +1,11 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	_ "github.com/lib/pq"
 	"go.uber.org/zap"
@@ -11,11 +14,11 @@ import (
 	"github.com/randhir/aegis-core/internal/logger"
 )
 
-var DB *sql.DB
-
-func ConnectPostgres() error {
-	cfg := config.AppConfig.Database
+// SchemaVersion is the migration version the repository queries are written against.
+// The server refuses to start against a database that is behind it.
+const SchemaVersion = 9
 
+func ConnectPostgres(cfg config.DatabaseConfig) (*sql.DB, error) {
 	dsn := fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.Host,
@@ -27,14 +30,16 @@ func ConnectPostgres() error {
 
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		return fmt.Errorf("failed to open database connection: %w", err)
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
-	}
+	ctx, cancel := withTimeout(context.Background(), cfg.QueryTimeout)
+	defer cancel()
 
-	DB = db
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
 
 	logger.Info("PostgreSQL connected successfully",
 		zap.String("host", cfg.Host),
@@ -42,13 +47,30 @@ func ConnectPostgres() error {
 		zap.String("database", cfg.Name),
 	)
 
-	return nil
+	return db, nil
 }
 
-func ClosePostgres() error {
-	if DB != nil {
-		return DB.Close()
+func ClosePostgres(db *sql.DB) error {
+	if db != nil {
+		return db.Close()
 	}
 	return nil
 }
 
+// withTimeout bounds a single store operation; a zero timeout only inherits the caller's deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// contextError attaches the context's error to err once the operation's deadline
+// has passed or the caller went away, so callers can tell timeouts from failures
+func contextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil || errors.Is(err, ctxErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ctxErr, err)
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/migrate"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/migrations"
+)
+
+// testPostgres connects to the database in TEST_DATABASE_URL and migrates it,
+// or skips the test when none is configured
+func testPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.NewMigrator(db, migrations.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// testPostgresUser creates a user that is deleted, along with their sessions
+// and tokens, when the test ends
+func testPostgresUser(t *testing.T, db *sql.DB) *models.User {
+	t.Helper()
+	users := NewPostgresUserStore(db, 5*time.Second)
+	user, err := users.CreateUser(context.Background(), uuid.NewString()+"@example.com", "unused", models.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec(`DELETE FROM users WHERE id = $1`, user.ID); err != nil {
+			t.Error(err)
+		}
+	})
+	return user
+}
+
+// Concurrent rotations of one token race for its row lock; exactly one wins
+func TestPostgresRotateRefreshTokenOnce(t *testing.T) {
+	db := testPostgres(t)
+	user := testPostgresUser(t, db)
+	store := NewPostgresRefreshTokenStore(db, 5*time.Second)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	session, err := NewPostgresSessionStore(db, 5*time.Second).CreateSession(ctx, models.Session{ID: uuid.New(), UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldTokenID := uuid.New()
+	if _, err := store.CreateRefreshToken(ctx, user.ID, oldTokenID, session.ID, uuid.NewString(), expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	var rotated, lost atomic.Int64
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := store.RotateRefreshToken(ctx, oldTokenID, uuid.New(), uuid.NewString(), expiresAt)
+			switch {
+			case err == nil:
+				rotated.Add(1)
+			case errors.Is(err, ErrRefreshTokenRotated):
+				lost.Add(1)
+			default:
+				t.Errorf("RotateRefreshToken() error = %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if rotated.Load() != 1 || lost.Load() != 19 {
+		t.Fatalf("rotated %d times with %d losers, want 1 and 19", rotated.Load(), lost.Load())
+	}
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/models"
+)
+
+// PostgresSecurityEventStore is a SecurityEventStore backed by the security_events table
+type PostgresSecurityEventStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresSecurityEventStore(db *sql.DB, timeout time.Duration) *PostgresSecurityEventStore {
+	return &PostgresSecurityEventStore{db: db, timeout: timeout}
+}
+
+func (s *PostgresSecurityEventStore) RecordSecurityEvent(ctx context.Context, eventType string, userID *uuid.UUID, details string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO security_events (id, event_type, user_id, details)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, uuid.New(), eventType, userID, details)
+	if err != nil {
+		return fmt.Errorf("failed to record security event: %w", contextError(ctx, err))
+	}
+
+	return nil
+}
+
+func (s *PostgresSecurityEventStore) ListSecurityEvents(ctx context.Context, since time.Time) ([]models.SecurityEvent, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, event_type, user_id, details, created_at
+		FROM security_events
+		WHERE created_at >= $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list security events: %w", contextError(ctx, err))
+	}
+	defer rows.Close()
+
+	var events []models.SecurityEvent
+	for rows.Next() {
+		var event models.SecurityEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.UserID,
+			&event.Details,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan security event: %w", contextError(ctx, err))
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating security events: %w", contextError(ctx, err))
+	}
+
+	return events, nil
+}
@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/models"
+)
+
+// PostgresSessionStore is a SessionStore backed by the sessions table
+type PostgresSessionStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresSessionStore(db *sql.DB, timeout time.Duration) *PostgresSessionStore {
+	return &PostgresSessionStore{db: db, timeout: timeout}
+}
+
+func (s *PostgresSessionStore) CreateSession(ctx context.Context, session models.Session) (*models.Session, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO sessions (id, user_id, device_name, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, user_id, device_name, user_agent, ip_address, created_at, last_used_at, expires_at
+	`
+
+	var created models.Session
+	err := s.db.QueryRowContext(ctx, query,
+		session.ID,
+		session.UserID,
+		session.DeviceName,
+		session.UserAgent,
+		session.IPAddress,
+		session.ExpiresAt,
+	).Scan(
+		&created.ID,
+		&created.UserID,
+		&created.DeviceName,
+		&created.UserAgent,
+		&created.IPAddress,
+		&created.CreatedAt,
+		&created.LastUsedAt,
+		&created.ExpiresAt,
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", contextError(ctx, err))
+	}
+
+	return &created, nil
+}
+
+func (s *PostgresSessionStore) GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, device_name, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE id = $1
+	`
+
+	var session models.Session
+	err := s.db.QueryRowContext(ctx, query, sessionID).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.DeviceName,
+		&session.UserAgent,
+		&session.IPAddress,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", contextError(ctx, err))
+	}
+
+	return &session, nil
+}
+
+func (s *PostgresSessionStore) ListSessionsByUserID(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, device_name, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_used_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", contextError(ctx, err))
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.DeviceName,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", contextError(ctx, err))
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", contextError(ctx, err))
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records that the session's refresh token was just rotated
+func (s *PostgresSessionStore) TouchSession(ctx context.Context, sessionID uuid.UUID, ipAddress string, expiresAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE sessions
+		SET last_used_at = CURRENT_TIMESTAMP, ip_address = $2, expires_at = $3
+		WHERE id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, sessionID, ipAddress, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// DeleteSession removes a session; its refresh tokens are removed with it
+func (s *PostgresSessionStore) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM sessions
+		WHERE id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresSessionStore) DeleteSessionsByUserID(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM sessions
+		WHERE user_id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	return rowsAffected, nil
+}
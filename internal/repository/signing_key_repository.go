@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/randhir/aegis-core/internal/models"
+)
+
+// PostgresSigningKeyStore is a SigningKeyStore backed by the signing_keys table
+type PostgresSigningKeyStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresSigningKeyStore(db *sql.DB, timeout time.Duration) *PostgresSigningKeyStore {
+	return &PostgresSigningKeyStore{db: db, timeout: timeout}
+}
+
+func (s *PostgresSigningKeyStore) CreateSigningKey(ctx context.Context, key models.SigningKey) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO signing_keys (id, purpose, algorithm, status, encrypted_key)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, key.ID, key.Purpose, key.Algorithm, key.Status, key.EncryptedKey)
+	if err != nil {
+		return fmt.Errorf("failed to create signing key: %w", contextError(ctx, err))
+	}
+
+	return nil
+}
+
+func (s *PostgresSigningKeyStore) GetSigningKey(ctx context.Context, keyID string) (*models.SigningKey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, purpose, algorithm, status, encrypted_key, created_at, activated_at, deactivated_at, retired_at
+		FROM signing_keys
+		WHERE id = $1
+	`
+
+	key, err := scanSigningKey(s.db.QueryRowContext(ctx, query, keyID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSigningKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get signing key: %w", contextError(ctx, err))
+	}
+
+	return key, nil
+}
+
+func (s *PostgresSigningKeyStore) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, purpose, algorithm, status, encrypted_key, created_at, activated_at, deactivated_at, retired_at
+		FROM signing_keys
+		ORDER BY created_at
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", contextError(ctx, err))
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		key, err := scanSigningKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %w", contextError(ctx, err))
+		}
+		keys = append(keys, *key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating signing keys: %w", contextError(ctx, err))
+	}
+
+	return keys, nil
+}
+
+func (s *PostgresSigningKeyStore) PromoteSigningKey(ctx context.Context, keyID string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", contextError(ctx, err))
+	}
+	defer tx.Rollback()
+
+	var purpose, status string
+	err = tx.QueryRowContext(ctx,
+		`SELECT purpose, status FROM signing_keys WHERE id = $1 FOR UPDATE`,
+		keyID,
+	).Scan(&purpose, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSigningKeyNotFound
+		}
+		return fmt.Errorf("failed to lock signing key: %w", contextError(ctx, err))
+	}
+
+	if status != models.SigningKeyStaged {
+		return ErrSigningKeyNotStaged
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE signing_keys
+		SET status = $2, deactivated_at = CURRENT_TIMESTAMP
+		WHERE purpose = $1 AND status = $3
+	`, purpose, models.SigningKeyInactive, models.SigningKeyActive)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate signing key: %w", contextError(ctx, err))
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE signing_keys
+		SET status = $2, activated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, keyID, models.SigningKeyActive)
+	if err != nil {
+		return fmt.Errorf("failed to activate signing key: %w", contextError(ctx, err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit signing key promotion: %w", contextError(ctx, err))
+	}
+
+	return nil
+}
+
+func (s *PostgresSigningKeyStore) RetireSigningKey(ctx context.Context, keyID string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE signing_keys
+		SET status = $2, retired_at = CURRENT_TIMESTAMP, encrypted_key = NULL
+		WHERE id = $1 AND status IN ($3, $4)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, keyID, models.SigningKeyRetired, models.SigningKeyStaged, models.SigningKeyInactive)
+	if err != nil {
+		return fmt.Errorf("failed to retire signing key: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrSigningKeyNotFound
+	}
+
+	return nil
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSigningKey(row rowScanner) (*models.SigningKey, error) {
+	var key models.SigningKey
+	err := row.Scan(
+		&key.ID,
+		&key.Purpose,
+		&key.Algorithm,
+		&key.Status,
+		&key.EncryptedKey,
+		&key.CreatedAt,
+		&key.ActivatedAt,
+		&key.DeactivatedAt,
+		&key.RetiredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
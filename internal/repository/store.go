@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/models"
+)
+
+var (
+	ErrUserNotFound         = errors.New("user not found")
+	ErrEmailExists          = errors.New("email already exists")
+	ErrPasswordChanged      = errors.New("password changed meanwhile")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenRotated  = errors.New("refresh token already rotated")
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrSigningKeyNotFound   = errors.New("signing key not found")
+	ErrSigningKeyNotStaged  = errors.New("signing key is not staged")
+	ErrMFANotEnrolled       = errors.New("mfa not enrolled")
+	ErrMFAAlreadyEnabled    = errors.New("mfa already enabled")
+	ErrTOTPStepUsed         = errors.New("totp code already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	ErrPasskeyNotFound      = errors.New("passkey not found")
+	ErrPasskeyExists        = errors.New("passkey already registered")
+	ErrPasskeySignCount     = errors.New("passkey signature counter did not increase")
+)
+
+// UserStore persists user accounts
+type UserStore interface {
+	CreateUser(ctx context.Context, email, passwordHash, role string) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	UserExistsByEmail(ctx context.Context, email string) (bool, error)
+	ListUsers(ctx context.Context) ([]models.User, error)
+	UpdateUserRole(ctx context.Context, userID uuid.UUID, role string) error
+	UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error
+	// UpdateUserPasswordIfUnchanged replaces the password hash only while it
+	// is still oldHash, and returns ErrPasswordChanged otherwise
+	UpdateUserPasswordIfUnchanged(ctx context.Context, userID uuid.UUID, oldHash, newHash string) error
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error
+}
+
+// RefreshTokenStore persists issued refresh tokens. Only keyed hashes of the
+// tokens are stored; the raw token never reaches the store except to migrate
+// rows written before hashing through ClaimLegacyRefreshToken.
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID, familyID uuid.UUID, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error)
+	GetRefreshTokenByTokenHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	GetRefreshTokenByID(ctx context.Context, tokenID uuid.UUID) (*models.RefreshToken, error)
+	ClaimLegacyRefreshToken(ctx context.Context, tokenID uuid.UUID, token string, tokenHash string) (*models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldTokenID uuid.UUID, newTokenID uuid.UUID, newTokenHash string, expiresAt time.Time) (*models.RefreshToken, error)
+	DeleteRefreshToken(ctx context.Context, tokenID uuid.UUID) error
+	DeleteRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) (int64, error)
+	DeleteRefreshTokensByUserID(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// SessionStore persists per-device login sessions. A session's ID is the family
+// ID of its refresh tokens.
+type SessionStore interface {
+	CreateSession(ctx context.Context, session models.Session) (*models.Session, error)
+	GetSession(ctx context.Context, sessionID uuid.UUID) (*models.Session, error)
+	// ListSessionsByUserID returns the user's unexpired sessions, most recently used first
+	ListSessionsByUserID(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	TouchSession(ctx context.Context, sessionID uuid.UUID, ipAddress string, expiresAt time.Time) error
+	DeleteSession(ctx context.Context, sessionID uuid.UUID) error
+	DeleteSessionsByUserID(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// SigningKeyStore persists the token signing keyring
+type SigningKeyStore interface {
+	CreateSigningKey(ctx context.Context, key models.SigningKey) error
+	GetSigningKey(ctx context.Context, keyID string) (*models.SigningKey, error)
+	ListSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	// PromoteSigningKey makes a staged key the active key of its purpose and
+	// demotes the previous active key to inactive
+	PromoteSigningKey(ctx context.Context, keyID string) error
+	// RetireSigningKey retires a staged or inactive key and erases its material;
+	// it fails with ErrSigningKeyNotFound for any other key
+	RetireSigningKey(ctx context.Context, keyID string) error
+}
+
+// MFAStore persists TOTP enrollments and their recovery codes. Secrets arrive
+// sealed and recovery codes as keyed hashes.
+type MFAStore interface {
+	// SaveMFAEnrollment starts or restarts an unconfirmed enrollment; it fails
+	// with ErrMFAAlreadyEnabled once the user's enrollment is confirmed
+	SaveMFAEnrollment(ctx context.Context, userID uuid.UUID, encryptedSecret []byte) error
+	GetMFAEnrollment(ctx context.Context, userID uuid.UUID) (*models.MFAEnrollment, error)
+	// ConfirmMFAEnrollment enables MFA, records step as used and replaces the
+	// user's recovery codes
+	ConfirmMFAEnrollment(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error
+	// UseTOTPStep records step as used; it fails with ErrTOTPStepUsed unless
+	// step is later than every step accepted before
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error
+	// UseRecoveryCode marks an unused recovery code as used, or fails with
+	// ErrRecoveryCodeNotFound
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error
+	// DeleteMFAEnrollment removes the enrollment and its recovery codes
+	DeleteMFAEnrollment(ctx context.Context, userID uuid.UUID) error
+}
+
+// PasskeyStore persists WebAuthn credentials
+type PasskeyStore interface {
+	// CreatePasskey fails with ErrPasskeyExists if the credential ID is taken
+	CreatePasskey(ctx context.Context, passkey models.Passkey) (*models.Passkey, error)
+	GetPasskey(ctx context.Context, credentialID []byte) (*models.Passkey, error)
+	ListPasskeysByUserID(ctx context.Context, userID uuid.UUID) ([]models.Passkey, error)
+	// UsePasskey records a sign-in and its signature counter. It fails with
+	// ErrPasskeyNotFound if the passkey is gone, and with ErrPasskeySignCount
+	// unless the counter increased, or it and the stored counter are both zero
+	// because the authenticator keeps none.
+	UsePasskey(ctx context.Context, credentialID []byte, signCount int64) error
+	DeletePasskey(ctx context.Context, userID uuid.UUID, credentialID []byte) error
+}
+
+// SecurityEventStore records suspicious activity for alerting
+type SecurityEventStore interface {
+	RecordSecurityEvent(ctx context.Context, eventType string, userID *uuid.UUID, details string) error
+	ListSecurityEvents(ctx context.Context, since time.Time) ([]models.SecurityEvent, error)
+}
+
+var (
+	_ UserStore          = (*PostgresUserStore)(nil)
+	_ UserStore          = (*MemoryUserStore)(nil)
+	_ RefreshTokenStore  = (*PostgresRefreshTokenStore)(nil)
+	_ RefreshTokenStore  = (*MemoryRefreshTokenStore)(nil)
+	_ SessionStore       = (*PostgresSessionStore)(nil)
+	_ SessionStore       = (*MemorySessionStore)(nil)
+	_ SigningKeyStore    = (*PostgresSigningKeyStore)(nil)
+	_ SigningKeyStore    = (*MemorySigningKeyStore)(nil)
+	_ MFAStore           = (*PostgresMFAStore)(nil)
+	_ MFAStore           = (*MemoryMFAStore)(nil)
+	_ PasskeyStore       = (*PostgresPasskeyStore)(nil)
+	_ PasskeyStore       = (*MemoryPasskeyStore)(nil)
+	_ SecurityEventStore = (*PostgresSecurityEventStore)(nil)
+	_ SecurityEventStore = (*MemorySecurityEventStore)(nil)
+)
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -10,101 +11,337 @@ import (
 	"github.com/randhir/aegis-core/internal/models"
 )
 
-func CreateRefreshToken(userID uuid.UUID, tokenID uuid.UUID, token string, expiresAt time.Time) (*models.RefreshToken, error) {
+// PostgresRefreshTokenStore is a RefreshTokenStore backed by the refresh_tokens table
+type PostgresRefreshTokenStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresRefreshTokenStore(db *sql.DB, timeout time.Duration) *PostgresRefreshTokenStore {
+	return &PostgresRefreshTokenStore{db: db, timeout: timeout}
+}
+
+func (s *PostgresRefreshTokenStore) CreateRefreshToken(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID, familyID uuid.UUID, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := `
-		INSERT INTO refresh_tokens (id, user_id, token, expires_at)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, user_id, token, expires_at, created_at
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, user_id, family_id, token_hash, expires_at, rotated_at, created_at
 	`
 
 	var refreshToken models.RefreshToken
-	err := DB.QueryRow(query, tokenID, userID, token, expiresAt).Scan(
+	err := s.db.QueryRowContext(ctx, query, tokenID, userID, familyID, tokenHash, expiresAt).Scan(
 		&refreshToken.ID,
 		&refreshToken.UserID,
-		&refreshToken.Token,
+		&refreshToken.FamilyID,
+		&refreshToken.TokenHash,
 		&refreshToken.ExpiresAt,
+		&refreshToken.RotatedAt,
 		&refreshToken.CreatedAt,
 	)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+		return nil, fmt.Errorf("failed to create refresh token: %w", contextError(ctx, err))
 	}
 
 	return &refreshToken, nil
 }
 
-func GetRefreshTokenByToken(token string) (*models.RefreshToken, error) {
+func (s *PostgresRefreshTokenStore) GetRefreshTokenByTokenHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := `
-		SELECT id, user_id, token, expires_at, created_at
+		SELECT id, user_id, family_id, token_hash, expires_at, rotated_at, created_at
 		FROM refresh_tokens
-		WHERE token = $1
+		WHERE token_hash = $1
+	`
+
+	var refreshToken models.RefreshToken
+	err := s.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&refreshToken.ID,
+		&refreshToken.UserID,
+		&refreshToken.FamilyID,
+		&refreshToken.TokenHash,
+		&refreshToken.ExpiresAt,
+		&refreshToken.RotatedAt,
+		&refreshToken.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", contextError(ctx, err))
+	}
+
+	return &refreshToken, nil
+}
+
+// ClaimLegacyRefreshToken hashes a token row written before refresh tokens were
+// stored hashed. It matches on the raw token, replaces it with tokenHash and
+// returns the row, or ErrRefreshTokenNotFound if no unhashed row matches.
+func (s *PostgresRefreshTokenStore) ClaimLegacyRefreshToken(ctx context.Context, tokenID uuid.UUID, token string, tokenHash string) (*models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE refresh_tokens
+		SET token_hash = $3, token = NULL
+		WHERE id = $1 AND token = $2 AND token_hash IS NULL
+		RETURNING id, user_id, family_id, token_hash, expires_at, rotated_at, created_at
 	`
 
 	var refreshToken models.RefreshToken
-	err := DB.QueryRow(query, token).Scan(
+	err := s.db.QueryRowContext(ctx, query, tokenID, token, tokenHash).Scan(
 		&refreshToken.ID,
 		&refreshToken.UserID,
-		&refreshToken.Token,
+		&refreshToken.FamilyID,
+		&refreshToken.TokenHash,
 		&refreshToken.ExpiresAt,
+		&refreshToken.RotatedAt,
 		&refreshToken.CreatedAt,
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("refresh token not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
 		}
-		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+		return nil, fmt.Errorf("failed to claim legacy refresh token: %w", contextError(ctx, err))
 	}
 
 	return &refreshToken, nil
 }
 
-func DeleteRefreshToken(tokenID uuid.UUID) error {
+// BackfillTokenHashes hashes up to batchSize rows that still store a raw token
+// and returns how many were updated. Call it repeatedly until it returns 0.
+func (s *PostgresRefreshTokenStore) BackfillTokenHashes(ctx context.Context, hash func(token string) (string, error), batchSize int) (int, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", contextError(ctx, err))
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, token
+		FROM refresh_tokens
+		WHERE token_hash IS NULL AND token IS NOT NULL
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := tx.QueryContext(ctx, query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select unhashed refresh tokens: %w", contextError(ctx, err))
+	}
+
+	hashes := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var tokenID uuid.UUID
+		var token string
+		if err := rows.Scan(&tokenID, &token); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan refresh token: %w", contextError(ctx, err))
+		}
+
+		tokenHash, err := hash(token)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to hash refresh token: %w", err)
+		}
+		hashes[tokenID] = tokenHash
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating refresh tokens: %w", contextError(ctx, err))
+	}
+
+	for tokenID, tokenHash := range hashes {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE refresh_tokens SET token_hash = $2, token = NULL WHERE id = $1`,
+			tokenID, tokenHash,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store refresh token hash: %w", contextError(ctx, err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit refresh token hashes: %w", contextError(ctx, err))
+	}
+
+	return len(hashes), nil
+}
+
+// RotateRefreshToken marks oldTokenID as rotated and stores its successor in the
+// same family in one transaction. The old row is locked first, so of several
+// concurrent rotations exactly one succeeds and the rest get ErrRefreshTokenRotated.
+func (s *PostgresRefreshTokenStore) RotateRefreshToken(ctx context.Context, oldTokenID uuid.UUID, newTokenID uuid.UUID, newTokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", contextError(ctx, err))
+	}
+	defer tx.Rollback()
+
+	var userID, familyID uuid.UUID
+	var rotatedAt *time.Time
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id, family_id, rotated_at FROM refresh_tokens WHERE id = $1 FOR UPDATE`,
+		oldTokenID,
+	).Scan(&userID, &familyID, &rotatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to lock refresh token: %w", contextError(ctx, err))
+	}
+
+	if rotatedAt != nil {
+		return nil, ErrRefreshTokenRotated
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET rotated_at = CURRENT_TIMESTAMP WHERE id = $1`,
+		oldTokenID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", contextError(ctx, err))
+	}
+
+	query := `
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, user_id, family_id, token_hash, expires_at, rotated_at, created_at
+	`
+
+	var refreshToken models.RefreshToken
+	err = tx.QueryRowContext(ctx, query, newTokenID, userID, familyID, newTokenHash, expiresAt).Scan(
+		&refreshToken.ID,
+		&refreshToken.UserID,
+		&refreshToken.FamilyID,
+		&refreshToken.TokenHash,
+		&refreshToken.ExpiresAt,
+		&refreshToken.RotatedAt,
+		&refreshToken.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", contextError(ctx, err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit refresh token rotation: %w", contextError(ctx, err))
+	}
+
+	return &refreshToken, nil
+}
+
+func (s *PostgresRefreshTokenStore) DeleteRefreshToken(ctx context.Context, tokenID uuid.UUID) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := `
 		DELETE FROM refresh_tokens
 		WHERE id = $1
 	`
 
-	result, err := DB.Exec(query, tokenID)
+	result, err := s.db.ExecContext(ctx, query, tokenID)
 	if err != nil {
-		return fmt.Errorf("failed to delete refresh token: %w", err)
+		return fmt.Errorf("failed to delete refresh token: %w", contextError(ctx, err))
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
 	}
 
 	if rowsAffected == 0 {
-		return errors.New("refresh token not found")
+		return ErrRefreshTokenNotFound
 	}
 
 	return nil
 }
 
-func GetRefreshTokenByID(tokenID uuid.UUID) (*models.RefreshToken, error) {
+func (s *PostgresRefreshTokenStore) GetRefreshTokenByID(ctx context.Context, tokenID uuid.UUID) (*models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := `
-		SELECT id, user_id, token, expires_at, created_at
+		SELECT id, user_id, family_id, COALESCE(token_hash, ''), expires_at, rotated_at, created_at
 		FROM refresh_tokens
 		WHERE id = $1
 	`
 
 	var refreshToken models.RefreshToken
-	err := DB.QueryRow(query, tokenID).Scan(
+	err := s.db.QueryRowContext(ctx, query, tokenID).Scan(
 		&refreshToken.ID,
 		&refreshToken.UserID,
-		&refreshToken.Token,
+		&refreshToken.FamilyID,
+		&refreshToken.TokenHash,
 		&refreshToken.ExpiresAt,
+		&refreshToken.RotatedAt,
 		&refreshToken.CreatedAt,
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("refresh token not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
 		}
-		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+		return nil, fmt.Errorf("failed to get refresh token: %w", contextError(ctx, err))
 	}
 
 	return &refreshToken, nil
 }
 
+// DeleteRefreshTokenFamily removes every token, rotated or not, descended from the same login
+func (s *PostgresRefreshTokenStore) DeleteRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE family_id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, familyID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete refresh token family: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	return rowsAffected, nil
+}
+
+func (s *PostgresRefreshTokenStore) DeleteRefreshTokensByUserID(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE user_id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete refresh tokens: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	return rowsAffected, nil
+}
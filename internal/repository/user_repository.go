@@ -1,100 +1,127 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/randhir/aegis-core/internal/models"
 )
 
-func CreateUser(email, passwordHash, role string) (*models.User, error) {
+// PostgresUserStore is a UserStore backed by the users table
+type PostgresUserStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresUserStore(db *sql.DB, timeout time.Duration) *PostgresUserStore {
+	return &PostgresUserStore{db: db, timeout: timeout}
+}
+
+func (s *PostgresUserStore) CreateUser(ctx context.Context, email, passwordHash, role string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	userID := uuid.New()
 	query := `
 		INSERT INTO users (id, email, password_hash, role)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, email, password_hash, role, created_at
+		RETURNING id, email, password_hash, role, created_at, tokens_revoked_at
 	`
 
 	var user models.User
-	err := DB.QueryRow(query, userID, email, passwordHash, role).Scan(
+	err := s.db.QueryRowContext(ctx, query, userID, email, passwordHash, role).Scan(
 		&user.ID,
 		&user.Email,
 		&user.PasswordHash,
 		&user.Role,
 		&user.CreatedAt,
+		&user.TokensRevokedAt,
 	)
 
 	if err != nil {
 		if isUniqueConstraintError(err) {
-			return nil, errors.New("email already exists")
+			return nil, ErrEmailExists
 		}
-		return nil, fmt.Errorf("failed to create user: %w", err)
+		return nil, fmt.Errorf("failed to create user: %w", contextError(ctx, err))
 	}
 
 	return &user, nil
 }
 
-func GetUserByEmail(email string) (*models.User, error) {
+func (s *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := `
-		SELECT id, email, password_hash, role, created_at
+		SELECT id, email, password_hash, role, created_at, tokens_revoked_at
 		FROM users
 		WHERE email = $1
 	`
 
 	var user models.User
-	err := DB.QueryRow(query, email).Scan(
+	err := s.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Email,
 		&user.PasswordHash,
 		&user.Role,
 		&user.CreatedAt,
+		&user.TokensRevokedAt,
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
 		}
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to get user: %w", contextError(ctx, err))
 	}
 
 	return &user, nil
 }
 
-func GetUserByID(userID uuid.UUID) (*models.User, error) {
+func (s *PostgresUserStore) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := `
-		SELECT id, email, password_hash, role, created_at
+		SELECT id, email, password_hash, role, created_at, tokens_revoked_at
 		FROM users
 		WHERE id = $1
 	`
 
 	var user models.User
-	err := DB.QueryRow(query, userID).Scan(
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
 		&user.ID,
 		&user.Email,
 		&user.PasswordHash,
 		&user.Role,
 		&user.CreatedAt,
+		&user.TokensRevokedAt,
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
 		}
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to get user: %w", contextError(ctx, err))
 	}
 
 	return &user, nil
 }
 
-func UserExistsByEmail(email string) (bool, error) {
+func (s *PostgresUserStore) UserExistsByEmail(ctx context.Context, email string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`
 
 	var exists bool
-	err := DB.QueryRow(query, email).Scan(&exists)
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&exists)
 	if err != nil {
-		return false, fmt.Errorf("failed to check user existence: %w", err)
+		return false, fmt.Errorf("failed to check user existence: %w", contextError(ctx, err))
 	}
 
 	return exists, nil
@@ -117,16 +144,19 @@ func contains(s, substr string) bool {
 	return false
 }
 
-func ListUsers() ([]models.User, error) {
+func (s *PostgresUserStore) ListUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := `
 		SELECT id, email, role, created_at
 		FROM users
 		ORDER BY created_at DESC
 	`
 
-	rows, err := DB.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list users: %w", err)
+		return nil, fmt.Errorf("failed to list users: %w", contextError(ctx, err))
 	}
 	defer rows.Close()
 
@@ -140,15 +170,124 @@ func ListUsers() ([]models.User, error) {
 			&user.CreatedAt,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
+			return nil, fmt.Errorf("failed to scan user: %w", contextError(ctx, err))
 		}
 		users = append(users, user)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
+		return nil, fmt.Errorf("error iterating users: %w", contextError(ctx, err))
 	}
 
 	return users, nil
 }
 
+func (s *PostgresUserStore) UpdateUserRole(ctx context.Context, userID uuid.UUID, role string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET role = $2
+		WHERE id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresUserStore) UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET password_hash = $2
+		WHERE id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to update user password: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresUserStore) UpdateUserPasswordIfUnchanged(ctx context.Context, userID uuid.UUID, oldHash, newHash string) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET password_hash = $3
+		WHERE id = $1 AND password_hash = $2
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, oldHash, newHash)
+	if err != nil {
+		return fmt.Errorf("failed to update user password: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	// The user may also be gone; either way there is nothing to update
+	if rowsAffected == 0 {
+		return ErrPasswordChanged
+	}
+
+	return nil
+}
+
+// RevokeUserTokens records that every token issued to the user up to revokedAt is revoked
+func (s *PostgresUserStore) RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET tokens_revoked_at = $2
+		WHERE id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, revokedAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", contextError(ctx, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", contextError(ctx, err))
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
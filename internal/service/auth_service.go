@@ -1,23 +1,63 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/notify"
 	"github.com/randhir/aegis-core/internal/repository"
 	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/randhir/aegis-core/internal/webauthn"
+	"go.uber.org/zap"
 )
 
-const refreshTokenValidity = 7 * 24 * time.Hour
+type AuthService struct {
+	users         repository.UserStore
+	refreshTokens repository.RefreshTokenStore
+	sessions      repository.SessionStore
+	references    cache.ReferenceTokenStore
+	mfa           *MFAService
+	passkeys      *PasskeyService
+	lockout       *LockoutService
+	// notifier is set when registration is enumeration-safe
+	notifier  notify.Notifier
+	passwords *utils.PasswordPool
+	jwt       *utils.JWTManager
+}
 
-type AuthService struct{}
+// LoginResult is either a token pair or, for users with MFA enabled, the
+// challenge token to exchange for one with VerifyMFA
+type LoginResult struct {
+	AccessToken  string
+	RefreshToken string
+	MFAToken     string
+}
 
-func NewAuthService() *AuthService {
-	return &AuthService{}
+func NewAuthService(users repository.UserStore, refreshTokens repository.RefreshTokenStore, sessions repository.SessionStore, references cache.ReferenceTokenStore, mfa *MFAService, passkeys *PasskeyService, lockout *LockoutService, notifier notify.Notifier, passwords *utils.PasswordPool, jwt *utils.JWTManager) *AuthService {
+	return &AuthService{
+		users:         users,
+		refreshTokens: refreshTokens,
+		sessions:      sessions,
+		references:    references,
+		mfa:           mfa,
+		passkeys:      passkeys,
+		lockout:       lockout,
+		notifier:      notifier,
+		passwords:     passwords,
+		jwt:           jwt,
+	}
 }
 
-func (s *AuthService) Register(email, password string) error {
+// Register creates a user account. In enumeration-safe mode it succeeds
+// whether or not the email is taken, and the owner of the email learns the
+// real outcome from the notifier.
+func (s *AuthService) Register(ctx context.Context, email, password string) error {
 	email = strings.TrimSpace(strings.ToLower(email))
 	password = strings.TrimSpace(password)
 
@@ -29,59 +69,244 @@ func (s *AuthService) Register(email, password string) error {
 		return &utils.AppError{Message: "password must be at least 8 characters long", StatusCode: 400}
 	}
 
-	exists, err := repository.UserExistsByEmail(email)
+	if s.notifier != nil {
+		return s.registerQuietly(ctx, email, password)
+	}
+
+	exists, err := s.users.UserExistsByEmail(ctx, email)
 	if err != nil {
-		return utils.ErrInternalError
+		return utils.FromStoreError(err)
 	}
 	if exists {
 		return utils.ErrConflict
 	}
 
-	passwordHash, err := utils.HashPassword(password)
+	passwordHash, err := s.passwords.Hash(ctx, password)
 	if err != nil {
-		return utils.ErrInternalError
+		return utils.ToAppError(err)
 	}
 
-	_, err = repository.CreateUser(email, passwordHash, "USER")
+	_, err = s.users.CreateUser(ctx, email, passwordHash, models.RoleUser)
 	if err != nil {
-		if err.Error() == "email already exists" {
+		if errors.Is(err, repository.ErrEmailExists) {
 			return utils.ErrConflict
 		}
-		return utils.ErrInternalError
+		return utils.FromStoreError(err)
+	}
+
+	return nil
+}
+
+// registerQuietly hashes the password before looking at the email, so taken and
+// free emails cost the same work and get the same answer
+func (s *AuthService) registerQuietly(ctx context.Context, email, password string) error {
+	passwordHash, err := s.passwords.Hash(ctx, password)
+	if err != nil {
+		return utils.ToAppError(err)
+	}
+
+	_, err = s.users.CreateUser(ctx, email, passwordHash, models.RoleUser)
+	if err != nil {
+		if !errors.Is(err, repository.ErrEmailExists) {
+			return utils.FromStoreError(err)
+		}
+		if err := s.notifier.AccountExists(ctx, email); err != nil {
+			logger.Error("Failed to send existing account notice", zap.Error(err))
+		}
+		return nil
 	}
 
+	if err := s.notifier.AccountCreated(ctx, email); err != nil {
+		logger.Error("Failed to send account created notice", zap.Error(err))
+	}
 	return nil
 }
 
-func (s *AuthService) Login(email, password string) (string, string, error) {
+// Login verifies credentials and starts a new session for the client's device.
+// Users with MFA enabled get a challenge token instead of a session. Repeated
+// failures lock out the account or source IP for a while. Unknown emails still
+// go through a password comparison, so they can't be told apart by timing.
+func (s *AuthService) Login(ctx context.Context, email, password string, client ClientInfo) (*LoginResult, error) {
 	email = strings.TrimSpace(strings.ToLower(email))
 	password = strings.TrimSpace(password)
 
-	user, err := repository.GetUserByEmail(email)
+	if !s.jwt.KnownClient(client.ClientID) {
+		return nil, utils.ErrUnknownClient
+	}
+
+	attempt, err := s.lockout.Begin(ctx, email, client.IPAddress)
 	if err != nil {
-		return "", "", utils.ErrInvalidCredentials
+		return nil, err
+	}
+
+	user, err := s.users.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			if err := s.passwords.VerifyDummy(ctx, email, password); err != nil {
+				s.lockout.Release(ctx, attempt)
+				return nil, utils.ToAppError(err)
+			}
+			s.lockout.Fail(ctx, attempt)
+			return nil, utils.ErrInvalidCredentials
+		}
+		s.lockout.Release(ctx, attempt)
+		return nil, utils.FromStoreError(err)
+	}
+
+	match, rehash, err := s.passwords.Verify(ctx, user.PasswordHash, password)
+	if err != nil {
+		s.lockout.Release(ctx, attempt)
+		return nil, utils.ToAppError(err)
+	}
+	if !match {
+		s.lockout.Fail(ctx, attempt)
+		return nil, utils.ErrInvalidCredentials
 	}
 
-	if !utils.ComparePassword(user.PasswordHash, password) {
-		return "", "", utils.ErrInvalidCredentials
+	if rehash {
+		s.rehashPassword(ctx, user, password)
 	}
 
-	accessToken, err := utils.GenerateAccessToken(user.ID.String(), user.Email, user.Role)
+	mfaEnabled, err := s.mfa.Enabled(ctx, user.ID)
 	if err != nil {
-		return "", "", utils.ErrInternalError
+		s.lockout.Release(ctx, attempt)
+		return nil, err
 	}
+	if mfaEnabled {
+		// The password alone doesn't clear the account's failures, or
+		// abandoned challenges would never lead to a lockout
+		s.lockout.AwaitSecondFactor(ctx, attempt)
+		mfaToken, err := s.mfa.StartChallenge(ctx, user, client)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAToken: mfaToken}, nil
+	}
+	s.lockout.Succeed(ctx, attempt)
 
+	return s.startSession(ctx, user, client)
+}
+
+// rehashPassword replaces a hash made with an outdated algorithm or weaker
+// parameters. Failures are only logged; the old hash still works and is
+// replaced at a later login.
+func (s *AuthService) rehashPassword(ctx context.Context, user *models.User, password string) {
+	passwordHash, err := s.passwords.Hash(ctx, password)
+	if err != nil {
+		logger.Warn("Failed to rehash password",
+			zap.String("user_id", user.ID.String()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	// A reset or change since the login read the hash wins over the rehash
+	err = s.users.UpdateUserPasswordIfUnchanged(ctx, user.ID, user.PasswordHash, passwordHash)
+	if errors.Is(err, repository.ErrPasswordChanged) {
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to store rehashed password",
+			zap.String("user_id", user.ID.String()),
+			zap.Error(err),
+		)
+		return
+	}
+
+	logger.Info("Password rehashed with current parameters",
+		zap.String("user_id", user.ID.String()),
+	)
+}
+
+// LoginWithPasskey starts a session for the user a passwordless passkey login
+// signs in. The passkey verified the user itself, so MFA is not asked for.
+func (s *AuthService) LoginWithPasskey(ctx context.Context, response webauthn.AssertionResponse, client ClientInfo) (*LoginResult, error) {
+	if !s.jwt.KnownClient(client.ClientID) {
+		return nil, utils.ErrUnknownClient
+	}
+
+	user, err := s.passkeys.FinishLogin(ctx, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.startSession(ctx, user, client)
+}
+
+// VerifyMFA completes a login pending its second factor: a TOTP code, a
+// recovery code or a passkey
+func (s *AuthService) VerifyMFA(ctx context.Context, mfaToken string, factor SecondFactor) (*LoginResult, error) {
+	challenge, err := s.mfa.CompleteChallenge(ctx, mfaToken, factor)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := uuid.Parse(challenge.UserID)
+	if err != nil {
+		return nil, utils.ErrInvalidToken
+	}
+
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, utils.ErrInvalidToken
+		}
+		return nil, utils.FromStoreError(err)
+	}
+	s.lockout.CompleteSecondFactor(ctx, user.Email)
+
+	// The client may have been removed from the configuration meanwhile
+	if !s.jwt.KnownClient(challenge.ClientID) {
+		return nil, utils.ErrUnknownClient
+	}
+
+	return s.startSession(ctx, user, ClientInfo{
+		ClientID:   challenge.ClientID,
+		DeviceName: challenge.DeviceName,
+		UserAgent:  challenge.UserAgent,
+		IPAddress:  challenge.IPAddress,
+	})
+}
+
+// startSession issues the token pair of a new session for the client's device
+func (s *AuthService) startSession(ctx context.Context, user *models.User, client ClientInfo) (*LoginResult, error) {
+	// Every login starts a new session, which is also its refresh token family
+	familyID := uuid.New()
+
+	expiresAt := s.jwt.SessionExpiry(user.Role, time.Now())
 	tokenID := uuid.New()
-	refreshToken, err := utils.GenerateRefreshToken(user.ID.String(), tokenID.String())
+	refreshToken, err := s.jwt.GenerateRefreshToken(user.ID.String(), tokenID.String(), client.ClientID, expiresAt)
+	if err != nil {
+		return nil, utils.ErrInternalError
+	}
+
+	refreshTokenHash, err := s.jwt.HashRefreshToken(refreshToken)
+	if err != nil {
+		return nil, utils.ErrInternalError
+	}
+
+	_, err = s.sessions.CreateSession(ctx, models.Session{
+		ID:         familyID,
+		UserID:     user.ID,
+		DeviceName: truncate(client.DeviceName, maxDeviceNameLength),
+		UserAgent:  truncate(client.UserAgent, maxUserAgentLength),
+		IPAddress:  client.IPAddress,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+
+	_, err = s.refreshTokens.CreateRefreshToken(ctx, user.ID, tokenID, familyID, refreshTokenHash, expiresAt)
 	if err != nil {
-		return "", "", utils.ErrInternalError
+		return nil, utils.FromStoreError(err)
 	}
 
-	expiresAt := time.Now().Add(refreshTokenValidity)
-	_, err = repository.CreateRefreshToken(user.ID, tokenID, refreshToken, expiresAt)
+	// Issued last, so a failed login leaves no usable access token behind
+	accessToken, err := issueAccessToken(ctx, s.jwt, s.references, user, familyID, client.ClientID)
 	if err != nil {
-		return "", "", utils.ErrInternalError
+		return nil, err
 	}
 
-	return accessToken, refreshToken, nil
+	return &LoginResult{AccessToken: accessToken, RefreshToken: refreshToken}, nil
 }
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/config"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+)
+
+const testPassword = "correct horse battery staple"
+
+// testPasswordConfig uses the cheapest parameters each algorithm accepts
+func testPasswordConfig(algorithm string) config.PasswordConfig {
+	return config.PasswordConfig{
+		Algorithm:       algorithm,
+		Argon2Time:      1,
+		Argon2MemoryKiB: 64,
+		Argon2Threads:   1,
+		BcryptCost:      4,
+		ScryptLogN:      4,
+		ScryptR:         8,
+		ScryptP:         1,
+	}
+}
+
+type testAuth struct {
+	service       *AuthService
+	users         *repository.MemoryUserStore
+	passwords     *utils.PasswordPool
+	user          *models.User
+	recoveryCodes []string
+}
+
+// newTestAuth returns an AuthService on memory stores and a user with
+// testPassword, with TOTP enabled if mfa is set
+func newTestAuth(t *testing.T, maxAccountFailures int, mfa bool) *testAuth {
+	t.Helper()
+	ctx := context.Background()
+
+	jwt, err := utils.NewJWTManager(config.JWTConfig{
+		AccessSecret:        "test-access-secret-at-least-32-bytes",
+		RefreshSecret:       "test-refresh-secret-at-least-32-bytes",
+		RefreshTokenHashKey: "test-refresh-hash-key",
+		Sessions:            config.SessionPolicy{AccessTokenTTL: time.Minute, IdleTimeout: time.Hour},
+		Issuer:              "aegis-test",
+		Audience:            "aegis-test",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashers, err := utils.NewPasswordHashers(testPasswordConfig(utils.PasswordAlgorithmBcrypt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords := utils.NewPasswordPool(hashers, 1, 16)
+
+	passwordHash, err := passwords.Hash(ctx, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := repository.NewMemoryUserStore()
+	user, err := users.CreateUser(ctx, "login@example.com", passwordHash, models.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := utils.NewMFASecrets(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := repository.NewMemorySecurityEventStore()
+	mfaService := NewMFAService(users, repository.NewMemoryMFAStore(), cache.NewMemoryMFAChallengeStore(),
+		events, nil, secrets, "AegisCore", time.Minute, 5)
+
+	var recoveryCodes []string
+	if mfa {
+		enrollment, err := mfaService.EnrollTOTP(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recoveryCodes, err = mfaService.ConfirmTOTP(ctx, user.ID, testTOTPCode(t, enrollment.Secret, time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lockout := NewLockoutService(users, cache.NewMemoryLoginAttemptStore(), events,
+		maxAccountFailures, 0, time.Minute, time.Minute, time.Hour)
+	service := NewAuthService(users, repository.NewMemoryRefreshTokenStore(), repository.NewMemorySessionStore(),
+		cache.NewMemoryReferenceTokenStore(), mfaService, nil, lockout, nil, passwords, jwt)
+
+	return &testAuth{
+		service:       service,
+		users:         users,
+		passwords:     passwords,
+		user:          user,
+		recoveryCodes: recoveryCodes,
+	}
+}
+
+func (a *testAuth) login(password string) (*LoginResult, error) {
+	return a.service.Login(context.Background(), a.user.Email, password, ClientInfo{IPAddress: "203.0.113.7"})
+}
+
+// A known password alone must not hand out MFA challenges forever
+func TestLoginAbandonedMFAChallengesLockOut(t *testing.T) {
+	const maxFailures = 3
+	auth := newTestAuth(t, maxFailures, true)
+
+	for i := range maxFailures {
+		result, err := auth.login(testPassword)
+		if err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+		if result.MFAToken == "" {
+			t.Fatalf("login %d: got no MFA challenge", i+1)
+		}
+	}
+
+	if _, err := auth.login(testPassword); !errors.Is(err, utils.ErrInvalidCredentials) {
+		t.Fatalf("login after %d abandoned challenges: got %v, want ErrInvalidCredentials", maxFailures, err)
+	}
+}
+
+// A correct password with MFA pending must not wipe out earlier failures;
+// only completing the second factor does
+func TestLoginPendingMFAKeepsLockoutState(t *testing.T) {
+	const maxFailures = 3
+	auth := newTestAuth(t, maxFailures, true)
+
+	for range maxFailures - 1 {
+		if _, err := auth.login("wrong password"); !errors.Is(err, utils.ErrInvalidCredentials) {
+			t.Fatalf("wrong password: got %v", err)
+		}
+	}
+
+	result, err := auth.login(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.login(testPassword); !errors.Is(err, utils.ErrInvalidCredentials) {
+		t.Fatalf("login with MFA pending after %d failures: got %v, want ErrInvalidCredentials", maxFailures-1, err)
+	}
+
+	factor := SecondFactor{RecoveryCode: auth.recoveryCodes[0]}
+	if _, err := auth.service.VerifyMFA(context.Background(), result.MFAToken, factor); err != nil {
+		t.Fatalf("VerifyMFA() error = %v", err)
+	}
+	if _, err := auth.login(testPassword); err != nil {
+		t.Fatalf("login after completing MFA: %v", err)
+	}
+}
+
+// resettingUserStore runs reset right after a login has read the user, as if
+// an admin reset the password while the login was still hashing
+type resettingUserStore struct {
+	*repository.MemoryUserStore
+	reset func()
+}
+
+func (s *resettingUserStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := s.MemoryUserStore.GetUserByEmail(ctx, email)
+	if s.reset != nil {
+		s.reset()
+		s.reset = nil
+	}
+	return user, err
+}
+
+// A rehash at login must not write the old password back over a reset
+func TestLoginRehashLosesToConcurrentReset(t *testing.T) {
+	auth := newTestAuth(t, 0, false)
+	ctx := context.Background()
+
+	// A hash from another algorithm is rehashed at login
+	scrypt, err := utils.NewPasswordHashers(testPasswordConfig(utils.PasswordAlgorithmScrypt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outdatedHash, err := scrypt.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.users.UpdateUserPassword(ctx, auth.user.ID, outdatedHash); err != nil {
+		t.Fatal(err)
+	}
+
+	resetHash, err := auth.passwords.Hash(ctx, "a brand new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.service.users = &resettingUserStore{
+		MemoryUserStore: auth.users,
+		reset: func() {
+			if err := auth.users.UpdateUserPassword(ctx, auth.user.ID, resetHash); err != nil {
+				t.Error(err)
+			}
+		},
+	}
+
+	if _, err := auth.login(testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := auth.users.GetUserByID(ctx, auth.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash != resetHash {
+		t.Fatal("the rehash overwrote the password reset")
+	}
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	lockoutScopeAccount = "account"
+	lockoutScopeIP      = "ip"
+)
+
+// LockoutService slows down password guessing. Failed logins are counted per
+// account and per source IP; once either reaches its threshold, further
+// failures lock it out for a period that doubles with every failure, up to
+// maxLockout. Locked-out logins fail like a wrong password, without revealing
+// whether the account exists.
+type LockoutService struct {
+	users              repository.UserStore
+	attempts           cache.LoginAttemptStore
+	events             repository.SecurityEventStore
+	maxAccountFailures int
+	maxIPFailures      int
+	failureWindow      time.Duration
+	baseLockout        time.Duration
+	maxLockout         time.Duration
+}
+
+func NewLockoutService(users repository.UserStore, attempts cache.LoginAttemptStore, events repository.SecurityEventStore, maxAccountFailures, maxIPFailures int, failureWindow, baseLockout, maxLockout time.Duration) *LockoutService {
+	return &LockoutService{
+		users:              users,
+		attempts:           attempts,
+		events:             events,
+		maxAccountFailures: maxAccountFailures,
+		maxIPFailures:      maxIPFailures,
+		failureWindow:      failureWindow,
+		baseLockout:        baseLockout,
+		maxLockout:         maxLockout,
+	}
+}
+
+// LoginAttempt is a login counted against the account and the source IP
+// before its password is checked. It ends with exactly one of Fail, Succeed
+// or Release.
+type LoginAttempt struct {
+	email     string
+	ipAddress string
+	reserved  []reservedSubject
+}
+
+type reservedSubject struct {
+	lockoutSubject
+	reservation cache.LoginAttemptReservation
+}
+
+// Begin counts a login attempt as failed up front, so parallel guesses can't
+// all get in before the first failure is recorded, and refuses it while the
+// account or the source IP is locked out. Unknown emails count too, so
+// lockouts behave the same whether the account exists or not.
+func (s *LockoutService) Begin(ctx context.Context, email, ipAddress string) (*LoginAttempt, error) {
+	attempt := &LoginAttempt{email: email, ipAddress: ipAddress}
+	for _, subject := range s.subjects(email, ipAddress) {
+		reservation, err := s.attempts.ReserveLoginAttempt(ctx, subject.key, s.policy(subject.threshold))
+		if err != nil {
+			s.Release(ctx, attempt)
+			return nil, utils.FromStoreError(err)
+		}
+
+		if !reservation.Allowed {
+			logger.Warn("Login refused during lockout",
+				zap.String("scope", subject.scope),
+				zap.String("email", email),
+				zap.String("ip_address", ipAddress),
+				zap.Duration("remaining", reservation.Remaining),
+			)
+			s.Release(ctx, attempt)
+			return nil, utils.ErrInvalidCredentials
+		}
+
+		attempt.reserved = append(attempt.reserved, reservedSubject{subject, *reservation})
+	}
+
+	return attempt, nil
+}
+
+// Fail confirms the attempt as a failed login; lockouts it reached now hold
+func (s *LockoutService) Fail(ctx context.Context, attempt *LoginAttempt) {
+	for _, reserved := range attempt.reserved {
+		if reserved.reservation.Lockout > 0 {
+			s.recordLockout(ctx, reserved.scope, attempt.email, attempt.ipAddress, reserved.reservation.Failures, reserved.reservation.Lockout)
+		}
+	}
+}
+
+// Succeed forgets the account's failures after a login that needs no second
+// factor passed its password check. The source
+// IP only gets this attempt back, since one valid login says little about the
+// other accounts it tried.
+func (s *LockoutService) Succeed(ctx context.Context, attempt *LoginAttempt) {
+	ctx = context.WithoutCancel(ctx)
+	for _, reserved := range attempt.reserved {
+		var err error
+		if reserved.scope == lockoutScopeAccount {
+			err = s.attempts.ClearLoginFailures(ctx, reserved.key)
+		} else {
+			err = s.attempts.ReleaseLoginAttempt(ctx, reserved.key, reserved.reservation)
+		}
+		if err != nil {
+			logger.Error("Failed to clear login failures",
+				zap.String("scope", reserved.scope),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// AwaitSecondFactor ends an attempt whose password was right but whose login
+// still waits for its second factor. The account keeps the attempt as a
+// failure until CompleteSecondFactor, so a known password alone can't keep
+// asking for fresh MFA challenges; the source IP gets it back as with Succeed.
+func (s *LockoutService) AwaitSecondFactor(ctx context.Context, attempt *LoginAttempt) {
+	ctx = context.WithoutCancel(ctx)
+	for _, reserved := range attempt.reserved {
+		if reserved.scope == lockoutScopeAccount {
+			if reserved.reservation.Lockout > 0 {
+				s.recordLockout(ctx, reserved.scope, attempt.email, attempt.ipAddress, reserved.reservation.Failures, reserved.reservation.Lockout)
+			}
+			continue
+		}
+		if err := s.attempts.ReleaseLoginAttempt(ctx, reserved.key, reserved.reservation); err != nil {
+			logger.Error("Failed to release login attempt",
+				zap.String("scope", reserved.scope),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// CompleteSecondFactor forgets the account's failures once a login passed its
+// second factor
+func (s *LockoutService) CompleteSecondFactor(ctx context.Context, email string) {
+	if s.maxAccountFailures <= 0 {
+		return
+	}
+	if err := s.attempts.ClearLoginFailures(context.WithoutCancel(ctx), accountSubject(email)); err != nil {
+		logger.Error("Failed to clear login failures",
+			zap.String("scope", lockoutScopeAccount),
+			zap.Error(err),
+		)
+	}
+}
+
+// Release takes the attempt back when its password could not be checked, e.g.
+// because the request was canceled, so it doesn't count as a failure
+func (s *LockoutService) Release(ctx context.Context, attempt *LoginAttempt) {
+	ctx = context.WithoutCancel(ctx)
+	for _, reserved := range attempt.reserved {
+		if err := s.attempts.ReleaseLoginAttempt(ctx, reserved.key, reserved.reservation); err != nil {
+			logger.Error("Failed to release login attempt",
+				zap.String("scope", reserved.scope),
+				zap.Error(err),
+			)
+		}
+	}
+	attempt.reserved = nil
+}
+
+// Unlock lets an admin lift a user's lockout and reset their failure count.
+// Lockouts of source IPs expire on their own.
+func (s *LockoutService) Unlock(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return utils.ErrUserNotFound
+		}
+		return utils.FromStoreError(err)
+	}
+
+	if err := s.attempts.ClearLoginFailures(ctx, accountSubject(user.Email)); err != nil {
+		return utils.FromStoreError(err)
+	}
+
+	return nil
+}
+
+func (s *LockoutService) policy(threshold int) cache.LoginLockoutPolicy {
+	return cache.LoginLockoutPolicy{
+		Threshold:   threshold,
+		Window:      s.failureWindow,
+		BaseLockout: s.baseLockout,
+		MaxLockout:  s.maxLockout,
+	}
+}
+
+type lockoutSubject struct {
+	scope     string
+	key       string
+	threshold int
+}
+
+// subjects lists what a login attempt counts against; a zero threshold
+// disables that scope
+func (s *LockoutService) subjects(email, ipAddress string) []lockoutSubject {
+	var subjects []lockoutSubject
+	if s.maxAccountFailures > 0 {
+		subjects = append(subjects, lockoutSubject{lockoutScopeAccount, accountSubject(email), s.maxAccountFailures})
+	}
+	if s.maxIPFailures > 0 && ipAddress != "" {
+		subjects = append(subjects, lockoutSubject{lockoutScopeIP, lockoutScopeIP + ":" + ipAddress, s.maxIPFailures})
+	}
+	return subjects
+}
+
+func (s *LockoutService) recordLockout(ctx context.Context, scope, email, ipAddress string, failures int64, duration time.Duration) {
+	logger.Warn("Login locked out after repeated failures",
+		zap.String("scope", scope),
+		zap.String("email", email),
+		zap.String("ip_address", ipAddress),
+		zap.Int64("failures", failures),
+		zap.Duration("lockout", duration),
+	)
+
+	details := fmt.Sprintf("scope=%s failures=%d lockout=%s ip_address=%s", scope, failures, duration, ipAddress)
+	if scope == lockoutScopeAccount {
+		details += " email=" + email
+	}
+	if err := s.events.RecordSecurityEvent(ctx, models.SecurityEventLoginLockout, nil, details); err != nil {
+		logger.Error("Failed to record security event",
+			zap.String("event", models.SecurityEventLoginLockout),
+			zap.Error(err),
+		)
+	}
+}
+
+func accountSubject(email string) string {
+	return lockoutScopeAccount + ":" + strings.TrimSpace(strings.ToLower(email))
+}
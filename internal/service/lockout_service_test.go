@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+)
+
+func newTestLockout(maxAccountFailures, maxIPFailures int) *LockoutService {
+	return NewLockoutService(repository.NewMemoryUserStore(), cache.NewMemoryLoginAttemptStore(),
+		repository.NewMemorySecurityEventStore(), maxAccountFailures, maxIPFailures, time.Minute, time.Minute, time.Hour)
+}
+
+func TestLockoutCapsParallelGuesses(t *testing.T) {
+	const maxFailures = 5
+	lockout := newTestLockout(maxFailures, 0)
+	ctx := context.Background()
+
+	var admitted atomic.Int64
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, err := lockout.Begin(ctx, "victim@example.com", "203.0.113.7")
+			if err != nil {
+				if !errors.Is(err, utils.ErrInvalidCredentials) {
+					t.Errorf("Begin() error = %v", err)
+				}
+				return
+			}
+			admitted.Add(1)
+			lockout.Fail(ctx, attempt)
+		}()
+	}
+	wg.Wait()
+
+	if got := admitted.Load(); got != maxFailures {
+		t.Fatalf("admitted %d parallel guesses, want %d", got, maxFailures)
+	}
+}
+
+func TestLockoutReleaseDoesNotCount(t *testing.T) {
+	lockout := newTestLockout(1, 0)
+	ctx := context.Background()
+
+	for range 3 {
+		attempt, err := lockout.Begin(ctx, "user@example.com", "")
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		lockout.Release(ctx, attempt)
+	}
+
+	attempt, err := lockout.Begin(ctx, "user@example.com", "")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	lockout.Fail(ctx, attempt)
+
+	if _, err := lockout.Begin(ctx, "user@example.com", ""); !errors.Is(err, utils.ErrInvalidCredentials) {
+		t.Fatalf("Begin() after reaching the threshold error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLockoutSucceedClearsAccount(t *testing.T) {
+	lockout := newTestLockout(3, 10)
+	ctx := context.Background()
+
+	for range 2 {
+		attempt, err := lockout.Begin(ctx, "user@example.com", "203.0.113.7")
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		lockout.Fail(ctx, attempt)
+	}
+
+	attempt, err := lockout.Begin(ctx, "user@example.com", "203.0.113.7")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	lockout.Succeed(ctx, attempt)
+
+	for range 2 {
+		attempt, err := lockout.Begin(ctx, "user@example.com", "203.0.113.7")
+		if err != nil {
+			t.Fatalf("Begin() after a successful login error = %v", err)
+		}
+		lockout.Fail(ctx, attempt)
+	}
+}
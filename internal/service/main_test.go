@@ -0,0 +1,15 @@
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/randhir/aegis-core/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.Initialize(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/randhir/aegis-core/internal/webauthn"
+	"go.uber.org/zap"
+)
+
+// TOTPEnrollment is what the user adds to their authenticator app, either by
+// scanning ProvisioningURI as a QR code or typing Secret
+type TOTPEnrollment struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+// SecondFactor completes a login pending MFA. Exactly one of its fields is set.
+type SecondFactor struct {
+	Code         string
+	RecoveryCode string
+	Passkey      *webauthn.AssertionResponse
+}
+
+type MFAService struct {
+	users      repository.UserStore
+	mfa        repository.MFAStore
+	challenges cache.MFAChallengeStore
+	events     repository.SecurityEventStore
+	passkeys   *PasskeyService
+	// secrets is nil when MFA_ENCRYPTION_KEY is not set
+	secrets      *utils.MFASecrets
+	issuer       string
+	challengeTTL time.Duration
+	maxAttempts  int
+}
+
+func NewMFAService(users repository.UserStore, mfa repository.MFAStore, challenges cache.MFAChallengeStore, events repository.SecurityEventStore, passkeys *PasskeyService, secrets *utils.MFASecrets, issuer string, challengeTTL time.Duration, maxAttempts int) *MFAService {
+	return &MFAService{
+		users:        users,
+		mfa:          mfa,
+		challenges:   challenges,
+		events:       events,
+		passkeys:     passkeys,
+		secrets:      secrets,
+		issuer:       issuer,
+		challengeTTL: challengeTTL,
+		maxAttempts:  maxAttempts,
+	}
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. It only guards logins
+// once ConfirmTOTP has seen a code from it; enrolling again before then
+// replaces the secret.
+func (s *MFAService) EnrollTOTP(ctx context.Context, userID uuid.UUID) (*TOTPEnrollment, error) {
+	if s.secrets == nil {
+		return nil, utils.ErrMFANotConfigured
+	}
+
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, utils.ErrUserNotFound
+		}
+		return nil, utils.FromStoreError(err)
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, utils.ErrInternalError
+	}
+
+	sealed, err := s.secrets.Seal(userID.String(), secret)
+	if err != nil {
+		return nil, utils.ErrInternalError
+	}
+
+	if err := s.mfa.SaveMFAEnrollment(ctx, userID, sealed); err != nil {
+		if errors.Is(err, repository.ErrMFAAlreadyEnabled) {
+			return nil, utils.ErrMFAAlreadyEnabled
+		}
+		return nil, utils.FromStoreError(err)
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(s.issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables MFA once the user proves their authenticator works, and
+// returns their recovery codes. They are shown only this once.
+func (s *MFAService) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	if s.secrets == nil {
+		return nil, utils.ErrMFANotConfigured
+	}
+
+	enrollment, err := s.mfa.GetMFAEnrollment(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotEnrolled) {
+			return nil, utils.ErrMFANotEnrolled
+		}
+		return nil, utils.FromStoreError(err)
+	}
+	if enrollment.ConfirmedAt != nil {
+		return nil, utils.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := s.secrets.Open(userID.String(), enrollment.EncryptedSecret)
+	if err != nil {
+		return nil, utils.ErrInternalError
+	}
+
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, utils.ErrInvalidMFACode
+	}
+
+	codes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, utils.ErrInternalError
+	}
+
+	hashes := make([]string, len(codes))
+	for i, recoveryCode := range codes {
+		hashes[i] = s.secrets.HashRecoveryCode(utils.NormalizeRecoveryCode(recoveryCode))
+	}
+
+	if err := s.mfa.ConfirmMFAEnrollment(ctx, userID, step, hashes); err != nil {
+		if errors.Is(err, repository.ErrMFANotEnrolled) {
+			return nil, utils.ErrMFANotEnrolled
+		}
+		return nil, utils.FromStoreError(err)
+	}
+
+	return codes, nil
+}
+
+// Enabled reports whether logins of the user need a second factor
+func (s *MFAService) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	enrollment, err := s.mfa.GetMFAEnrollment(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotEnrolled) {
+			return false, nil
+		}
+		return false, utils.FromStoreError(err)
+	}
+
+	return enrollment.ConfirmedAt != nil, nil
+}
+
+// StartChallenge holds a login that passed the password check until its second
+// factor arrives, and returns the token that identifies it
+func (s *MFAService) StartChallenge(ctx context.Context, user *models.User, client ClientInfo) (string, error) {
+	token, err := utils.NewMFAChallengeToken()
+	if err != nil {
+		return "", utils.ErrInternalError
+	}
+
+	err = s.challenges.StoreMFAChallenge(ctx, token, cache.MFAChallenge{
+		UserID:     user.ID.String(),
+		ClientID:   client.ClientID,
+		DeviceName: client.DeviceName,
+		UserAgent:  client.UserAgent,
+		IPAddress:  client.IPAddress,
+		ExpiresAt:  time.Now().Add(s.challengeTTL),
+	})
+	if err != nil {
+		return "", utils.FromStoreError(err)
+	}
+
+	return token, nil
+}
+
+// CompleteChallenge checks a second factor against a pending login and returns
+// the login once it passes. Every attempt is counted before the factor is
+// checked, so parallel guesses can't exceed maxAttempts, and too many wrong
+// codes or passkeys end the pending login. Only one request can complete it.
+func (s *MFAService) CompleteChallenge(ctx context.Context, token string, factor SecondFactor) (*cache.MFAChallenge, error) {
+	challenge, attempts, err := s.challenges.ReserveMFAAttempt(ctx, token)
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+	if challenge == nil {
+		return nil, utils.ErrInvalidToken
+	}
+
+	userID, err := uuid.Parse(challenge.UserID)
+	if err != nil {
+		return nil, utils.ErrInvalidToken
+	}
+
+	if attempts > int64(s.maxAttempts) {
+		s.endChallenge(ctx, token, userID, attempts)
+		return nil, utils.ErrInvalidToken
+	}
+
+	if err := s.verify(ctx, userID, factor); err != nil {
+		if (errors.Is(err, utils.ErrInvalidMFACode) || errors.Is(err, utils.ErrInvalidPasskey)) && attempts >= int64(s.maxAttempts) {
+			s.endChallenge(ctx, token, userID, attempts)
+		}
+		return nil, err
+	}
+
+	completed, err := s.challenges.ConsumeMFAChallenge(ctx, token)
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+	if completed == nil {
+		// Another request completed or ended the login first
+		return nil, utils.ErrInvalidToken
+	}
+
+	return completed, nil
+}
+
+// Reset removes a user's MFA, e.g. after they lost their authenticator and
+// recovery codes; they can log in with their password alone and enroll again
+func (s *MFAService) Reset(ctx context.Context, adminID string, userID uuid.UUID) error {
+	if err := s.mfa.DeleteMFAEnrollment(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrMFANotEnrolled) {
+			return utils.ErrMFANotEnrolled
+		}
+		return utils.FromStoreError(err)
+	}
+
+	details := fmt.Sprintf("reset_by=%s", adminID)
+	if err := s.events.RecordSecurityEvent(ctx, models.SecurityEventMFAReset, &userID, details); err != nil {
+		logger.Error("Failed to record security event",
+			zap.String("event", models.SecurityEventMFAReset),
+			zap.Error(err),
+		)
+	}
+
+	return nil
+}
+
+// verify checks a second factor. TOTP codes and recovery codes are both single
+// use; passkeys may be any of the user's, registered with or without TOTP.
+func (s *MFAService) verify(ctx context.Context, userID uuid.UUID, factor SecondFactor) error {
+	if factor.Passkey != nil {
+		return s.passkeys.VerifySecondFactor(ctx, userID, *factor.Passkey)
+	}
+
+	if s.secrets == nil {
+		logger.Error("MFA login attempted without MFA_ENCRYPTION_KEY",
+			zap.String("user_id", userID.String()),
+		)
+		return utils.ErrInternalError
+	}
+
+	if factor.RecoveryCode != "" {
+		codeHash := s.secrets.HashRecoveryCode(utils.NormalizeRecoveryCode(factor.RecoveryCode))
+		if err := s.mfa.UseRecoveryCode(ctx, userID, codeHash); err != nil {
+			if errors.Is(err, repository.ErrRecoveryCodeNotFound) {
+				return utils.ErrInvalidMFACode
+			}
+			return utils.FromStoreError(err)
+		}
+		return nil
+	}
+
+	enrollment, err := s.mfa.GetMFAEnrollment(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotEnrolled) {
+			// MFA was reset while the login was pending
+			return utils.ErrInvalidToken
+		}
+		return utils.FromStoreError(err)
+	}
+
+	secret, err := s.secrets.Open(userID.String(), enrollment.EncryptedSecret)
+	if err != nil {
+		return utils.ErrInternalError
+	}
+
+	step, ok := utils.ValidateTOTP(secret, factor.Code, time.Now())
+	if !ok {
+		return utils.ErrInvalidMFACode
+	}
+
+	if err := s.mfa.UseTOTPStep(ctx, userID, step); err != nil {
+		if errors.Is(err, repository.ErrTOTPStepUsed) {
+			return utils.ErrInvalidMFACode
+		}
+		return utils.FromStoreError(err)
+	}
+
+	return nil
+}
+
+// endChallenge ends the pending login after too many attempts
+func (s *MFAService) endChallenge(ctx context.Context, token string, userID uuid.UUID, attempts int64) {
+	logger.Warn("MFA challenge ended after too many attempts",
+		zap.String("user_id", userID.String()),
+		zap.Int64("attempts", attempts),
+	)
+	if err := s.challenges.DeleteMFAChallenge(ctx, token); err != nil {
+		logger.Error("Failed to delete MFA challenge",
+			zap.String("user_id", userID.String()),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+)
+
+// newTestMFA returns an MFAService on memory stores and a user with TOTP
+// enabled, along with their recovery codes
+func newTestMFA(t *testing.T, maxAttempts int) (*MFAService, *models.User, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	secrets, err := utils.NewMFASecrets(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := repository.NewMemoryUserStore()
+	user, err := users.CreateUser(ctx, "mfa@example.com", "unused", models.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := NewMFAService(users, repository.NewMemoryMFAStore(), cache.NewMemoryMFAChallengeStore(),
+		repository.NewMemorySecurityEventStore(), nil, secrets, "AegisCore", time.Minute, maxAttempts)
+
+	enrollment, err := service.EnrollTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := service.ConfirmTOTP(ctx, user.ID, testTOTPCode(t, enrollment.Secret, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return service, user, recoveryCodes
+}
+
+func testTOTPCode(t *testing.T, secret string, now time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(now.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1_000_000)
+}
+
+func TestCompleteChallengeCapsParallelGuesses(t *testing.T) {
+	const maxAttempts = 3
+	service, user, _ := newTestMFA(t, maxAttempts)
+	ctx := context.Background()
+
+	token, err := service.StartChallenge(ctx, user, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	checked := 0
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.CompleteChallenge(ctx, token, SecondFactor{RecoveryCode: fmt.Sprintf("wrong-%05d", i)})
+			if errors.Is(err, utils.ErrInvalidMFACode) {
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			} else if !errors.Is(err, utils.ErrInvalidToken) {
+				t.Errorf("unexpected error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if checked > maxAttempts {
+		t.Fatalf("%d guesses were checked, want at most %d", checked, maxAttempts)
+	}
+}
+
+func TestCompleteChallengeEndsAfterMaxAttempts(t *testing.T) {
+	service, user, recoveryCodes := newTestMFA(t, 2)
+	ctx := context.Background()
+
+	token, err := service.StartChallenge(ctx, user, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if _, err := service.CompleteChallenge(ctx, token, SecondFactor{RecoveryCode: "wrong-wrong"}); !errors.Is(err, utils.ErrInvalidMFACode) {
+			t.Fatalf("wrong code: got %v", err)
+		}
+	}
+
+	if _, err := service.CompleteChallenge(ctx, token, SecondFactor{RecoveryCode: recoveryCodes[0]}); !errors.Is(err, utils.ErrInvalidToken) {
+		t.Fatalf("after max attempts: got %v, want the challenge ended", err)
+	}
+}
+
+func TestCompleteChallengeSucceedsOnce(t *testing.T) {
+	service, user, recoveryCodes := newTestMFA(t, 5)
+	ctx := context.Background()
+
+	token, err := service.StartChallenge(ctx, user, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	completed := 0
+	for _, code := range recoveryCodes[:4] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			challenge, err := service.CompleteChallenge(ctx, token, SecondFactor{RecoveryCode: code})
+			if err == nil {
+				if challenge.UserID != user.ID.String() {
+					t.Errorf("challenge for %s, want %s", challenge.UserID, user.ID)
+				}
+				mu.Lock()
+				completed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if completed != 1 {
+		t.Fatalf("challenge completed %d times, want once", completed)
+	}
+}
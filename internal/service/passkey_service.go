@@ -0,0 +1,362 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/randhir/aegis-core/internal/webauthn"
+	"go.uber.org/zap"
+)
+
+const (
+	maxPasskeyNameLength = 255
+	defaultPasskeyName   = "Passkey"
+)
+
+// PasskeyService registers WebAuthn passkeys and verifies sign-ins with them,
+// either passwordless or as the second factor of a password login
+type PasskeyService struct {
+	users         repository.UserStore
+	passkeys      repository.PasskeyStore
+	challenges    cache.WebAuthnChallengeStore
+	mfaChallenges cache.MFAChallengeStore
+	events        repository.SecurityEventStore
+	// rp is nil when WEBAUTHN_RP_ID is not set
+	rp           *webauthn.RelyingParty
+	challengeTTL time.Duration
+}
+
+func NewPasskeyService(users repository.UserStore, passkeys repository.PasskeyStore, challenges cache.WebAuthnChallengeStore, mfaChallenges cache.MFAChallengeStore, events repository.SecurityEventStore, rp *webauthn.RelyingParty, challengeTTL time.Duration) *PasskeyService {
+	return &PasskeyService{
+		users:         users,
+		passkeys:      passkeys,
+		challenges:    challenges,
+		mfaChallenges: mfaChallenges,
+		events:        events,
+		rp:            rp,
+		challengeTTL:  challengeTTL,
+	}
+}
+
+// BeginRegistration returns the options for creating a passkey for the user.
+// Passkeys the user already has are excluded so an authenticator isn't
+// registered twice.
+func (s *PasskeyService) BeginRegistration(ctx context.Context, userID uuid.UUID) (*webauthn.CreationOptions, error) {
+	if s.rp == nil {
+		return nil, utils.ErrPasskeysDisabled
+	}
+
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, utils.ErrUserNotFound
+		}
+		return nil, utils.FromStoreError(err)
+	}
+
+	passkeys, err := s.passkeys.ListPasskeysByUserID(ctx, userID)
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+
+	challenge, err := s.issueChallenge(ctx, cache.WebAuthnRegistration, userID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// The user handle is the user ID, so passwordless logins can name the
+	// account without revealing the email
+	options := s.rp.CreationOptions(challenge, webauthn.UserEntity{
+		ID:          webauthn.EncodeID(userID[:]),
+		Name:        user.Email,
+		DisplayName: user.Email,
+	}, credentialIDs(passkeys), s.challengeTTL.Milliseconds())
+
+	return &options, nil
+}
+
+// FinishRegistration verifies the authenticator's response to BeginRegistration
+// and stores the new passkey
+func (s *PasskeyService) FinishRegistration(ctx context.Context, userID uuid.UUID, name string, response webauthn.AttestationResponse) (*models.Passkey, error) {
+	if s.rp == nil {
+		return nil, utils.ErrPasskeysDisabled
+	}
+
+	challenge, err := s.consumeChallenge(ctx, response.Response.ClientDataJSON, cache.WebAuthnRegistration, userID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.rp.VerifyRegistration(challenge, response, false)
+	if err != nil {
+		logger.Warn("Passkey registration rejected",
+			zap.String("user_id", userID.String()),
+			zap.String("reason", err.Error()),
+		)
+		return nil, utils.ErrInvalidPasskey
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+
+	passkey, err := s.passkeys.CreatePasskey(ctx, models.Passkey{
+		ID:        credential.ID,
+		UserID:    userID,
+		Name:      truncate(name, maxPasskeyNameLength),
+		PublicKey: credential.PublicKey,
+		Algorithm: credential.Algorithm,
+		AAGUID:    credential.AAGUID,
+		SignCount: int64(credential.SignCount),
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrPasskeyExists) {
+			return nil, utils.ErrPasskeyExists
+		}
+		return nil, utils.FromStoreError(err)
+	}
+
+	return passkey, nil
+}
+
+// List returns the user's passkeys, oldest first
+func (s *PasskeyService) List(ctx context.Context, userID uuid.UUID) ([]models.Passkey, error) {
+	passkeys, err := s.passkeys.ListPasskeysByUserID(ctx, userID)
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+
+	return passkeys, nil
+}
+
+// Delete removes one of the user's passkeys
+func (s *PasskeyService) Delete(ctx context.Context, userID uuid.UUID, credentialID []byte) error {
+	if err := s.passkeys.DeletePasskey(ctx, userID, credentialID); err != nil {
+		if errors.Is(err, repository.ErrPasskeyNotFound) {
+			return utils.ErrPasskeyNotFound
+		}
+		return utils.FromStoreError(err)
+	}
+
+	return nil
+}
+
+// BeginLogin returns the options for a passwordless login. The allow list is
+// empty, so the user picks any of their passkeys for this site.
+func (s *PasskeyService) BeginLogin(ctx context.Context) (*webauthn.RequestOptions, error) {
+	if s.rp == nil {
+		return nil, utils.ErrPasskeysDisabled
+	}
+
+	challenge, err := s.issueChallenge(ctx, cache.WebAuthnLogin, "")
+	if err != nil {
+		return nil, err
+	}
+
+	options := s.rp.RequestOptions(challenge, nil, "required", s.challengeTTL.Milliseconds())
+	return &options, nil
+}
+
+// FinishLogin verifies a passwordless login and returns the user it signs in.
+// The passkey must have verified the user (PIN or biometrics), since nothing
+// else is asked for.
+func (s *PasskeyService) FinishLogin(ctx context.Context, response webauthn.AssertionResponse) (*models.User, error) {
+	passkey, err := s.verifyAssertion(ctx, response, cache.WebAuthnLogin, "", true)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetUserByID(ctx, passkey.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, utils.ErrInvalidPasskey
+		}
+		return nil, utils.FromStoreError(err)
+	}
+
+	return user, nil
+}
+
+// BeginSecondFactor returns the options for completing a pending MFA login
+// with one of the user's passkeys
+func (s *PasskeyService) BeginSecondFactor(ctx context.Context, mfaToken string) (*webauthn.RequestOptions, error) {
+	if s.rp == nil {
+		return nil, utils.ErrPasskeysDisabled
+	}
+
+	pending, err := s.mfaChallenges.GetMFAChallenge(ctx, mfaToken)
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+	if pending == nil {
+		return nil, utils.ErrInvalidToken
+	}
+
+	userID, err := uuid.Parse(pending.UserID)
+	if err != nil {
+		return nil, utils.ErrInvalidToken
+	}
+
+	passkeys, err := s.passkeys.ListPasskeysByUserID(ctx, userID)
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+	if len(passkeys) == 0 {
+		return nil, utils.ErrPasskeyNotFound
+	}
+
+	challenge, err := s.issueChallenge(ctx, cache.WebAuthnMFA, userID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// The password was already checked, so presence of the passkey is enough
+	options := s.rp.RequestOptions(challenge, credentialIDs(passkeys), "discouraged", s.challengeTTL.Milliseconds())
+	return &options, nil
+}
+
+// VerifySecondFactor checks a passkey assertion answering BeginSecondFactor
+// for the user's pending login
+func (s *PasskeyService) VerifySecondFactor(ctx context.Context, userID uuid.UUID, response webauthn.AssertionResponse) error {
+	_, err := s.verifyAssertion(ctx, response, cache.WebAuthnMFA, userID.String(), false)
+	return err
+}
+
+// verifyAssertion checks an assertion against the challenge it answers and the
+// passkey it names, then records the sign-in. A signature counter that did not
+// increase means the passkey may have been cloned; the sign-in is refused and
+// a security event recorded.
+func (s *PasskeyService) verifyAssertion(ctx context.Context, response webauthn.AssertionResponse, purpose, userID string, requireUserVerification bool) (*models.Passkey, error) {
+	if s.rp == nil {
+		return nil, utils.ErrPasskeysDisabled
+	}
+
+	challenge, err := s.consumeChallenge(ctx, response.Response.ClientDataJSON, purpose, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID, err := webauthn.DecodeID(response.ID)
+	if err != nil {
+		return nil, utils.ErrInvalidPasskey
+	}
+
+	passkey, err := s.passkeys.GetPasskey(ctx, credentialID)
+	if err != nil {
+		if errors.Is(err, repository.ErrPasskeyNotFound) {
+			return nil, utils.ErrInvalidPasskey
+		}
+		return nil, utils.FromStoreError(err)
+	}
+	if userID != "" && passkey.UserID.String() != userID {
+		return nil, utils.ErrInvalidPasskey
+	}
+
+	assertion, err := s.rp.VerifyAssertion(challenge, passkey.PublicKey, response, requireUserVerification)
+	if err != nil {
+		logger.Warn("Passkey assertion rejected",
+			zap.String("user_id", passkey.UserID.String()),
+			zap.String("reason", err.Error()),
+		)
+		return nil, utils.ErrInvalidPasskey
+	}
+
+	if len(assertion.UserHandle) > 0 && !bytes.Equal(assertion.UserHandle, passkey.UserID[:]) {
+		return nil, utils.ErrInvalidPasskey
+	}
+
+	if err := s.passkeys.UsePasskey(ctx, passkey.ID, int64(assertion.SignCount)); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrPasskeySignCount):
+			s.recordClonedPasskey(ctx, passkey, assertion.SignCount)
+			return nil, utils.ErrInvalidPasskey
+		case errors.Is(err, repository.ErrPasskeyNotFound):
+			// Deleted while the ceremony was in flight
+			return nil, utils.ErrInvalidPasskey
+		default:
+			return nil, utils.FromStoreError(err)
+		}
+	}
+
+	return passkey, nil
+}
+
+// issueChallenge generates and stores the challenge of a new ceremony
+func (s *PasskeyService) issueChallenge(ctx context.Context, purpose, userID string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, utils.ErrInternalError
+	}
+
+	err = s.challenges.StoreWebAuthnChallenge(ctx, webauthn.EncodeID(challenge), cache.WebAuthnSession{
+		Purpose:   purpose,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(s.challengeTTL),
+	})
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+
+	return challenge, nil
+}
+
+// consumeChallenge looks up the challenge a response answers and makes sure it
+// was issued for this ceremony and user. Each challenge is answered at most once.
+func (s *PasskeyService) consumeChallenge(ctx context.Context, clientDataJSON, purpose, userID string) ([]byte, error) {
+	encoded, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return nil, utils.ErrInvalidPasskey
+	}
+	encoded = strings.TrimRight(encoded, "=")
+
+	session, err := s.challenges.ConsumeWebAuthnChallenge(ctx, encoded)
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+	if session == nil || session.Purpose != purpose || session.UserID != userID {
+		return nil, utils.ErrInvalidPasskey
+	}
+
+	challenge, err := webauthn.DecodeID(encoded)
+	if err != nil {
+		return nil, utils.ErrInvalidPasskey
+	}
+
+	return challenge, nil
+}
+
+func (s *PasskeyService) recordClonedPasskey(ctx context.Context, passkey *models.Passkey, signCount uint32) {
+	logger.Warn("Passkey signature counter did not increase",
+		zap.String("user_id", passkey.UserID.String()),
+		zap.String("credential_id", webauthn.EncodeID(passkey.ID)),
+		zap.Int64("stored_sign_count", passkey.SignCount),
+		zap.Uint32("sign_count", signCount),
+	)
+
+	details := fmt.Sprintf("credential_id=%s stored_sign_count=%d sign_count=%d", webauthn.EncodeID(passkey.ID), passkey.SignCount, signCount)
+	if err := s.events.RecordSecurityEvent(ctx, models.SecurityEventPasskeyCloned, &passkey.UserID, details); err != nil {
+		logger.Error("Failed to record security event",
+			zap.String("event", models.SecurityEventPasskeyCloned),
+			zap.Error(err),
+		)
+	}
+}
+
+func credentialIDs(passkeys []models.Passkey) [][]byte {
+	ids := make([][]byte, len(passkeys))
+	for i, passkey := range passkeys {
+		ids[i] = passkey.ID
+	}
+	return ids
+}
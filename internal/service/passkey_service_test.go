@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+	"github.com/randhir/aegis-core/internal/webauthn"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator is an ES256 authenticator with one discoverable credential
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{t: t, key: key, credentialID: credentialID}
+}
+
+// create answers navigator.credentials.create() with "none" attestation
+func (a *softAuthenticator) create(options *webauthn.CreationOptions) webauthn.AttestationResponse {
+	a.t.Helper()
+	userHandle, err := webauthn.DecodeID(options.User.ID)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	a.userHandle = userHandle
+
+	point := a.key.PublicKey
+	x, y := make([]byte, 32), make([]byte, 32)
+	point.X.FillBytes(x)
+	point.Y.FillBytes(y)
+	coseKey := cborMap(
+		cborInt(1), cborInt(2), // kty: EC2
+		cborInt(3), cborInt(-7), // alg: ES256
+		cborInt(-1), cborInt(1), // crv: P-256
+		cborInt(-2), cborBytes(x),
+		cborInt(-3), cborBytes(y),
+	)
+
+	var attested []byte
+	attested = append(attested, make([]byte, 16)...) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+	authData := a.authenticatorData(0x01|0x04|0x40, 0, attested)
+
+	var response webauthn.AttestationResponse
+	response.ID = webauthn.EncodeID(a.credentialID)
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", options.Challenge)
+	response.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(authData),
+	))
+	return response
+}
+
+// get answers navigator.credentials.get() reporting signCount
+func (a *softAuthenticator) get(options *webauthn.RequestOptions, signCount uint32) webauthn.AssertionResponse {
+	a.t.Helper()
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge)
+	authData := a.authenticatorData(0x01|0x04, signCount, nil)
+
+	rawClientData, err := base64.RawURLEncoding.DecodeString(clientDataJSON)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	var response webauthn.AssertionResponse
+	response.ID = webauthn.EncodeID(a.credentialID)
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	response.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	response.Response.UserHandle = webauthn.EncodeID(a.userHandle)
+	return response
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte, signCount uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) string {
+	a.t.Helper()
+	raw, err := json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": testOrigin})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func cborHead(major byte, value uint64) []byte {
+	switch {
+	case value < 24:
+		return []byte{major<<5 | byte(value)}
+	case value <= 0xff:
+		return []byte{major<<5 | 24, byte(value)}
+	case value <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(value))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(value))
+	}
+}
+
+func cborInt(value int64) []byte {
+	if value < 0 {
+		return cborHead(1, uint64(-1-value))
+	}
+	return cborHead(0, uint64(value))
+}
+
+func cborBytes(value []byte) []byte {
+	return append(cborHead(2, uint64(len(value))), value...)
+}
+
+func cborText(value string) []byte {
+	return append(cborHead(3, uint64(len(value))), value...)
+}
+
+// cborMap encodes alternating keys and values
+func cborMap(items ...[]byte) []byte {
+	encoded := cborHead(5, uint64(len(items)/2))
+	for _, item := range items {
+		encoded = append(encoded, item...)
+	}
+	return encoded
+}
+
+// vanishingPasskeyStore deletes each passkey just before its sign-in is recorded
+type vanishingPasskeyStore struct {
+	*repository.MemoryPasskeyStore
+}
+
+func (s vanishingPasskeyStore) UsePasskey(ctx context.Context, credentialID []byte, signCount int64) error {
+	passkey, err := s.GetPasskey(ctx, credentialID)
+	if err != nil {
+		return err
+	}
+	if err := s.DeletePasskey(ctx, passkey.UserID, credentialID); err != nil {
+		return err
+	}
+	return s.MemoryPasskeyStore.UsePasskey(ctx, credentialID, signCount)
+}
+
+type testPasskeys struct {
+	service       *PasskeyService
+	events        *repository.MemorySecurityEventStore
+	user          *models.User
+	authenticator *softAuthenticator
+}
+
+// newTestPasskeys registers a passkey from a software authenticator with
+// passkeys, which the returned service also uses
+func newTestPasskeys(t *testing.T, passkeys repository.PasskeyStore) *testPasskeys {
+	t.Helper()
+	ctx := context.Background()
+
+	users := repository.NewMemoryUserStore()
+	user, err := users.CreateUser(ctx, "passkey@example.com", "unused", models.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := repository.NewMemorySecurityEventStore()
+	service := NewPasskeyService(users, passkeys, cache.NewMemoryWebAuthnChallengeStore(), cache.NewMemoryMFAChallengeStore(),
+		events, webauthn.NewRelyingParty(testRPID, "AegisCore", []string{testOrigin}), time.Minute)
+
+	authenticator := newSoftAuthenticator(t)
+	options, err := service.BeginRegistration(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishRegistration(ctx, user.ID, "Test key", authenticator.create(options)); err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+
+	return &testPasskeys{service: service, events: events, user: user, authenticator: authenticator}
+}
+
+func (p *testPasskeys) login(t *testing.T, signCount uint32) (*models.User, error) {
+	t.Helper()
+	ctx := context.Background()
+	options, err := p.service.BeginLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.service.FinishLogin(ctx, p.authenticator.get(options, signCount))
+}
+
+func (p *testPasskeys) clonedEvents(t *testing.T) int {
+	t.Helper()
+	events, err := p.events.ListSecurityEvents(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cloned int
+	for _, event := range events {
+		if event.Type == models.SecurityEventPasskeyCloned {
+			cloned++
+		}
+	}
+	return cloned
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	passkeys := newTestPasskeys(t, repository.NewMemoryPasskeyStore())
+
+	for _, signCount := range []uint32{1, 2, 10} {
+		user, err := passkeys.login(t, signCount)
+		if err != nil {
+			t.Fatalf("login with sign count %d error = %v", signCount, err)
+		}
+		if user.ID != passkeys.user.ID {
+			t.Fatalf("login signed in %s, want %s", user.ID, passkeys.user.ID)
+		}
+	}
+}
+
+func TestPasskeySignCountRegressionIsRefused(t *testing.T) {
+	passkeys := newTestPasskeys(t, repository.NewMemoryPasskeyStore())
+
+	if _, err := passkeys.login(t, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, signCount := range []uint32{5, 3} {
+		if _, err := passkeys.login(t, signCount); !errors.Is(err, utils.ErrInvalidPasskey) {
+			t.Fatalf("login with sign count %d after 5 error = %v, want ErrInvalidPasskey", signCount, err)
+		}
+	}
+	if got := passkeys.clonedEvents(t); got != 2 {
+		t.Fatalf("recorded %d passkey_cloned events, want 2", got)
+	}
+}
+
+func TestPasskeyDeletedDuringLoginIsNotCloned(t *testing.T) {
+	passkeys := newTestPasskeys(t, vanishingPasskeyStore{repository.NewMemoryPasskeyStore()})
+
+	if _, err := passkeys.login(t, 1); !errors.Is(err, utils.ErrInvalidPasskey) {
+		t.Fatalf("login with a deleted passkey error = %v, want ErrInvalidPasskey", err)
+	}
+	if got := passkeys.clonedEvents(t); got != 0 {
+		t.Fatalf("recorded %d passkey_cloned events for a deleted passkey, want 0", got)
+	}
+}
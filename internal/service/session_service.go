@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+)
+
+const (
+	maxDeviceNameLength = 255
+	maxUserAgentLength  = 512
+)
+
+// ClientInfo describes the client application and device a login or refresh
+// request came from
+type ClientInfo struct {
+	// ClientID selects the audiences of the issued access tokens; empty for
+	// clients that don't identify themselves
+	ClientID   string
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+}
+
+type SessionService struct {
+	users         repository.UserStore
+	sessions      repository.SessionStore
+	refreshTokens repository.RefreshTokenStore
+	revocations   cache.TokenRevocationStore
+	jwt           *utils.JWTManager
+}
+
+func NewSessionService(users repository.UserStore, sessions repository.SessionStore, refreshTokens repository.RefreshTokenStore, revocations cache.TokenRevocationStore, jwt *utils.JWTManager) *SessionService {
+	return &SessionService{
+		users:         users,
+		sessions:      sessions,
+		refreshTokens: refreshTokens,
+		revocations:   revocations,
+		jwt:           jwt,
+	}
+}
+
+// ListSessions returns the user's active sessions, most recently used first
+func (s *SessionService) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	sessions, err := s.sessions.ListSessionsByUserID(ctx, userID)
+	if err != nil {
+		return nil, utils.FromStoreError(err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends one of the user's sessions, including its outstanding access tokens
+func (s *SessionService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	session, err := s.sessions.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return utils.ErrSessionNotFound
+		}
+		return utils.FromStoreError(err)
+	}
+
+	// Don't reveal whether another user's session exists
+	if session.UserID != userID {
+		return utils.ErrSessionNotFound
+	}
+
+	return s.revoke(ctx, sessionID)
+}
+
+// RevokeOtherSessions ends every session of the user except currentSessionID
+// and returns how many were ended
+func (s *SessionService) RevokeOtherSessions(ctx context.Context, userID, currentSessionID uuid.UUID) (int, error) {
+	sessions, err := s.sessions.ListSessionsByUserID(ctx, userID)
+	if err != nil {
+		return 0, utils.FromStoreError(err)
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == currentSessionID {
+			continue
+		}
+		if err := s.revoke(ctx, session.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// RevokeAllSessions ends every session of the user and rejects every access token
+// issued to them until now, returning how many sessions were ended
+func (s *SessionService) RevokeAllSessions(ctx context.Context, userID uuid.UUID) (int64, error) {
+	revokedAt := time.Now()
+
+	// Postgres keeps the durable record, Redis the copy checked on every request
+	if err := s.users.RevokeUserTokens(ctx, userID, revokedAt); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return 0, utils.ErrUserNotFound
+		}
+		return 0, utils.FromStoreError(err)
+	}
+
+	err := s.revocations.RevokeUserTokens(ctx, userID.String(), revokedAt, revokedAt.Add(s.jwt.MaxAccessTokenTTL()))
+	if err != nil {
+		return 0, utils.FromStoreError(err)
+	}
+
+	if _, err := s.refreshTokens.DeleteRefreshTokensByUserID(ctx, userID); err != nil {
+		return 0, utils.FromStoreError(err)
+	}
+
+	revoked, err := s.sessions.DeleteSessionsByUserID(ctx, userID)
+	if err != nil {
+		return 0, utils.FromStoreError(err)
+	}
+
+	return revoked, nil
+}
+
+func (s *SessionService) revoke(ctx context.Context, sessionID uuid.UUID) error {
+	if _, err := deleteSession(ctx, s.sessions, s.refreshTokens, sessionID); err != nil {
+		return utils.FromStoreError(err)
+	}
+
+	// Access tokens carry the session ID as their family ID
+	err := s.revocations.RevokeTokenFamily(ctx, sessionID.String(), time.Now().Add(s.jwt.MaxAccessTokenTTL()))
+	if err != nil {
+		return utils.FromStoreError(err)
+	}
+
+	return nil
+}
+
+// deleteSession removes a session together with its refresh tokens and returns
+// how many refresh tokens were removed
+func deleteSession(ctx context.Context, sessions repository.SessionStore, refreshTokens repository.RefreshTokenStore, sessionID uuid.UUID) (int64, error) {
+	revoked, err := refreshTokens.DeleteRefreshTokenFamily(ctx, sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = sessions.DeleteSession(ctx, sessionID)
+	if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+		return revoked, err
+	}
+
+	return revoked, nil
+}
+
+// truncate cuts value to at most maxLength characters, which is how VARCHAR
+// columns count, never splitting a multi-byte character. Bytes that aren't
+// UTF-8 are replaced, since Postgres would reject them.
+func truncate(value string, maxLength int) string {
+	value = strings.ToValidUTF8(value, "\uFFFD")
+	if utf8.RuneCountInString(value) <= maxLength {
+		return value
+	}
+
+	var length int
+	for i := range value {
+		if length == maxLength {
+			return value[:i]
+		}
+		length++
+	}
+	return value
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		maxLength int
+		want      string
+	}{
+		{"short", "Pixel 8", 255, "Pixel 8"},
+		{"ascii", "abcdef", 3, "abc"},
+		{"multi-byte kept whole", "iPhone de José", 13, "iPhone de Jos"},
+		{"counts characters, not bytes", "日本語の端末", 4, "日本語の"},
+		{"emoji", "📱📱📱", 2, "📱📱"},
+		{"invalid UTF-8", "ab\xffcd", 3, "ab�"},
+		{"exact", strings.Repeat("é", 255), 255, strings.Repeat("é", 255)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := truncate(test.value, test.maxLength)
+			if got != test.want {
+				t.Fatalf("truncate(%q, %d) = %q, want %q", test.value, test.maxLength, got, test.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Fatalf("truncate(%q, %d) = %q, not valid UTF-8", test.value, test.maxLength, got)
+			}
+		})
+	}
+}
@@ -1,32 +1,76 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/logger"
+	"github.com/randhir/aegis-core/internal/models"
 	"github.com/randhir/aegis-core/internal/repository"
 	"github.com/randhir/aegis-core/internal/utils"
+	"go.uber.org/zap"
 )
 
-type TokenService struct{}
+// rotationPollInterval is how often a duplicate refresh checks for the result of
+// the rotation that beat it
+const rotationPollInterval = 25 * time.Millisecond
 
-func NewTokenService() *TokenService {
-	return &TokenService{}
+// rotationMaxWait bounds how long a duplicate refresh waits for that result
+const rotationMaxWait = time.Second
+
+type TokenService struct {
+	users         repository.UserStore
+	refreshTokens repository.RefreshTokenStore
+	sessions      repository.SessionStore
+	revocations   cache.TokenRevocationStore
+	references    cache.ReferenceTokenStore
+	rotations     cache.RefreshRotationCache
+	events        repository.SecurityEventStore
+	jwt           *utils.JWTManager
+	rotationGrace time.Duration
+}
+
+func NewTokenService(users repository.UserStore, refreshTokens repository.RefreshTokenStore, sessions repository.SessionStore, revocations cache.TokenRevocationStore, references cache.ReferenceTokenStore, rotations cache.RefreshRotationCache, events repository.SecurityEventStore, jwt *utils.JWTManager, rotationGrace time.Duration) *TokenService {
+	return &TokenService{
+		users:         users,
+		refreshTokens: refreshTokens,
+		sessions:      sessions,
+		revocations:   revocations,
+		references:    references,
+		rotations:     rotations,
+		events:        events,
+		jwt:           jwt,
+		rotationGrace: rotationGrace,
+	}
 }
 
-// Refresh generates new access and refresh tokens, invalidating the old refresh token
-func (s *TokenService) Refresh(refreshTokenString string) (string, string, error) {
+// Refresh generates new access and refresh tokens, invalidating the old refresh token.
+// Presenting a token that was already rotated revokes its whole family, unless it
+// was rotated within the grace period, in which case the same new pair is returned.
+func (s *TokenService) Refresh(ctx context.Context, refreshTokenString string, client ClientInfo) (string, string, error) {
 	// Validate refresh token signature and expiry
-	claims, err := utils.ValidateRefreshToken(refreshTokenString)
+	claims, err := s.jwt.ValidateRefreshToken(refreshTokenString)
 	if err != nil {
 		return "", "", utils.ErrInvalidToken
 	}
 
 	// Check if refresh token exists in DB
-	dbToken, err := repository.GetRefreshTokenByToken(refreshTokenString)
+	dbToken, err := s.lookupRefreshToken(ctx, claims, refreshTokenString)
 	if err != nil {
-		return "", "", utils.ErrInvalidToken
+		return "", "", err
+	}
+
+	// A rotated-out token being presented again means it was copied, unless
+	// it is a concurrent duplicate of the request that rotated it
+	if dbToken.RotatedAt != nil {
+		if pair := s.awaitRotation(ctx, dbToken, *dbToken.RotatedAt); pair != nil {
+			return pair.AccessToken, pair.RefreshToken, nil
+		}
+		return "", "", s.handleReuse(ctx, dbToken)
 	}
 
 	// Verify token hasn't expired
@@ -47,52 +91,264 @@ func (s *TokenService) Refresh(refreshTokenString string) (string, string, error
 	}
 
 	// Get user details
-	user, err := repository.GetUserByID(userID)
+	user, err := s.users.GetUserByID(ctx, userID)
 	if err != nil {
-		return "", "", utils.ErrInternalError
+		return "", "", utils.FromStoreError(err)
 	}
 
-	// Delete old refresh token (rotation)
-	err = repository.DeleteRefreshToken(dbToken.ID)
-	if err != nil {
-		return "", "", utils.ErrInternalError
+	// Refresh tokens are deleted when a user's tokens are revoked; this also
+	// catches one issued by a login racing the revocation
+	if user.TokensRevokedAt != nil && utils.IssuedBefore(claims.IssuedAt, *user.TokensRevokedAt) {
+		return "", "", utils.ErrInvalidToken
 	}
 
-	// Generate new access token
-	accessToken, err := utils.GenerateAccessToken(user.ID.String(), user.Email, user.Role)
+	// The session keeps the client it was started by; a client since removed
+	// from the configuration can't refresh
+	if !s.jwt.KnownClient(claims.ClientID) {
+		return "", "", utils.ErrInvalidToken
+	}
+
+	session, err := s.sessions.GetSession(ctx, dbToken.FamilyID)
 	if err != nil {
-		return "", "", utils.ErrInternalError
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return "", "", utils.ErrInvalidToken
+		}
+		return "", "", utils.FromStoreError(err)
+	}
+
+	// Rotation slides the idle timeout but can't extend the session past its
+	// maximum lifetime, which may also have been shortened since login
+	expiresAt := s.jwt.SessionExpiry(user.Role, session.CreatedAt)
+	if !time.Now().Before(expiresAt) {
+		return "", "", utils.ErrInvalidToken
 	}
 
 	// Generate new refresh token with new token ID
 	newTokenID := uuid.New()
-	newRefreshToken, err := utils.GenerateRefreshToken(user.ID.String(), newTokenID.String())
+	newRefreshToken, err := s.jwt.GenerateRefreshToken(user.ID.String(), newTokenID.String(), claims.ClientID, expiresAt)
 	if err != nil {
 		return "", "", utils.ErrInternalError
 	}
 
-	// Store new refresh token
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	_, err = repository.CreateRefreshToken(user.ID, newTokenID, newRefreshToken, expiresAt)
+	newRefreshTokenHash, err := s.jwt.HashRefreshToken(newRefreshToken)
 	if err != nil {
 		return "", "", utils.ErrInternalError
 	}
 
+	// Generate new access token. It is issued before the rotation so a failure
+	// here leaves the refresh token usable for a retry.
+	accessToken, err := issueAccessToken(ctx, s.jwt, s.references, user, dbToken.FamilyID, claims.ClientID)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Rotate atomically; of several concurrent refreshes only one gets past this
+	_, err = s.refreshTokens.RotateRefreshToken(ctx, dbToken.ID, newTokenID, newRefreshTokenHash, expiresAt)
+	if err != nil {
+		// Nobody gets the access token of a refresh that didn't rotate
+		discardAccessToken(ctx, s.jwt, s.references, accessToken, claims.ClientID)
+		switch {
+		case errors.Is(err, repository.ErrRefreshTokenRotated):
+			if pair := s.awaitRotation(ctx, dbToken, time.Now()); pair != nil {
+				return pair.AccessToken, pair.RefreshToken, nil
+			}
+			return "", "", s.handleReuse(ctx, dbToken)
+		case errors.Is(err, repository.ErrRefreshTokenNotFound):
+			return "", "", utils.ErrInvalidToken
+		default:
+			return "", "", utils.FromStoreError(err)
+		}
+	}
+
+	err = s.sessions.TouchSession(ctx, dbToken.FamilyID, client.IPAddress, expiresAt)
+	if err != nil {
+		logger.Error("Failed to update session activity",
+			zap.String("session_id", dbToken.FamilyID.String()),
+			zap.Error(err),
+		)
+	}
+
+	if s.rotationGrace > 0 {
+		pair := cache.RotatedTokenPair{AccessToken: accessToken, RefreshToken: newRefreshToken}
+		err = s.rotations.StoreRotation(ctx, dbToken.TokenHash, pair, time.Now().Add(s.rotationGrace))
+		if err != nil {
+			// Duplicates will be treated as reuse, but this refresh succeeded
+			logger.Error("Failed to remember refresh token rotation", zap.Error(err))
+		}
+	}
+
 	return accessToken, newRefreshToken, nil
 }
 
-// Logout invalidates the refresh token and blacklists the access token
-func (s *TokenService) Logout(refreshTokenString, accessTokenString string) error {
+// awaitRotation returns the pair issued when dbToken was rotated at rotatedAt, if
+// that was within the grace period. The winning request stores the pair just
+// after its transaction commits, so a loser polls briefly before giving up.
+func (s *TokenService) awaitRotation(ctx context.Context, dbToken *models.RefreshToken, rotatedAt time.Time) *cache.RotatedTokenPair {
+	if s.rotationGrace <= 0 {
+		return nil
+	}
+
+	graceEnds := rotatedAt.Add(s.rotationGrace)
+	deadline := time.Now().Add(rotationMaxWait)
+	if graceEnds.Before(deadline) {
+		deadline = graceEnds
+	}
+
+	for {
+		pair, err := s.rotations.GetRotation(ctx, dbToken.TokenHash)
+		if err != nil {
+			logger.Error("Failed to look up refresh token rotation", zap.Error(err))
+			return nil
+		}
+		if pair != nil {
+			return pair
+		}
+
+		if !time.Now().Add(rotationPollInterval).Before(deadline) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(rotationPollInterval):
+		}
+	}
+}
+
+// issueAccessToken issues an access token for a session in the format configured
+// for the client: a signed JWT, or an opaque token whose claims are kept in Redis
+func issueAccessToken(ctx context.Context, jwt *utils.JWTManager, references cache.ReferenceTokenStore, user *models.User, familyID uuid.UUID, clientID string) (string, error) {
+	if jwt.AccessTokenFormat(clientID) == utils.AccessTokenFormatJWT {
+		accessToken, err := jwt.GenerateAccessToken(user.ID.String(), user.Email, user.Role, familyID.String(), clientID)
+		if err != nil {
+			return "", utils.ErrInternalError
+		}
+		return accessToken, nil
+	}
+
+	accessToken, err := utils.NewReferenceToken()
+	if err != nil {
+		return "", utils.ErrInternalError
+	}
+
+	now := time.Now()
+	err = references.StoreReferenceToken(ctx, accessToken, cache.ReferenceToken{
+		TokenID:   uuid.NewString(),
+		UserID:    user.ID.String(),
+		Email:     user.Email,
+		Role:      user.Role,
+		FamilyID:  familyID.String(),
+		ClientID:  clientID,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(jwt.AccessTokenTTL(user.Role)),
+	})
+	if err != nil {
+		return "", utils.FromStoreError(err)
+	}
+
+	return accessToken, nil
+}
+
+// discardAccessToken deletes an opaque access token that was issued but won't
+// be handed out. JWTs can't be taken back, but no one holds them either.
+func discardAccessToken(ctx context.Context, jwt *utils.JWTManager, references cache.ReferenceTokenStore, accessToken, clientID string) {
+	if jwt.AccessTokenFormat(clientID) == utils.AccessTokenFormatJWT {
+		return
+	}
+
+	if err := references.DeleteReferenceToken(context.WithoutCancel(ctx), accessToken); err != nil {
+		logger.Error("Failed to delete unused reference token", zap.Error(err))
+	}
+}
+
+// lookupRefreshToken finds the stored row for a validated refresh token by its
+// keyed hash, migrating rows that were written before tokens were hashed
+func (s *TokenService) lookupRefreshToken(ctx context.Context, claims *utils.RefreshTokenClaims, refreshTokenString string) (*models.RefreshToken, error) {
+	tokenHash, err := s.jwt.HashRefreshToken(refreshTokenString)
+	if err != nil {
+		return nil, utils.ErrInternalError
+	}
+
+	dbToken, err := s.refreshTokens.GetRefreshTokenByTokenHash(ctx, tokenHash)
+	if err == nil {
+		return dbToken, nil
+	}
+	if !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil, utils.FromStoreError(err)
+	}
+
+	tokenID, err := uuid.Parse(claims.TokenID)
+	if err != nil {
+		return nil, utils.ErrInvalidToken
+	}
+
+	dbToken, err = s.refreshTokens.ClaimLegacyRefreshToken(ctx, tokenID, refreshTokenString, tokenHash)
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+			return nil, utils.ErrInvalidToken
+		}
+		return nil, utils.FromStoreError(err)
+	}
+
+	return dbToken, nil
+}
+
+// handleReuse revokes every refresh and access token in the family of a replayed
+// refresh token and records the event for alerting
+func (s *TokenService) handleReuse(ctx context.Context, dbToken *models.RefreshToken) error {
+	logger.Warn("Refresh token reuse detected, revoking token family",
+		zap.String("event", models.SecurityEventRefreshTokenReuse),
+		zap.String("user_id", dbToken.UserID.String()),
+		zap.String("family_id", dbToken.FamilyID.String()),
+	)
+
+	revoked, err := deleteSession(ctx, s.sessions, s.refreshTokens, dbToken.FamilyID)
+	if err != nil {
+		logger.Error("Failed to revoke refresh token family",
+			zap.String("family_id", dbToken.FamilyID.String()),
+			zap.Error(err),
+		)
+		return utils.FromStoreError(err)
+	}
+
+	// Access tokens from this family live at most one access token lifetime from now
+	err = s.revocations.RevokeTokenFamily(ctx, dbToken.FamilyID.String(), time.Now().Add(s.jwt.MaxAccessTokenTTL()))
+	if err != nil {
+		logger.Error("Failed to revoke access tokens of token family",
+			zap.String("family_id", dbToken.FamilyID.String()),
+			zap.Error(err),
+		)
+		return utils.FromStoreError(err)
+	}
+
+	details := fmt.Sprintf("family_id=%s token_id=%s revoked_refresh_tokens=%d", dbToken.FamilyID, dbToken.ID, revoked)
+	if err := s.events.RecordSecurityEvent(ctx, models.SecurityEventRefreshTokenReuse, &dbToken.UserID, details); err != nil {
+		logger.Error("Failed to record security event",
+			zap.String("event", models.SecurityEventRefreshTokenReuse),
+			zap.Error(err),
+		)
+	}
+
+	return utils.ErrInvalidToken
+}
+
+// Logout invalidates the refresh token family and blacklists the access token
+func (s *TokenService) Logout(ctx context.Context, refreshTokenString, accessTokenString string) error {
 	// Validate refresh token to get claims
-	claims, err := utils.ValidateRefreshToken(refreshTokenString)
+	claims, err := s.jwt.ValidateRefreshToken(refreshTokenString)
 	if err != nil {
 		return utils.ErrInvalidToken
 	}
 
 	// Get refresh token from DB
-	dbToken, err := repository.GetRefreshTokenByToken(refreshTokenString)
+	dbToken, err := s.lookupRefreshToken(ctx, claims, refreshTokenString)
 	if err != nil {
-		return utils.ErrInvalidToken
+		return err
+	}
+
+	if dbToken.RotatedAt != nil {
+		return s.handleReuse(ctx, dbToken)
 	}
 
 	// Verify token ID matches
@@ -101,26 +357,41 @@ func (s *TokenService) Logout(refreshTokenString, accessTokenString string) erro
 		return utils.ErrInvalidToken
 	}
 
-	// Delete refresh token from DB
-	err = repository.DeleteRefreshToken(dbToken.ID)
+	// End the session, deleting the token together with its rotated-out ancestors
+	_, err = deleteSession(ctx, s.sessions, s.refreshTokens, dbToken.FamilyID)
 	if err != nil {
-		return utils.ErrInternalError
+		return utils.FromStoreError(err)
+	}
+
+	// Opaque access tokens are revoked by forgetting them
+	if utils.IsReferenceToken(accessTokenString) {
+		if err := s.references.DeleteReferenceToken(ctx, accessTokenString); err != nil {
+			logger.Error("Failed to revoke reference access token on logout", zap.Error(err))
+		}
+		return nil
 	}
 
 	// Blacklist access token in Redis
 	if accessTokenString != "" {
-		// Parse access token to get expiry
-		accessClaims, err := utils.ValidateAccessToken(accessTokenString)
+		// Parse access token to get its jti and expiry
+		accessClaims, err := s.jwt.ValidateAccessToken(accessTokenString)
 		if err == nil && accessClaims.ExpiresAt != nil {
 			expiryTime := accessClaims.ExpiresAt.Time
-			err = cache.BlacklistAccessToken(accessTokenString, expiryTime)
-			if err != nil {
-				// Log error but don't fail logout
-				// In production, you might want to handle this differently
+			if accessClaims.ID != "" {
+				err = s.revocations.BlacklistAccessToken(ctx, accessClaims.ID, expiryTime)
+				if err != nil {
+					// Log error but don't fail logout
+					logger.Error("Failed to blacklist access token on logout", zap.Error(err))
+				}
+			}
+			if s.jwt.UsesLegacyBlacklist(accessClaims) {
+				err = s.revocations.BlacklistLegacyAccessToken(ctx, accessTokenString, expiryTime)
+				if err != nil {
+					logger.Error("Failed to blacklist legacy access token on logout", zap.Error(err))
+				}
 			}
 		}
 	}
 
 	return nil
 }
-
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/randhir/aegis-core/internal/cache"
+	"github.com/randhir/aegis-core/internal/config"
+	"github.com/randhir/aegis-core/internal/models"
+	"github.com/randhir/aegis-core/internal/repository"
+	"github.com/randhir/aegis-core/internal/utils"
+)
+
+type testTokens struct {
+	service       *TokenService
+	sessions      *repository.MemorySessionStore
+	refreshTokens *repository.MemoryRefreshTokenStore
+	references    *trackedReferenceTokenStore
+	sessionID     uuid.UUID
+	refreshToken  string
+}
+
+// trackedReferenceTokenStore remembers which reference tokens are live
+type trackedReferenceTokenStore struct {
+	*cache.MemoryReferenceTokenStore
+	mu   sync.Mutex
+	live map[string]bool
+}
+
+func (s *trackedReferenceTokenStore) StoreReferenceToken(ctx context.Context, token string, claims cache.ReferenceToken) error {
+	s.mu.Lock()
+	s.live[token] = true
+	s.mu.Unlock()
+	return s.MemoryReferenceTokenStore.StoreReferenceToken(ctx, token, claims)
+}
+
+func (s *trackedReferenceTokenStore) DeleteReferenceToken(ctx context.Context, token string) error {
+	s.mu.Lock()
+	delete(s.live, token)
+	s.mu.Unlock()
+	return s.MemoryReferenceTokenStore.DeleteReferenceToken(ctx, token)
+}
+
+// newTestTokens returns a TokenService on memory stores and the refresh token
+// of a freshly started session
+func newTestTokens(t *testing.T, rotationGrace time.Duration) *testTokens {
+	return newTestTokensWithFormat(t, rotationGrace, utils.AccessTokenFormatJWT)
+}
+
+// newTestTokensWithFormat is newTestTokens issuing access tokens in format
+func newTestTokensWithFormat(t *testing.T, rotationGrace time.Duration, format string) *testTokens {
+	t.Helper()
+	ctx := context.Background()
+
+	jwt, err := utils.NewJWTManager(config.JWTConfig{
+		AccessTokenFormat:   format,
+		AccessSecret:        "test-access-secret-at-least-32-bytes",
+		RefreshSecret:       "test-refresh-secret-at-least-32-bytes",
+		RefreshTokenHashKey: "test-refresh-hash-key",
+		Sessions:            config.SessionPolicy{AccessTokenTTL: time.Minute, IdleTimeout: time.Hour},
+		Issuer:              "aegis-test",
+		Audience:            "aegis-test",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := repository.NewMemoryUserStore()
+	user, err := users.CreateUser(ctx, "refresh@example.com", "unused", models.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := repository.NewMemorySessionStore()
+	refreshTokens := repository.NewMemoryRefreshTokenStore()
+	references := &trackedReferenceTokenStore{
+		MemoryReferenceTokenStore: cache.NewMemoryReferenceTokenStore(),
+		live:                      make(map[string]bool),
+	}
+	service := NewTokenService(users, refreshTokens, sessions, cache.NewMemoryTokenRevocationStore(),
+		references, cache.NewMemoryRefreshRotationCache(),
+		repository.NewMemorySecurityEventStore(), jwt, rotationGrace)
+
+	sessionID := uuid.New()
+	tokenID := uuid.New()
+	expiresAt := jwt.SessionExpiry(user.Role, time.Now())
+	refreshToken, err := jwt.GenerateRefreshToken(user.ID.String(), tokenID.String(), "", expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenHash, err := jwt.HashRefreshToken(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessions.CreateSession(ctx, models.Session{ID: sessionID, UserID: user.ID, ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := refreshTokens.CreateRefreshToken(ctx, user.ID, tokenID, sessionID, tokenHash, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testTokens{
+		service:       service,
+		sessions:      sessions,
+		refreshTokens: refreshTokens,
+		references:    references,
+		sessionID:     sessionID,
+		refreshToken:  refreshToken,
+	}
+}
+
+type refreshResult struct {
+	accessToken  string
+	refreshToken string
+	err          error
+}
+
+// refreshConcurrently presents refreshToken from n goroutines at once
+func refreshConcurrently(service *TokenService, refreshToken string, n int) []refreshResult {
+	results := make([]refreshResult, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			accessToken, newRefreshToken, err := service.Refresh(context.Background(), refreshToken, ClientInfo{})
+			results[i] = refreshResult{accessToken, newRefreshToken, err}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return results
+}
+
+func TestRefreshConcurrentDuplicatesShareOnePair(t *testing.T) {
+	tokens := newTestTokens(t, 5*time.Second)
+
+	results := refreshConcurrently(tokens.service, tokens.refreshToken, 20)
+
+	first := results[0]
+	for i, result := range results {
+		if result.err != nil {
+			t.Fatalf("refresh %d error = %v", i, result.err)
+		}
+		if result != first {
+			t.Fatalf("refresh %d got a different token pair than refresh 0", i)
+		}
+	}
+
+	if _, err := tokens.sessions.GetSession(context.Background(), tokens.sessionID); err != nil {
+		t.Fatalf("session was revoked by concurrent duplicates: %v", err)
+	}
+
+	// The new refresh token works, so exactly one rotation happened
+	if _, _, err := tokens.service.Refresh(context.Background(), first.refreshToken, ClientInfo{}); err != nil {
+		t.Fatalf("refresh with the rotated token error = %v", err)
+	}
+}
+
+func TestRefreshConcurrentWithoutGraceRotatesOnce(t *testing.T) {
+	tokens := newTestTokens(t, 0)
+
+	results := refreshConcurrently(tokens.service, tokens.refreshToken, 20)
+
+	var succeeded int
+	for i, result := range results {
+		switch {
+		case result.err == nil:
+			succeeded++
+		case !errors.Is(result.err, utils.ErrInvalidToken):
+			t.Fatalf("refresh %d error = %v, want ErrInvalidToken", i, result.err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d concurrent refreshes succeeded, want 1", succeeded)
+	}
+
+	// The losers look like a replayed token, which revokes the whole family
+	if _, err := tokens.sessions.GetSession(context.Background(), tokens.sessionID); !errors.Is(err, repository.ErrSessionNotFound) {
+		t.Fatalf("GetSession() after reuse error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+// lockstepRefreshTokenStore holds every lookup until all of them have been
+// made, so each of the concurrent refreshes races for the rotation
+type lockstepRefreshTokenStore struct {
+	*repository.MemoryRefreshTokenStore
+	lookups sync.WaitGroup
+}
+
+func (s *lockstepRefreshTokenStore) GetRefreshTokenByTokenHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	refreshToken, err := s.MemoryRefreshTokenStore.GetRefreshTokenByTokenHash(ctx, tokenHash)
+	s.lookups.Done()
+	s.lookups.Wait()
+	return refreshToken, err
+}
+
+// Refreshes that lose the rotation must not leave opaque access tokens behind
+func TestRefreshConcurrentLeavesNoOrphanReferenceTokens(t *testing.T) {
+	const n = 20
+	for _, grace := range []time.Duration{0, 5 * time.Second} {
+		tokens := newTestTokensWithFormat(t, grace, utils.AccessTokenFormatOpaque)
+		lockstep := &lockstepRefreshTokenStore{MemoryRefreshTokenStore: tokens.refreshTokens}
+		lockstep.lookups.Add(n)
+		tokens.service.refreshTokens = lockstep
+
+		results := refreshConcurrently(tokens.service, tokens.refreshToken, n)
+
+		issued := make(map[string]bool)
+		for _, result := range results {
+			if result.err == nil {
+				issued[result.accessToken] = true
+			}
+		}
+		for token := range tokens.references.live {
+			if !issued[token] {
+				t.Fatalf("grace %s: a reference token nobody received is still live", grace)
+			}
+		}
+	}
+}
+
+func TestRefreshReplayWithinGraceReturnsSamePair(t *testing.T) {
+	tokens := newTestTokens(t, 5*time.Second)
+	ctx := context.Background()
+
+	accessToken, refreshToken, err := tokens.service.Refresh(ctx, tokens.refreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayedAccess, replayedRefresh, err := tokens.service.Refresh(ctx, tokens.refreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("replay within grace error = %v", err)
+	}
+	if replayedAccess != accessToken || replayedRefresh != refreshToken {
+		t.Fatal("replay within grace got a different token pair")
+	}
+}
+
+func TestRefreshReplayAfterGraceRevokesFamily(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	tokens := newTestTokens(t, grace)
+	ctx := context.Background()
+
+	_, refreshToken, err := tokens.service.Refresh(ctx, tokens.refreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * grace)
+
+	if _, _, err := tokens.service.Refresh(ctx, tokens.refreshToken, ClientInfo{}); !errors.Is(err, utils.ErrInvalidToken) {
+		t.Fatalf("replay after grace error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.sessions.GetSession(ctx, tokens.sessionID); !errors.Is(err, repository.ErrSessionNotFound) {
+		t.Fatalf("GetSession() after reuse error = %v, want ErrSessionNotFound", err)
+	}
+	if _, _, err := tokens.service.Refresh(ctx, refreshToken, ClientInfo{}); !errors.Is(err, utils.ErrInvalidToken) {
+		t.Fatalf("refresh with the revoked family's newest token error = %v, want ErrInvalidToken", err)
+	}
+}
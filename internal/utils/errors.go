@@ -1,7 +1,11 @@
 package utils
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"net"
 	"net/http"
 )
 
@@ -17,13 +21,28 @@ func (e *AppError) Error() string {
 
 // Predefined application errors
 var (
-	ErrInvalidRequest   = &AppError{Message: "invalid request", StatusCode: http.StatusBadRequest}
-	ErrUnauthorized     = &AppError{Message: "unauthorized", StatusCode: http.StatusUnauthorized}
-	ErrForbidden        = &AppError{Message: "forbidden", StatusCode: http.StatusForbidden}
-	ErrConflict         = &AppError{Message: "email already exists", StatusCode: http.StatusConflict}
+	ErrInvalidRequest     = &AppError{Message: "invalid request", StatusCode: http.StatusBadRequest}
+	ErrUnauthorized       = &AppError{Message: "unauthorized", StatusCode: http.StatusUnauthorized}
+	ErrForbidden          = &AppError{Message: "forbidden", StatusCode: http.StatusForbidden}
+	ErrConflict           = &AppError{Message: "email already exists", StatusCode: http.StatusConflict}
 	ErrInvalidCredentials = &AppError{Message: "invalid credentials", StatusCode: http.StatusUnauthorized}
-	ErrInvalidToken     = &AppError{Message: "invalid or expired token", StatusCode: http.StatusUnauthorized}
-	ErrInternalError    = &AppError{Message: "internal server error", StatusCode: http.StatusInternalServerError}
+	ErrInvalidToken       = &AppError{Message: "invalid or expired token", StatusCode: http.StatusUnauthorized}
+	ErrUnknownClient      = &AppError{Message: "unknown client", StatusCode: http.StatusBadRequest}
+	ErrUserNotFound       = &AppError{Message: "user not found", StatusCode: http.StatusNotFound}
+	ErrSessionNotFound    = &AppError{Message: "session not found", StatusCode: http.StatusNotFound}
+	ErrInvalidMFACode     = &AppError{Message: "invalid mfa code", StatusCode: http.StatusUnauthorized}
+	ErrMFANotEnrolled     = &AppError{Message: "mfa not enrolled", StatusCode: http.StatusNotFound}
+	ErrMFAAlreadyEnabled  = &AppError{Message: "mfa already enabled", StatusCode: http.StatusConflict}
+	ErrMFANotConfigured   = &AppError{Message: "mfa is not configured", StatusCode: http.StatusNotImplemented}
+	ErrInvalidPasskey     = &AppError{Message: "invalid passkey", StatusCode: http.StatusUnauthorized}
+	ErrPasskeyNotFound    = &AppError{Message: "passkey not found", StatusCode: http.StatusNotFound}
+	ErrPasskeyExists      = &AppError{Message: "passkey already registered", StatusCode: http.StatusConflict}
+	ErrPasskeysDisabled   = &AppError{Message: "passkeys are not configured", StatusCode: http.StatusNotImplemented}
+	ErrTooManyRequests    = &AppError{Message: "too many requests", StatusCode: http.StatusTooManyRequests}
+	ErrInternalError      = &AppError{Message: "internal server error", StatusCode: http.StatusInternalServerError}
+	ErrServiceUnavailable = &AppError{Message: "service temporarily unavailable", StatusCode: http.StatusServiceUnavailable}
+	ErrServerBusy         = &AppError{Message: "server busy, try again later", StatusCode: http.StatusServiceUnavailable}
+	ErrGatewayTimeout     = &AppError{Message: "upstream request timed out", StatusCode: http.StatusGatewayTimeout}
 )
 
 // ToAppError converts a standard error to AppError
@@ -33,6 +52,10 @@ func ToAppError(err error) *AppError {
 		return appErr
 	}
 
+	if storeErr := FromStoreError(err); storeErr != ErrInternalError {
+		return storeErr
+	}
+
 	// Map common error messages to AppError
 	errMsg := err.Error()
 	switch errMsg {
@@ -49,3 +72,25 @@ func ToAppError(err error) *AppError {
 	}
 }
 
+// FromStoreError maps an error from a database or cache call to the AppError
+// returned to clients: deadlines become 504, unreachable backends 503, and
+// everything else a generic 500.
+func FromStoreError(err error) *AppError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrGatewayTimeout
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return ErrServiceUnavailable
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrGatewayTimeout
+		}
+		return ErrServiceUnavailable
+	}
+
+	return ErrInternalError
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported JWE key management algorithms; content is always encrypted with A256GCM
+const (
+	EncryptionDirect     = "dir"
+	EncryptionRSAOAEP    = "RSA-OAEP"
+	EncryptionRSAOAEP256 = "RSA-OAEP-256"
+)
+
+const jweContentEncryption = "A256GCM"
+
+// EncryptionKey wraps tokens in JWE (RFC 7516) so their claims can't be read by
+// whoever holds them. Direct keys are the AES-256 content key itself; RSA keys
+// encrypt a fresh content key per token.
+type EncryptionKey struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   *rsa.PrivateKey
+}
+
+type jweHeader struct {
+	Algorithm   string `json:"alg"`
+	Encryption  string `json:"enc"`
+	KeyID       string `json:"kid,omitempty"`
+	ContentType string `json:"cty,omitempty"`
+}
+
+// NewEncryptionKey builds a key with the given ID from raw key material: a
+// 32-byte secret for dir, or a PEM-encoded RSA private key for RSA-OAEP
+func NewEncryptionKey(id, algorithm string, material []byte) (*EncryptionKey, error) {
+	key := &EncryptionKey{ID: id, Algorithm: algorithm}
+
+	switch algorithm {
+	case EncryptionDirect:
+		if len(material) != 32 {
+			return nil, fmt.Errorf("dir encryption needs a 32-byte key, got %d bytes", len(material))
+		}
+		key.secret = material
+	case EncryptionRSAOAEP, EncryptionRSAOAEP256:
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(material)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA encryption key: %w", err)
+		}
+		if privateKey.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA encryption key must be at least 2048 bits, got %d", privateKey.N.BitLen())
+		}
+		key.private = privateKey
+	default:
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", algorithm)
+	}
+
+	if key.ID == "" {
+		key.ID = key.thumbprint()
+	}
+	return key, nil
+}
+
+// LoadEncryptionKey reads the configured key: base64 for dir, or a PEM file for RSA-OAEP
+func LoadEncryptionKey(algorithm, encodedKey, pemFile string) (*EncryptionKey, error) {
+	if algorithm == EncryptionDirect {
+		secret, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access token encryption key: %w", err)
+		}
+		return NewEncryptionKey("", algorithm, secret)
+	}
+
+	if pemFile == "" {
+		return nil, fmt.Errorf("a private key file is required for %s", algorithm)
+	}
+
+	pemData, err := os.ReadFile(pemFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+
+	return NewEncryptionKey("", algorithm, pemData)
+}
+
+// IsEncryptedToken reports whether token is in JWE compact serialization, which
+// has five parts where a JWT has three
+func IsEncryptedToken(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// Encrypt wraps a token in compact JWE
+func (k *EncryptionKey) Encrypt(token string) (string, error) {
+	header, err := json.Marshal(jweHeader{
+		Algorithm:   k.Algorithm,
+		Encryption:  jweContentEncryption,
+		KeyID:       k.ID,
+		ContentType: "JWT",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWE header: %w", err)
+	}
+
+	contentKey := k.secret
+	var encryptedKey []byte
+	if k.private != nil {
+		contentKey = make([]byte, 32)
+		if _, err := rand.Read(contentKey); err != nil {
+			return "", fmt.Errorf("failed to generate content key: %w", err)
+		}
+		encryptedKey, err = rsa.EncryptOAEP(k.oaepHash(), rand.Reader, &k.private.PublicKey, contentKey, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt content key: %w", err)
+		}
+	}
+
+	aead, err := newContentCipher(contentKey)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", fmt.Errorf("failed to generate IV: %w", err)
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	protected := encode(header)
+	sealed := aead.Seal(nil, iv, []byte(token), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+
+	return strings.Join([]string{protected, encode(encryptedKey), encode(iv), encode(ciphertext), encode(tag)}, "."), nil
+}
+
+// DecryptToken unwraps a compact JWE with the key named in its header
+func DecryptToken(token string, keys EncryptionKeySource) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return "", errors.New("malformed JWE")
+	}
+
+	decoded := make([][]byte, len(parts))
+	for i, part := range parts {
+		value, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return "", fmt.Errorf("malformed JWE: %w", err)
+		}
+		decoded[i] = value
+	}
+
+	var header jweHeader
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return "", fmt.Errorf("malformed JWE header: %w", err)
+	}
+	if header.Encryption != jweContentEncryption {
+		return "", fmt.Errorf("unsupported JWE content encryption %q", header.Encryption)
+	}
+
+	key, err := keys.DecryptionKey(header.KeyID)
+	if err != nil {
+		return "", err
+	}
+	// The key decides the algorithm, never the token
+	if header.Algorithm != key.Algorithm {
+		return "", fmt.Errorf("unexpected JWE algorithm %q", header.Algorithm)
+	}
+
+	contentKey := key.secret
+	if key.private != nil {
+		contentKey, err = rsa.DecryptOAEP(key.oaepHash(), nil, key.private, decoded[1], nil)
+		if err != nil {
+			return "", errors.New("failed to decrypt content key")
+		}
+	} else if len(decoded[1]) != 0 {
+		return "", errors.New("dir JWE must not carry an encrypted key")
+	}
+
+	aead, err := newContentCipher(contentKey)
+	if err != nil {
+		return "", err
+	}
+	if len(decoded[2]) != aead.NonceSize() {
+		return "", errors.New("malformed JWE IV")
+	}
+
+	plaintext, err := aead.Open(nil, decoded[2], append(decoded[3], decoded[4]...), []byte(parts[0]))
+	if err != nil {
+		return "", errors.New("failed to decrypt token")
+	}
+
+	return string(plaintext), nil
+}
+
+func newContentCipher(contentKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create content cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *EncryptionKey) oaepHash() hash.Hash {
+	if k.Algorithm == EncryptionRSAOAEP {
+		return sha1.New()
+	}
+	return sha256.New()
+}
+
+// thumbprint derives a stable key ID that reveals nothing about the key
+func (k *EncryptionKey) thumbprint() string {
+	var digest [32]byte
+	if k.private != nil {
+		digest = sha256.Sum256(k.private.PublicKey.N.Bytes())
+	} else {
+		digest = sha256.Sum256(append([]byte("dir:"), k.secret...))
+	}
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
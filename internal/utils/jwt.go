@@ -1,110 +1,361 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/randhir/aegis-core/internal/config"
 )
 
 type AccessTokenClaims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	FamilyID string `json:"family_id,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type RefreshTokenClaims struct {
-	UserID  string `json:"user_id"`
-	TokenID string `json:"token_id"`
+	UserID   string `json:"user_id"`
+	TokenID  string `json:"token_id"`
+	ClientID string `json:"client_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateAccessToken(userID, email, role string) (string, error) {
-	secret := config.AppConfig.JWT.AccessSecret
-	if secret == "" {
-		return "", errors.New("JWT_ACCESS_SECRET not configured")
+// JWTManager issues and validates access and refresh tokens in the configured
+// token format
+type JWTManager struct {
+	cfg     config.JWTConfig
+	access  KeySource
+	refresh KeySource
+	// encryption wraps access tokens in JWE when it has an encryption key
+	encryption EncryptionKeySource
+	// accessCodec and refreshCodec encode new tokens; decoders are every codec
+	// tokens are accepted in, picked by the token's format
+	accessCodec  TokenCodec
+	refreshCodec TokenCodec
+	decoders     []TokenCodec
+	validator    *jwt.Validator
+}
+
+// NewJWTManager loads the configured signing keys. If keys is not nil, it
+// supplies rotating keys that take precedence over the configured ones.
+func NewJWTManager(cfg config.JWTConfig, keys KeyProvider) (*JWTManager, error) {
+	var accessKey *SigningKey
+	switch cfg.AccessAlgorithm {
+	case "", AlgorithmHS256:
+		if cfg.AccessSecret != "" {
+			accessKey = NewHMACSigningKey([]byte(cfg.AccessSecret))
+		}
+	default:
+		key, err := LoadSigningKey(cfg.AccessAlgorithm, cfg.AccessPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		accessKey = key
+	}
+
+	formats := []string{cfg.AccessTokenFormat}
+	for _, format := range cfg.ClientAccessTokenFormats {
+		formats = append(formats, format)
+	}
+	for _, format := range formats {
+		if format != "" && format != AccessTokenFormatJWT && format != AccessTokenFormatOpaque {
+			return nil, fmt.Errorf("unsupported access token format %q", format)
+		}
+	}
+
+	var refreshKey *SigningKey
+	if cfg.RefreshSecret != "" {
+		refreshKey = NewHMACSigningKey([]byte(cfg.RefreshSecret))
+	}
+
+	var encryptionKey *EncryptionKey
+	if cfg.AccessTokenEncryption != "" {
+		key, err := LoadEncryptionKey(cfg.AccessTokenEncryption, cfg.AccessTokenEncryptionKey, cfg.AccessTokenEncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encryptionKey = key
+	}
+
+	accessCodec, err := NewTokenCodec(cfg.TokenCodec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only this service reads refresh tokens, so they never need a public key
+	refreshCodec := accessCodec
+	if cfg.TokenCodec == TokenCodecPasetoPublic {
+		refreshCodec = pasetoCodec{purpose: pasetoLocal}
+	}
+
+	if err := checkCodecKey(accessCodec, accessKey); err != nil {
+		return nil, err
 	}
+	if err := checkCodecKey(refreshCodec, refreshKey); err != nil {
+		return nil, err
+	}
+
+	decoders := []TokenCodec{accessCodec, refreshCodec}
+	for _, name := range cfg.AcceptedTokenCodecs {
+		codec, err := NewTokenCodec(name)
+		if err != nil {
+			return nil, err
+		}
+		decoders = append(decoders, codec)
+	}
+
+	m := &JWTManager{
+		cfg:          cfg,
+		access:       NewStaticKeySource(accessKey, "JWT_ACCESS_SECRET not configured"),
+		refresh:      NewStaticKeySource(refreshKey, "JWT_REFRESH_SECRET not configured"),
+		encryption:   NewStaticEncryptionKeySource(encryptionKey),
+		accessCodec:  accessCodec,
+		refreshCodec: refreshCodec,
+		decoders:     slices.Compact(decoders),
+		validator:    jwt.NewValidator(jwt.WithLeeway(cfg.Leeway)),
+	}
+
+	if keys != nil {
+		m.access = keys.KeySource(KeyPurposeAccess, m.access)
+		m.refresh = keys.KeySource(KeyPurposeRefresh, m.refresh)
+		m.encryption = keys.EncryptionKeySource(m.encryption)
+	}
+
+	return m, nil
+}
 
+// KnownClient reports whether tokens can be issued to clientID; the empty ID
+// stands for clients that don't identify themselves
+func (m *JWTManager) KnownClient(clientID string) bool {
+	if clientID == "" {
+		return true
+	}
+	_, hasAudiences := m.cfg.ClientAudiences[clientID]
+	_, hasFormat := m.cfg.ClientAccessTokenFormats[clientID]
+	return hasAudiences || hasFormat
+}
+
+// AccessTokenFormat returns the format of the access tokens issued to clientID
+func (m *JWTManager) AccessTokenFormat(clientID string) string {
+	format, ok := m.cfg.ClientAccessTokenFormats[clientID]
+	if !ok {
+		format = m.cfg.AccessTokenFormat
+	}
+	if format == "" {
+		return AccessTokenFormatJWT
+	}
+	return format
+}
+
+// AccessTokenTTL is how long access tokens issued to users with role stay valid
+func (m *JWTManager) AccessTokenTTL(role string) time.Duration {
+	return m.cfg.SessionPolicy(role).AccessTokenTTL
+}
+
+// GenerateAccessToken issues an access token for this service's API and any
+// other services configured for clientID
+func (m *JWTManager) GenerateAccessToken(userID, email, role, familyID, clientID string) (string, error) {
+	key, err := m.access.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	audience := append([]string{m.cfg.Audience}, m.cfg.ClientAudiences[clientID]...)
 	claims := AccessTokenClaims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:   userID,
+		Email:    email,
+		Role:     role,
+		FamilyID: familyID,
+		ClientID: clientID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			Issuer:    m.cfg.Issuer,
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.cfg.SessionPolicy(role).AccessTokenTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	token, err := m.accessCodec.Encode(key, claims)
+	if err != nil {
+		return "", err
+	}
+
+	encryptionKey, err := m.encryption.EncryptionKey()
+	if err != nil || encryptionKey == nil {
+		return token, err
+	}
+	return encryptionKey.Encrypt(token)
+}
+
+// SessionExpiry is when a session started at sessionStart ends unless it is
+// refreshed before then: after the idle timeout for role, but never past the
+// maximum session lifetime
+func (m *JWTManager) SessionExpiry(role string, sessionStart time.Time) time.Time {
+	policy := m.cfg.SessionPolicy(role)
+	expiresAt := time.Now().Add(policy.IdleTimeout)
+	if policy.MaxLifetime > 0 {
+		if deadline := sessionStart.Add(policy.MaxLifetime); deadline.Before(expiresAt) {
+			return deadline
+		}
+	}
+	return expiresAt
+}
+
+// MaxAccessTokenTTL is the longest any access token stays valid
+func (m *JWTManager) MaxAccessTokenTTL() time.Duration {
+	return m.cfg.MaxAccessTokenTTL()
 }
 
-func GenerateRefreshToken(userID, tokenID string) (string, error) {
-	secret := config.AppConfig.JWT.RefreshSecret
-	if secret == "" {
-		return "", errors.New("JWT_REFRESH_SECRET not configured")
+// UsesLegacyBlacklist reports whether an access token must also be blacklisted
+// under its full token string: tokens issued without a jti always are, and every
+// token is during the compatibility window so instances still on the old keying
+// see revocations made by upgraded ones
+func (m *JWTManager) UsesLegacyBlacklist(claims *AccessTokenClaims) bool {
+	return claims.ID == "" || time.Now().Before(m.cfg.LegacyBlacklistUntil)
+}
+
+// GenerateRefreshToken issues a refresh token valid until expiresAt, normally
+// from SessionExpiry; its only audience is the issuer itself, since no other
+// service may accept it
+func (m *JWTManager) GenerateRefreshToken(userID, tokenID, clientID string, expiresAt time.Time) (string, error) {
+	key, err := m.refresh.SigningKey()
+	if err != nil {
+		return "", err
 	}
 
+	now := time.Now()
 	claims := RefreshTokenClaims{
-		UserID:  userID,
-		TokenID: tokenID,
+		UserID:   userID,
+		TokenID:  tokenID,
+		ClientID: clientID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    m.cfg.Issuer,
+			Audience:  jwt.ClaimStrings{m.cfg.Issuer},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return m.refreshCodec.Encode(key, claims)
 }
 
-func ValidateAccessToken(tokenString string) (*AccessTokenClaims, error) {
-	secret := config.AppConfig.JWT.AccessSecret
-	if secret == "" {
-		return nil, errors.New("JWT_ACCESS_SECRET not configured")
-	}
-
-	token, err := jwt.ParseWithClaims(tokenString, &AccessTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+// ValidateAccessToken decrypts the token first if it is a JWE. Unencrypted
+// tokens are accepted too, since they are still signed.
+func (m *JWTManager) ValidateAccessToken(tokenString string) (*AccessTokenClaims, error) {
+	if IsEncryptedToken(tokenString) {
+		decrypted, err := DecryptToken(tokenString, m.encryption)
+		if err != nil {
+			return nil, err
 		}
-		return []byte(secret), nil
-	})
+		tokenString = decrypted
+	}
 
-	if err != nil {
+	claims := &AccessTokenClaims{}
+	if err := m.decode(tokenString, m.access, claims); err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*AccessTokenClaims); ok && token.Valid {
-		return claims, nil
+	if err := m.validateClaims(claims, claims.RegisteredClaims, m.cfg.Audience); err != nil {
+		return nil, err
 	}
 
-	return nil, errors.New("invalid token claims")
+	return claims, nil
 }
 
-func ValidateRefreshToken(tokenString string) (*RefreshTokenClaims, error) {
-	secret := config.AppConfig.JWT.RefreshSecret
-	if secret == "" {
-		return nil, errors.New("JWT_REFRESH_SECRET not configured")
+func (m *JWTManager) ValidateRefreshToken(tokenString string) (*RefreshTokenClaims, error) {
+	claims := &RefreshTokenClaims{}
+	if err := m.decode(tokenString, m.refresh, claims); err != nil {
+		return nil, err
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &RefreshTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+	if err := m.validateClaims(claims, claims.RegisteredClaims, m.cfg.Issuer); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// decode verifies a token with the codec for its format
+func (m *JWTManager) decode(tokenString string, keys KeySource, claims jwt.Claims) error {
+	for _, codec := range m.decoders {
+		if codec.Handles(tokenString) {
+			return codec.Decode(tokenString, keys, claims)
 		}
-		return []byte(secret), nil
-	})
+	}
+	return errors.New("unsupported token format")
+}
 
-	if err != nil {
-		return nil, err
+// validateClaims checks the time claims, allowing for clock skew, and the issuer and audience
+func (m *JWTManager) validateClaims(claims jwt.Claims, registered jwt.RegisteredClaims, audience string) error {
+	if err := m.validator.Validate(claims); err != nil {
+		return err
 	}
+	return m.verifyIssuer(registered, audience)
+}
 
-	if claims, ok := token.Claims.(*RefreshTokenClaims); ok && token.Valid {
-		return claims, nil
+// verifyIssuer rejects tokens minted by another issuer or for another audience.
+// Tokens without an issuer predate these claims and are only accepted during
+// the LegacyClaimsUntil window.
+func (m *JWTManager) verifyIssuer(claims jwt.RegisteredClaims, audience string) error {
+	if claims.Issuer == "" && time.Now().Before(m.cfg.LegacyClaimsUntil) {
+		return nil
 	}
 
-	return nil, errors.New("invalid token claims")
+	if claims.Issuer != m.cfg.Issuer {
+		return fmt.Errorf("%w: %q", jwt.ErrTokenInvalidIssuer, claims.Issuer)
+	}
+	if !slices.Contains(claims.Audience, audience) {
+		return fmt.Errorf("%w: %q not in %q", jwt.ErrTokenInvalidAudience, audience, claims.Audience)
+	}
+	return nil
 }
 
+// JWKS returns the public keys that verify access tokens. It is empty for HS256,
+// whose secret must never be published.
+func (m *JWTManager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range m.access.VerificationKeys() {
+		if jwk, ok := key.PublicJWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
+
+// IssuedBefore reports whether a token with the given iat was issued at or before
+// epoch. iat has one-second precision, so a token from the same second as epoch
+// counts as issued before it; tokens without iat always do.
+func IssuedBefore(issuedAt *jwt.NumericDate, epoch time.Time) bool {
+	if issuedAt == nil {
+		return true
+	}
+	return issuedAt.Unix() <= epoch.Unix()
+}
+
+// HashRefreshToken returns the keyed hash under which a refresh token is stored,
+// so a database leak does not expose usable tokens
+func (m *JWTManager) HashRefreshToken(tokenString string) (string, error) {
+	key := m.cfg.RefreshTokenHashKey
+	if key == "" {
+		return "", errors.New("REFRESH_TOKEN_HASH_KEY not configured")
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(tokenString))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
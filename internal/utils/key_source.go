@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Token purposes a key can be issued for
+const (
+	KeyPurposeAccess  = "access"
+	KeyPurposeRefresh = "refresh"
+	// KeyPurposeEncryption keys wrap access tokens in JWE
+	KeyPurposeEncryption = "encryption"
+)
+
+// KeySource supplies the keys for one token purpose
+type KeySource interface {
+	// SigningKey returns the key new tokens are signed with
+	SigningKey() (*SigningKey, error)
+	// VerificationKey returns the key a token with the given kid header was
+	// signed with; an empty kid means a token issued before kid headers
+	VerificationKey(kid string) (*SigningKey, error)
+	// VerificationKeys returns every key tokens are currently accepted from
+	VerificationKeys() []*SigningKey
+}
+
+// EncryptionKeySource supplies the keys access tokens are encrypted with
+type EncryptionKeySource interface {
+	// EncryptionKey returns the key new access tokens are encrypted with, or nil
+	// if they are issued unencrypted
+	EncryptionKey() (*EncryptionKey, error)
+	// DecryptionKey returns the key a JWE with the given kid was encrypted with
+	DecryptionKey(kid string) (*EncryptionKey, error)
+}
+
+// KeyProvider supplies rotating keys for a purpose, falling back to the key
+// from configuration when it has none of its own
+type KeyProvider interface {
+	KeySource(purpose string, fallback KeySource) KeySource
+	EncryptionKeySource(fallback EncryptionKeySource) EncryptionKeySource
+}
+
+// StaticKeySource is a KeySource with a single key from configuration
+type StaticKeySource struct {
+	key *SigningKey
+	// missing describes the configuration needed when key is nil
+	missing string
+}
+
+func NewStaticKeySource(key *SigningKey, missing string) *StaticKeySource {
+	return &StaticKeySource{key: key, missing: missing}
+}
+
+func (s *StaticKeySource) SigningKey() (*SigningKey, error) {
+	if s.key == nil {
+		return nil, errors.New(s.missing)
+	}
+	return s.key, nil
+}
+
+func (s *StaticKeySource) VerificationKey(kid string) (*SigningKey, error) {
+	if s.key == nil {
+		return nil, errors.New(s.missing)
+	}
+	if kid != "" && kid != s.key.ID {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return s.key, nil
+}
+
+func (s *StaticKeySource) VerificationKeys() []*SigningKey {
+	if s.key == nil {
+		return nil
+	}
+	return []*SigningKey{s.key}
+}
+
+// StaticEncryptionKeySource is an EncryptionKeySource with the key from
+// configuration, if any
+type StaticEncryptionKeySource struct {
+	key *EncryptionKey
+}
+
+func NewStaticEncryptionKeySource(key *EncryptionKey) *StaticEncryptionKeySource {
+	return &StaticEncryptionKeySource{key: key}
+}
+
+func (s *StaticEncryptionKeySource) EncryptionKey() (*EncryptionKey, error) {
+	return s.key, nil
+}
+
+func (s *StaticEncryptionKeySource) DecryptionKey(kid string) (*EncryptionKey, error) {
+	if s.key == nil {
+		return nil, errors.New("access token encryption not configured")
+	}
+	if kid != "" && kid != s.key.ID {
+		return nil, fmt.Errorf("unknown encryption key %q", kid)
+	}
+	return s.key, nil
+}
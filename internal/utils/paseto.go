@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	pasetoVersion = "v4."
+	pasetoLocal   = "local"
+	pasetoPublic  = "public"
+)
+
+// pasetoTimeClaims are the registered claims PASETO encodes as RFC 3339
+// strings, where JWT uses seconds since the epoch
+var pasetoTimeClaims = []string{"exp", "nbf", "iat"}
+
+// pasetoFooter is the unencrypted but authenticated footer; it names the key so
+// it can be rotated like a JWT kid header
+type pasetoFooter struct {
+	KeyID string `json:"kid"`
+}
+
+// pasetoCodec implements PASETO v4: v4.local encrypts claims with XChaCha20 and
+// BLAKE2b-MAC under a secret key, v4.public signs them with Ed25519. The version
+// and purpose are fixed by the token header, so there is no algorithm to confuse.
+type pasetoCodec struct {
+	purpose string
+}
+
+func (c pasetoCodec) header() string {
+	return pasetoVersion + c.purpose + "."
+}
+
+func (c pasetoCodec) Handles(token string) bool {
+	return strings.HasPrefix(token, c.header())
+}
+
+func (c pasetoCodec) Encode(key *SigningKey, claims jwt.Claims) (string, error) {
+	payload, err := pasetoPayload(claims)
+	if err != nil {
+		return "", err
+	}
+
+	footer, err := json.Marshal(pasetoFooter{KeyID: key.ID})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token footer: %w", err)
+	}
+
+	var body []byte
+	if c.purpose == pasetoLocal {
+		secret, err := pasetoLocalKey(key)
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			return "", fmt.Errorf("failed to generate token nonce: %w", err)
+		}
+		body = pasetoEncrypt(secret, nonce, payload, footer)
+	} else {
+		privateKey, ok := key.signKey.(ed25519.PrivateKey)
+		if !ok {
+			return "", fmt.Errorf("%s needs an EdDSA key, got %s", TokenCodecPasetoPublic, key.Method.Alg())
+		}
+		signature := ed25519.Sign(privateKey, pasetoPAE([]byte(c.header()), payload, footer, nil))
+		body = append(payload, signature...)
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	return c.header() + encode(body) + "." + encode(footer), nil
+}
+
+func (c pasetoCodec) Decode(token string, keys KeySource, claims jwt.Claims) error {
+	if !c.Handles(token) {
+		return errors.New("not a " + strings.TrimSuffix(c.header(), ".") + " token")
+	}
+
+	encodedBody, encodedFooter, _ := strings.Cut(strings.TrimPrefix(token, c.header()), ".")
+	body, err := base64.RawURLEncoding.DecodeString(encodedBody)
+	if err != nil {
+		return fmt.Errorf("malformed token: %w", err)
+	}
+	footer, err := base64.RawURLEncoding.DecodeString(encodedFooter)
+	if err != nil {
+		return fmt.Errorf("malformed token footer: %w", err)
+	}
+
+	var kid pasetoFooter
+	if len(footer) > 0 {
+		if err := json.Unmarshal(footer, &kid); err != nil {
+			return fmt.Errorf("malformed token footer: %w", err)
+		}
+	}
+
+	key, err := keys.VerificationKey(kid.KeyID)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	if c.purpose == pasetoLocal {
+		secret, err := pasetoLocalKey(key)
+		if err != nil {
+			return err
+		}
+		payload, err = pasetoDecrypt(secret, body, footer)
+		if err != nil {
+			return err
+		}
+	} else {
+		publicKey, ok := key.verifyKey.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an EdDSA key, got %s", TokenCodecPasetoPublic, key.Method.Alg())
+		}
+		if len(body) < ed25519.SignatureSize {
+			return errors.New("malformed token")
+		}
+		split := len(body) - ed25519.SignatureSize
+		payload = body[:split]
+		if !ed25519.Verify(publicKey, pasetoPAE([]byte(c.header()), payload, footer, nil), body[split:]) {
+			return errors.New("invalid token signature")
+		}
+	}
+
+	return pasetoClaims(payload, claims)
+}
+
+// pasetoLocalKey derives the 256-bit v4.local key from an HS256 secret, which
+// may have any length
+func pasetoLocalKey(key *SigningKey) ([]byte, error) {
+	secret, ok := key.signKey.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%s needs an HS256 key, got %s", TokenCodecPasetoLocal, key.Method.Alg())
+	}
+	return hkdf.Key(sha256.New, secret, nil, "aegis-core paseto v4.local", 32)
+}
+
+// pasetoEncrypt returns n || c || t for v4.local with an empty implicit assertion
+func pasetoEncrypt(key, nonce, payload, footer []byte) []byte {
+	encryptionKey, counterNonce, authKey := pasetoSplitKey(key, nonce)
+
+	ciphertext := make([]byte, len(payload))
+	stream, _ := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	stream.XORKeyStream(ciphertext, payload)
+
+	tag := pasetoMAC(authKey, nonce, ciphertext, footer)
+
+	body := append([]byte{}, nonce...)
+	body = append(body, ciphertext...)
+	return append(body, tag...)
+}
+
+func pasetoDecrypt(key, body, footer []byte) ([]byte, error) {
+	if len(body) < 64 {
+		return nil, errors.New("malformed token")
+	}
+	nonce, ciphertext, tag := body[:32], body[32:len(body)-32], body[len(body)-32:]
+
+	encryptionKey, counterNonce, authKey := pasetoSplitKey(key, nonce)
+	if subtle.ConstantTimeCompare(tag, pasetoMAC(authKey, nonce, ciphertext, footer)) != 1 {
+		return nil, errors.New("invalid token authentication tag")
+	}
+
+	payload := make([]byte, len(ciphertext))
+	stream, _ := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	stream.XORKeyStream(payload, ciphertext)
+	return payload, nil
+}
+
+func pasetoSplitKey(key, nonce []byte) (encryptionKey, counterNonce, authKey []byte) {
+	derived := pasetoHash(key, 56, []byte("paseto-encryption-key"), nonce)
+	return derived[:32], derived[32:], pasetoHash(key, 32, []byte("paseto-auth-key-for-aead"), nonce)
+}
+
+func pasetoMAC(authKey, nonce, ciphertext, footer []byte) []byte {
+	header := []byte(pasetoVersion + pasetoLocal + ".")
+	return pasetoHash(authKey, 32, pasetoPAE(header, nonce, ciphertext, footer, nil))
+}
+
+// pasetoHash is keyed BLAKE2b over the concatenated parts
+func pasetoHash(key []byte, size int, parts ...[]byte) []byte {
+	hash, _ := blake2b.New(size, key)
+	for _, part := range parts {
+		hash.Write(part)
+	}
+	return hash.Sum(nil)
+}
+
+// pasetoPAE is PASETO's pre-authentication encoding: the number of pieces, then
+// each piece prefixed with its length, all as 64-bit little-endian integers
+func pasetoPAE(pieces ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(pieces))&(1<<63-1)))
+	for _, piece := range pieces {
+		buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(piece))&(1<<63-1)))
+		buf.Write(piece)
+	}
+	return buf.Bytes()
+}
+
+// pasetoPayload encodes claims as JSON with PASETO's RFC 3339 time claims
+func pasetoPayload(claims jwt.Claims) ([]byte, error) {
+	fields, err := claimFields(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range pasetoTimeClaims {
+		if seconds, ok := fields[name].(json.Number); ok {
+			value, err := seconds.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s claim: %w", name, err)
+			}
+			fields[name] = time.Unix(int64(value), 0).UTC().Format(time.RFC3339)
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// pasetoClaims decodes a PASETO payload into claims
+func pasetoClaims(payload []byte, claims jwt.Claims) error {
+	fields := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return fmt.Errorf("malformed token claims: %w", err)
+	}
+
+	for _, name := range pasetoTimeClaims {
+		if value, ok := fields[name].(string); ok {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fmt.Errorf("invalid %s claim: %w", name, err)
+			}
+			fields[name] = parsed.Unix()
+		}
+	}
+
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("malformed token claims: %w", err)
+	}
+	return json.Unmarshal(encoded, claims)
+}
+
+func claimFields(claims jwt.Claims) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode token claims: %w", err)
+	}
+
+	fields := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("failed to encode token claims: %w", err)
+	}
+	return fields, nil
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Vectors from paseto-standard/test-vectors v4.json. The file could not be
+// fetched when these were added, so they were written down from memory and
+// have only been checked against this implementation; compare them with
+// v4.json when it is next at hand.
+var pasetoV4LocalVectors = []struct {
+	name    string
+	key     string
+	nonce   string
+	payload string
+	token   string
+}{
+	{
+		name:    "4-E-1",
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+		payload: `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+	},
+	{
+		name:    "4-E-3",
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		payload: `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA",
+	},
+}
+
+var pasetoV4PublicVectors = []struct {
+	name      string
+	secretKey string
+	publicKey string
+	payload   string
+	token     string
+}{
+	{
+		name:      "4-S-1",
+		secretKey: "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2",
+		publicKey: "1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2",
+		payload:   `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+		token:     "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+	},
+}
+
+func mustHex(t *testing.T, value string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestPasetoV4LocalVectors(t *testing.T) {
+	for _, vector := range pasetoV4LocalVectors {
+		t.Run(vector.name, func(t *testing.T) {
+			key := mustHex(t, vector.key)
+
+			body := pasetoEncrypt(key, mustHex(t, vector.nonce), []byte(vector.payload), nil)
+			if got := "v4.local." + base64.RawURLEncoding.EncodeToString(body); got != vector.token {
+				t.Fatalf("encrypt = %s, want %s", got, vector.token)
+			}
+
+			body, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(vector.token, "v4.local."))
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := pasetoDecrypt(key, body, nil)
+			if err != nil {
+				t.Fatalf("decrypt error = %v", err)
+			}
+			if string(payload) != vector.payload {
+				t.Fatalf("decrypt = %s, want %s", payload, vector.payload)
+			}
+
+			body[len(body)-1] ^= 1
+			if _, err := pasetoDecrypt(key, body, nil); err == nil {
+				t.Fatal("decrypt accepted a tampered tag")
+			}
+		})
+	}
+}
+
+func TestPasetoV4PublicVectors(t *testing.T) {
+	for _, vector := range pasetoV4PublicVectors {
+		t.Run(vector.name, func(t *testing.T) {
+			secretKey := ed25519.PrivateKey(mustHex(t, vector.secretKey))
+			publicKey := ed25519.PublicKey(mustHex(t, vector.publicKey))
+			if !bytes.Equal(secretKey.Public().(ed25519.PublicKey), publicKey) {
+				t.Fatal("secret key does not match public key")
+			}
+
+			payload := []byte(vector.payload)
+			signature := ed25519.Sign(secretKey, pasetoPAE([]byte("v4.public."), payload, nil, nil))
+			if got := "v4.public." + base64.RawURLEncoding.EncodeToString(append(payload, signature...)); got != vector.token {
+				t.Fatalf("sign = %s, want %s", got, vector.token)
+			}
+
+			key := &SigningKey{Method: jwt.SigningMethodEdDSA, signKey: secretKey, verifyKey: publicKey}
+			var claims jwt.MapClaims
+			codec := pasetoCodec{purpose: pasetoPublic}
+			if err := codec.Decode(vector.token, NewStaticKeySource(key, "no key"), &claims); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if claims["data"] != "this is a signed message" {
+				t.Fatalf("data claim = %v", claims["data"])
+			}
+			exp, err := claims.GetExpirationTime()
+			if err != nil || !exp.Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Fatalf("exp claim = %v, %v", exp, err)
+			}
+		})
+	}
+}
+
+func TestPasetoCodecRoundTrip(t *testing.T) {
+	publicKey, secretKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuedAt := time.Now().Truncate(time.Second)
+	tests := []struct {
+		purpose string
+		key     *SigningKey
+		other   *SigningKey
+	}{
+		{pasetoLocal, NewHMACSigningKey([]byte("local-secret")), NewHMACSigningKey([]byte("other-secret"))},
+		{pasetoPublic, &SigningKey{ID: "ed", Method: jwt.SigningMethodEdDSA, signKey: secretKey, verifyKey: publicKey}, NewHMACSigningKey([]byte("wrong-type"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.purpose, func(t *testing.T) {
+			codec := pasetoCodec{purpose: test.purpose}
+			token, err := codec.Encode(test.key, jwt.RegisteredClaims{
+				Subject:   "user-1",
+				IssuedAt:  jwt.NewNumericDate(issuedAt),
+				ExpiresAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(token, "v4."+test.purpose+".") {
+				t.Fatalf("token %q has the wrong header", token)
+			}
+
+			var claims jwt.RegisteredClaims
+			if err := codec.Decode(token, NewStaticKeySource(test.key, "no key"), &claims); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if claims.Subject != "user-1" || !claims.ExpiresAt.Equal(issuedAt.Add(time.Hour)) || !claims.IssuedAt.Equal(issuedAt) {
+				t.Fatalf("Decode() claims = %+v", claims)
+			}
+
+			otherKeys := NewStaticKeySource(&SigningKey{ID: test.key.ID, Method: test.other.Method, signKey: test.other.signKey, verifyKey: test.other.verifyKey}, "no key")
+			if err := codec.Decode(token, otherKeys, &jwt.RegisteredClaims{}); err == nil {
+				t.Fatal("Decode() accepted the wrong key")
+			}
+
+			body, _, _ := strings.Cut(token[len("v4."+test.purpose+"."):], ".")
+			footer := base64.RawURLEncoding.EncodeToString([]byte(`{"kid":"` + test.key.ID + `","extra":1}`))
+			tampered := "v4." + test.purpose + "." + body + "." + footer
+			if err := codec.Decode(tampered, NewStaticKeySource(test.key, "no key"), &jwt.RegisteredClaims{}); err == nil {
+				t.Fatal("Decode() accepted a tampered footer")
+			}
+
+			if (pasetoCodec{purpose: pasetoLocal}).Handles(token) == (test.purpose == pasetoPublic) {
+				t.Fatal("Handles() confused v4.local and v4.public")
+			}
+		})
+	}
+}
@@ -1,19 +1,408 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 
-const bcryptCost = 12
+	"github.com/randhir/aegis-core/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
 
-func HashPassword(password string) (string, error) {
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+// Password hashing algorithms selectable with PASSWORD_HASH_ALGORITHM
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+	PasswordAlgorithmScrypt   = "scrypt"
+)
+
+const (
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+)
+
+// ErrUnknownPasswordHash is returned for a stored hash no hasher recognizes
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords in one encoded format. Encoded hashes carry
+// their salt and parameters, so they verify after the parameters change.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encodedHash, password string) (bool, error)
+	// Identifies reports whether encodedHash is in this hasher's format,
+	// judging by its prefix
+	Identifies(encodedHash string) bool
+	// NeedsRehash reports whether encodedHash uses weaker parameters than the
+	// hasher's own
+	NeedsRehash(encodedHash string) bool
+}
+
+// PasswordHashers hashes new passwords with the configured algorithm and
+// verifies hashes made by any supported one, so existing users keep logging in
+// after the algorithm or its parameters change
+type PasswordHashers struct {
+	current PasswordHasher
+	all     []PasswordHasher
+	// dummies[i] stands in for a hash made by all[i] for accounts that don't exist
+	dummies []func() (string, error)
+	// seen[i] counts stored hashes checked with all[i], the mix of algorithms
+	// that dummy checks follow
+	seen     []atomic.Int64
+	dummyKey []byte
+}
+
+func NewPasswordHashers(cfg config.PasswordConfig) (*PasswordHashers, error) {
+	if cfg.Argon2Time < 1 || cfg.Argon2MemoryKiB < 1 || cfg.Argon2Threads < 1 || cfg.Argon2Threads > 255 {
+		return nil, fmt.Errorf("invalid argon2id parameters: time=%d memory=%dKiB threads=%d", cfg.Argon2Time, cfg.Argon2MemoryKiB, cfg.Argon2Threads)
+	}
+	argon2id := &Argon2idHasher{Time: uint32(cfg.Argon2Time), MemoryKiB: uint32(cfg.Argon2MemoryKiB), Threads: uint8(cfg.Argon2Threads)}
+	bcryptHasher := &BcryptHasher{Cost: cfg.BcryptCost}
+	scryptHasher := &ScryptHasher{LogN: cfg.ScryptLogN, R: cfg.ScryptR, P: cfg.ScryptP}
+
+	if err := argon2id.validate(); err != nil {
+		return nil, err
+	}
+	if err := bcryptHasher.validate(); err != nil {
+		return nil, err
+	}
+	if err := scryptHasher.validate(); err != nil {
+		return nil, err
+	}
+
+	var current PasswordHasher
+	switch cfg.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		current = argon2id
+	case PasswordAlgorithmBcrypt:
+		current = bcryptHasher
+	case PasswordAlgorithmScrypt:
+		current = scryptHasher
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q (expected argon2id, bcrypt or scrypt)", cfg.Algorithm)
+	}
+
+	dummyKey := make([]byte, 32)
+	if _, err := rand.Read(dummyKey); err != nil {
+		return nil, fmt.Errorf("failed to generate dummy password key: %w", err)
+	}
+
+	all := []PasswordHasher{argon2id, bcryptHasher, scryptHasher}
+	dummies := make([]func() (string, error), len(all))
+	for i, hasher := range all {
+		dummies[i] = sync.OnceValues(func() (string, error) {
+			return hasher.Hash("aegis-core-dummy-password")
+		})
+	}
+
+	return &PasswordHashers{
+		current:  current,
+		all:      all,
+		dummies:  dummies,
+		seen:     make([]atomic.Int64, len(all)),
+		dummyKey: dummyKey,
+	}, nil
+}
+
+// Hash hashes a password for storage with the configured algorithm
+func (h *PasswordHashers) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Verify checks password against a stored hash. rehash is true when the hash
+// matched but was made with another algorithm or weaker parameters, and should
+// be replaced with a fresh one.
+func (h *PasswordHashers) Verify(encodedHash, password string) (match, rehash bool, err error) {
+	for i, hasher := range h.all {
+		if !hasher.Identifies(encodedHash) {
+			continue
+		}
+
+		h.seen[i].Add(1)
+		match, err = hasher.Verify(encodedHash, password)
+		if err != nil || !match {
+			return false, false, err
+		}
+		return true, hasher != h.current || hasher.NeedsRehash(encodedHash), nil
+	}
+
+	return false, false, ErrUnknownPasswordHash
+}
+
+// VerifyDummy does the work of Verify without a real hash, so a login for an
+// unknown email takes as long as one with a wrong password. Accounts whose
+// hashes predate the current algorithm verify at that algorithm's speed, so
+// the dummy is checked with an algorithm drawn from the mix of stored hashes
+// seen so far; the draw is keyed on subject, so retrying one unknown email
+// consistently looks like one account.
+func (h *PasswordHashers) VerifyDummy(subject, password string) error {
+	i := h.dummyHasher(subject)
+	dummyHash, err := h.dummies[i]()
+	if err != nil {
+		return fmt.Errorf("failed to hash dummy password: %w", err)
+	}
+	_, err = h.all[i].Verify(dummyHash, password)
+	return err
+}
+
+// dummyHasher picks the index of the hasher that dummy checks for subject use,
+// weighted by how many stored hashes each has checked
+func (h *PasswordHashers) dummyHasher(subject string) int {
+	counts := make([]uint64, len(h.seen))
+	var total uint64
+	for i := range h.seen {
+		counts[i] = uint64(h.seen[i].Load())
+		total += counts[i]
+	}
+	if total == 0 {
+		return slices.Index(h.all, h.current)
+	}
+
+	mac := hmac.New(sha256.New, h.dummyKey)
+	mac.Write([]byte(subject))
+	point := binary.BigEndian.Uint64(mac.Sum(nil)) % total
+	for i, count := range counts {
+		if point < count {
+			return i
+		}
+		point -= count
+	}
+	return len(counts) - 1
+}
+
+// Argon2idHasher encodes hashes in the PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<hash>
+type Argon2idHasher struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := passwordSalt()
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.MemoryKiB, a.Threads, passwordKeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.MemoryKiB, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2idHasher) Verify(encodedHash, password string) (bool, error) {
+	params, salt, key, err := parseArgon2id(encodedHash)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Time, params.MemoryKiB, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+func (a *Argon2idHasher) Identifies(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$argon2id$")
+}
+
+func (a *Argon2idHasher) NeedsRehash(encodedHash string) bool {
+	params, _, key, err := parseArgon2id(encodedHash)
+	if err != nil {
+		return true
+	}
+	return params.MemoryKiB < a.MemoryKiB || params.Time < a.Time || len(key) < passwordKeyLength
+}
+
+func (a *Argon2idHasher) validate() error {
+	if a.Time < 1 || a.MemoryKiB < 8*uint32(a.Threads) || a.Threads < 1 {
+		return fmt.Errorf("invalid argon2id parameters: time=%d memory=%dKiB threads=%d", a.Time, a.MemoryKiB, a.Threads)
+	}
+	return nil
+}
+
+func parseArgon2id(encodedHash string) (params *Argon2idHasher, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	params = &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.MemoryKiB, &params.Time, &params.Threads); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse argon2id parameters: %w", err)
+	}
+	if err := params.validate(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt, key, err = decodeSaltAndKey(parts[4], parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+// BcryptHasher produces the standard $2a$ modular crypt format
+type BcryptHasher struct {
+	Cost int
+}
+
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
 	if err != nil {
 		return "", err
 	}
 	return string(hashedBytes), nil
 }
 
-func ComparePassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+func (b *BcryptHasher) Verify(encodedHash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *BcryptHasher) Identifies(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") ||
+		strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
+func (b *BcryptHasher) NeedsRehash(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	return err != nil || cost < b.Cost
+}
+
+func (b *BcryptHasher) validate() error {
+	if b.Cost < bcrypt.MinCost || b.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid bcrypt cost %d (expected %d-%d)", b.Cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+// ScryptHasher encodes hashes as $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>
+type ScryptHasher struct {
+	LogN int
+	R    int
+	P    int
+}
+
+func (s *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := passwordSalt()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<s.LogN, s.R, s.P, passwordKeyLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s",
+		s.LogN, s.R, s.P,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (s *ScryptHasher) Verify(encodedHash, password string) (bool, error) {
+	params, salt, key, err := parseScrypt(encodedHash)
+	if err != nil {
+		return false, err
+	}
+
+	candidate, err := scrypt.Key([]byte(password), salt, 1<<params.LogN, params.R, params.P, len(key))
+	if err != nil {
+		return false, fmt.Errorf("failed to hash password: %w", err)
+	}
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+func (s *ScryptHasher) Identifies(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$scrypt$")
+}
+
+func (s *ScryptHasher) NeedsRehash(encodedHash string) bool {
+	params, _, key, err := parseScrypt(encodedHash)
+	if err != nil {
+		return true
+	}
+	return params.LogN < s.LogN || params.R < s.R || params.P < s.P || len(key) < passwordKeyLength
+}
+
+func (s *ScryptHasher) validate() error {
+	if s.LogN < 1 || s.LogN > 30 || s.R < 1 || s.P < 1 || s.R*s.P >= 1<<30 {
+		return fmt.Errorf("invalid scrypt parameters: ln=%d r=%d p=%d", s.LogN, s.R, s.P)
+	}
+	return nil
+}
+
+func parseScrypt(encodedHash string) (params *ScryptHasher, salt, key []byte, err error) {
+	// "", "scrypt", "ln=...,r=...,p=...", salt, hash
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	params = &ScryptHasher{}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.LogN, &params.R, &params.P); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse scrypt parameters: %w", err)
+	}
+	if err := params.validate(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt, key, err = decodeSaltAndKey(parts[3], parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+func passwordSalt() ([]byte, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+func decodeSaltAndKey(encodedSalt, encodedKey string) (salt, key []byte, err error) {
+	salt, err = base64.RawStdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode password salt: %w", err)
+	}
+	key, err = base64.RawStdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode password hash: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, nil, errors.New("password hash is empty")
+	}
+	return salt, key, nil
 }
 
+var (
+	_ PasswordHasher = (*Argon2idHasher)(nil)
+	_ PasswordHasher = (*BcryptHasher)(nil)
+	_ PasswordHasher = (*ScryptHasher)(nil)
+)
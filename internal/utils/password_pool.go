@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"expvar"
+	"runtime"
+	"time"
+)
+
+// PasswordPool runs password hashing on a fixed number of workers, so a burst
+// of logins or registrations can't take every core away from token checks and
+// health probes. Work waits in a bounded queue; when the queue is full the
+// request is refused with ErrServerBusy instead of piling up.
+type PasswordPool struct {
+	hashers *PasswordHashers
+	jobs    chan passwordJob
+
+	metrics     *expvar.Map
+	inFlight    expvar.Int
+	completed   expvar.Int
+	rejected    expvar.Int
+	canceled    expvar.Int
+	waitSeconds expvar.Float
+	hashSeconds expvar.Float
+}
+
+type passwordJob struct {
+	ctx      context.Context
+	queuedAt time.Time
+	run      func()
+	done     chan struct{}
+}
+
+// NewPasswordPool starts workers goroutines sharing a queue of queueSize jobs.
+// Zero workers means half the available CPUs, leaving the rest for other traffic.
+func NewPasswordPool(hashers *PasswordHashers, workers, queueSize int) *PasswordPool {
+	if workers <= 0 {
+		workers = max(runtime.GOMAXPROCS(0)/2, 1)
+	}
+	queueSize = max(queueSize, 0)
+
+	p := &PasswordPool{
+		hashers: hashers,
+		jobs:    make(chan passwordJob, queueSize),
+	}
+
+	workerCount := new(expvar.Int)
+	workerCount.Set(int64(workers))
+	queueCapacity := new(expvar.Int)
+	queueCapacity.Set(int64(queueSize))
+
+	p.metrics = new(expvar.Map).Init()
+	p.metrics.Set("workers", workerCount)
+	p.metrics.Set("queue_capacity", queueCapacity)
+	p.metrics.Set("queue_depth", expvar.Func(func() any { return len(p.jobs) }))
+	p.metrics.Set("in_flight", &p.inFlight)
+	p.metrics.Set("completed_total", &p.completed)
+	p.metrics.Set("rejected_total", &p.rejected)
+	p.metrics.Set("canceled_total", &p.canceled)
+	p.metrics.Set("queue_wait_seconds_total", &p.waitSeconds)
+	p.metrics.Set("hash_seconds_total", &p.hashSeconds)
+
+	for range workers {
+		go p.work()
+	}
+
+	return p
+}
+
+// Hash hashes a password for storage
+func (p *PasswordPool) Hash(ctx context.Context, password string) (string, error) {
+	var hash string
+	var hashErr error
+	if err := p.do(ctx, func() { hash, hashErr = p.hashers.Hash(password) }); err != nil {
+		return "", err
+	}
+	return hash, hashErr
+}
+
+// Verify checks password against a stored hash; see PasswordHashers.Verify
+func (p *PasswordPool) Verify(ctx context.Context, encodedHash, password string) (bool, bool, error) {
+	var match, rehash bool
+	var verifyErr error
+	if err := p.do(ctx, func() { match, rehash, verifyErr = p.hashers.Verify(encodedHash, password) }); err != nil {
+		return false, false, err
+	}
+	return match, rehash, verifyErr
+}
+
+// VerifyDummy is PasswordHashers.VerifyDummy run on the pool
+func (p *PasswordPool) VerifyDummy(ctx context.Context, subject, password string) error {
+	var verifyErr error
+	if err := p.do(ctx, func() { verifyErr = p.hashers.VerifyDummy(subject, password) }); err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+// Metrics returns the pool's queue depth, throughput and latency counters.
+// Latencies are running totals in seconds; divide by completed_total for the
+// mean.
+func (p *PasswordPool) Metrics() *expvar.Map {
+	return p.metrics
+}
+
+// do queues run and waits for a worker to finish it. A caller whose context
+// ends stops waiting, and its job is dropped if no worker has started it yet.
+func (p *PasswordPool) do(ctx context.Context, run func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	job := passwordJob{
+		ctx:      ctx,
+		queuedAt: time.Now(),
+		run:      run,
+		done:     make(chan struct{}),
+	}
+
+	select {
+	case p.jobs <- job:
+	default:
+		p.rejected.Add(1)
+		return ErrServerBusy
+	}
+
+	select {
+	case <-job.done:
+		return nil
+	case <-ctx.Done():
+		p.canceled.Add(1)
+		return ctx.Err()
+	}
+}
+
+func (p *PasswordPool) work() {
+	for job := range p.jobs {
+		if job.ctx.Err() != nil {
+			continue
+		}
+
+		p.waitSeconds.Add(time.Since(job.queuedAt).Seconds())
+		p.inFlight.Add(1)
+		start := time.Now()
+		job.run()
+		p.hashSeconds.Add(time.Since(start).Seconds())
+		p.inFlight.Add(-1)
+		p.completed.Add(1)
+		close(job.done)
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestPasswordPool(t *testing.T, workers, queueSize int) *PasswordPool {
+	t.Helper()
+	return NewPasswordPool(newTestPasswordHashers(t, testPasswordConfig(PasswordAlgorithmBcrypt)), workers, queueSize)
+}
+
+// Callers that give up must not share result variables with the worker still
+// running their job; run with -race
+func TestPasswordPoolVerifyCanceledMidHash(t *testing.T) {
+	pool := newTestPasswordPool(t, 1, 16)
+	hash, err := pool.Hash(context.Background(), "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 50 {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Microsecond)
+		match, rehash, err := pool.Verify(ctx, hash, "correct horse battery staple")
+		cancel()
+		if err != nil && (match || rehash) {
+			t.Fatalf("Verify() = %v, %v with error %v, want no result", match, rehash, err)
+		}
+	}
+
+	match, _, err := pool.Verify(context.Background(), hash, "correct horse battery staple")
+	if err != nil || !match {
+		t.Fatalf("Verify() = %v, %v, want a match", match, err)
+	}
+}
+
+func TestPasswordPoolRejectsWhenQueueFull(t *testing.T) {
+	pool := newTestPasswordPool(t, 1, 0)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		// The worker may not be waiting for jobs yet
+		for errors.Is(pool.do(context.Background(), func() {
+			close(started)
+			<-release
+		}), ErrServerBusy) {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	<-started
+	defer close(release)
+
+	if _, err := pool.Hash(context.Background(), "password"); !errors.Is(err, ErrServerBusy) {
+		t.Fatalf("Hash() with a busy pool error = %v, want ErrServerBusy", err)
+	}
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/randhir/aegis-core/internal/config"
+)
+
+// testPasswordConfig uses the cheapest valid parameters of every algorithm
+func testPasswordConfig(algorithm string) config.PasswordConfig {
+	return config.PasswordConfig{
+		Algorithm:       algorithm,
+		Argon2Time:      1,
+		Argon2MemoryKiB: 64,
+		Argon2Threads:   1,
+		BcryptCost:      4,
+		ScryptLogN:      4,
+		ScryptR:         8,
+		ScryptP:         1,
+	}
+}
+
+func newTestPasswordHashers(t *testing.T, cfg config.PasswordConfig) *PasswordHashers {
+	t.Helper()
+	hashers, err := NewPasswordHashers(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashers
+}
+
+func TestPasswordHashersEncodeAndVerify(t *testing.T) {
+	prefixes := map[string]string{
+		PasswordAlgorithmArgon2id: "$argon2id$v=19$m=64,t=1,p=1$",
+		PasswordAlgorithmBcrypt:   "$2a$04$",
+		PasswordAlgorithmScrypt:   "$scrypt$",
+	}
+
+	for algorithm, prefix := range prefixes {
+		t.Run(algorithm, func(t *testing.T) {
+			hashers := newTestPasswordHashers(t, testPasswordConfig(algorithm))
+
+			hash, err := hashers.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(hash, prefix) {
+				t.Fatalf("Hash() = %q, want prefix %q", hash, prefix)
+			}
+
+			other, err := hashers.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other == hash {
+				t.Fatal("Hash() reused a salt")
+			}
+
+			match, rehash, err := hashers.Verify(hash, "correct horse battery staple")
+			if err != nil || !match || rehash {
+				t.Fatalf("Verify(correct) = %v, %v, %v, want match without rehash", match, rehash, err)
+			}
+			match, rehash, err = hashers.Verify(hash, "Correct horse battery staple")
+			if err != nil || match || rehash {
+				t.Fatalf("Verify(wrong) = %v, %v, %v, want no match", match, rehash, err)
+			}
+		})
+	}
+}
+
+func TestPasswordHashersRehashDecision(t *testing.T) {
+	weaker := testPasswordConfig(PasswordAlgorithmArgon2id)
+	stronger := weaker
+	stronger.Argon2Time = 2
+	stronger.BcryptCost = 5
+	stronger.ScryptLogN = 5
+
+	for _, algorithm := range []string{PasswordAlgorithmArgon2id, PasswordAlgorithmBcrypt, PasswordAlgorithmScrypt} {
+		weakCfg := weaker
+		weakCfg.Algorithm = algorithm
+		hash, err := newTestPasswordHashers(t, weakCfg).Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, current := range []string{PasswordAlgorithmArgon2id, PasswordAlgorithmBcrypt, PasswordAlgorithmScrypt} {
+			for _, cfg := range []config.PasswordConfig{weaker, stronger} {
+				cfg.Algorithm = current
+				wantRehash := current != algorithm || cfg.Argon2Time == stronger.Argon2Time
+
+				t.Run(fmt.Sprintf("%s hash, current %s, stronger %v", algorithm, current, cfg.Argon2Time == stronger.Argon2Time), func(t *testing.T) {
+					match, rehash, err := newTestPasswordHashers(t, cfg).Verify(hash, "password")
+					if err != nil || !match {
+						t.Fatalf("Verify() = %v, %v, want a match", match, err)
+					}
+					if rehash != wantRehash {
+						t.Fatalf("Verify() rehash = %v, want %v", rehash, wantRehash)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestPasswordHashersRejectUnknownFormat(t *testing.T) {
+	hashers := newTestPasswordHashers(t, testPasswordConfig(PasswordAlgorithmArgon2id))
+
+	for _, hash := range []string{"", "plaintext", "$pbkdf2-sha256$29000$abc$def", "$argon2id$v=19$m=64"} {
+		match, _, err := hashers.Verify(hash, "plaintext")
+		if match || err == nil {
+			t.Fatalf("Verify(%q) = %v, %v, want an error", hash, match, err)
+		}
+	}
+
+	if _, _, err := hashers.Verify("$md5$abc", "password"); !errors.Is(err, ErrUnknownPasswordHash) {
+		t.Fatalf("Verify() error = %v, want ErrUnknownPasswordHash", err)
+	}
+}
+
+func TestVerifyDummyFollowsStoredHashes(t *testing.T) {
+	hashers := newTestPasswordHashers(t, testPasswordConfig(PasswordAlgorithmArgon2id))
+	argon2idIndex, bcryptIndex := 0, 1
+
+	// Nothing seen yet: the dummy uses the current algorithm
+	if got := hashers.dummyHasher("nobody@example.com"); got != argon2idIndex {
+		t.Fatalf("dummyHasher() before any login = %d, want argon2id", got)
+	}
+	if err := hashers.VerifyDummy("nobody@example.com", "password"); err != nil {
+		t.Fatalf("VerifyDummy() error = %v", err)
+	}
+
+	bcryptCfg := testPasswordConfig(PasswordAlgorithmBcrypt)
+	legacyHash, err := newTestPasswordHashers(t, bcryptCfg).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every stored hash so far is bcrypt, so every dummy is too
+	if _, _, err := hashers.Verify(legacyHash, "wrong"); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		if got := hashers.dummyHasher(fmt.Sprintf("nobody%d@example.com", i)); got != bcryptIndex {
+			t.Fatalf("dummyHasher() with only bcrypt hashes seen = %d, want bcrypt", got)
+		}
+	}
+	if err := hashers.VerifyDummy("nobody@example.com", "password"); err != nil {
+		t.Fatalf("VerifyDummy() error = %v", err)
+	}
+
+	// With a mix, each subject keeps its draw and both algorithms are drawn
+	currentHash, err := hashers.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := hashers.Verify(currentHash, "wrong"); err != nil {
+		t.Fatal(err)
+	}
+	drawn := make(map[int]bool)
+	for i := range 64 {
+		subject := fmt.Sprintf("nobody%d@example.com", i)
+		first := hashers.dummyHasher(subject)
+		if again := hashers.dummyHasher(subject); again != first {
+			t.Fatalf("dummyHasher(%q) = %d then %d", subject, first, again)
+		}
+		drawn[first] = true
+	}
+	if !drawn[argon2idIndex] || !drawn[bcryptIndex] || len(drawn) != 2 {
+		t.Fatalf("dummyHasher() drew %v from an even argon2id/bcrypt mix", drawn)
+	}
+}
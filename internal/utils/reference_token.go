@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Access token formats
+const (
+	AccessTokenFormatJWT    = "jwt"
+	AccessTokenFormatOpaque = "opaque"
+)
+
+// referenceTokenPrefix marks opaque access tokens, which never contain the dots
+// that separate the parts of a JWT
+const referenceTokenPrefix = "aat_"
+
+// NewReferenceToken returns a random opaque access token
+func NewReferenceToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate reference token: %w", err)
+	}
+	return referenceTokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// IsReferenceToken reports whether token is an opaque access token rather than a JWT
+func IsReferenceToken(token string) bool {
+	return strings.HasPrefix(token, referenceTokenPrefix)
+}
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported access token signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// SigningKey signs and verifies tokens with a single algorithm and key. ID is
+// stamped into the kid header of every token it signs.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// JWK is the public half of a signing key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewHMACSigningKey returns an HS256 key; it has no public form
+func NewHMACSigningKey(secret []byte) *SigningKey {
+	key := &SigningKey{Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+	key.ID = key.thumbprint()
+	return key
+}
+
+// NewSigningKey builds a key with the given ID from raw key material: the secret
+// itself for HS256, or a PEM-encoded private key for asymmetric algorithms
+func NewSigningKey(id, algorithm string, material []byte) (*SigningKey, error) {
+	var key *SigningKey
+	if algorithm == AlgorithmHS256 {
+		key = NewHMACSigningKey(material)
+	} else {
+		var err error
+		key, err = ParseSigningKey(algorithm, material)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key.ID = id
+	return key, nil
+}
+
+// LoadSigningKey reads a PEM-encoded private key for an asymmetric algorithm
+func LoadSigningKey(algorithm, pemFile string) (*SigningKey, error) {
+	if pemFile == "" {
+		return nil, fmt.Errorf("a private key file is required for %s", algorithm)
+	}
+
+	pemData, err := os.ReadFile(pemFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	return ParseSigningKey(algorithm, pemData)
+}
+
+// ParseSigningKey parses a PEM-encoded private key for an asymmetric algorithm.
+// Its ID is the key's RFC 7638 thumbprint.
+func ParseSigningKey(algorithm string, pemData []byte) (*SigningKey, error) {
+	key, err := parsePrivateKey(algorithm, pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = key.thumbprint()
+	return key, nil
+}
+
+func parsePrivateKey(algorithm string, pemData []byte) (*SigningKey, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA signing key: %w", err)
+		}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA signing key must be at least 2048 bits, got %d", key.N.BitLen())
+		}
+		return &SigningKey{Method: jwt.SigningMethodRS256, signKey: key, verifyKey: &key.PublicKey}, nil
+
+	case AlgorithmES256:
+		key, err := jwt.ParseECPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ECDSA signing key: %w", err)
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 requires a P-256 key, got %s", key.Curve.Params().Name)
+		}
+		return &SigningKey{Method: jwt.SigningMethodES256, signKey: key, verifyKey: &key.PublicKey}, nil
+
+	case AlgorithmEdDSA:
+		key, err := jwt.ParseEdPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Ed25519 signing key: %w", err)
+		}
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unexpected Ed25519 key type %T", key)
+		}
+		return &SigningKey{Method: jwt.SigningMethodEdDSA, signKey: privateKey, verifyKey: privateKey.Public()}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// Sign signs the claims with this key
+func (k *SigningKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.Method, claims)
+	token.Header["kid"] = k.ID
+	return token.SignedString(k.signKey)
+}
+
+// Keyfunc verifies only tokens signed with exactly this key's algorithm, which
+// rules out algorithm confusion such as an RS256 public key used as an HS256 secret
+func (k *SigningKey) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+	return k.verifyKey, nil
+}
+
+// PublicJWK returns the public key as a JWK; symmetric keys have none
+func (k *SigningKey) PublicJWK() (JWK, bool) {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch key := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: k.Method.Alg(),
+			Kid: k.ID,
+			N:   encode(key.N.Bytes()),
+			E:   encode(big.NewInt(int64(key.E)).Bytes()),
+		}, true
+
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Use: "sig",
+			Alg: k.Method.Alg(),
+			Kid: k.ID,
+			Crv: key.Curve.Params().Name,
+			X:   encode(key.X.FillBytes(make([]byte, size))),
+			Y:   encode(key.Y.FillBytes(make([]byte, size))),
+		}, true
+
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Use: "sig",
+			Alg: k.Method.Alg(),
+			Kid: k.ID,
+			Crv: "Ed25519",
+			X:   encode(key),
+		}, true
+
+	default:
+		return JWK{}, false
+	}
+}
+
+// thumbprint derives a stable key ID: the RFC 7638 JWK thumbprint for public keys,
+// or a hash of the secret for HMAC keys, which reveals nothing about the secret
+func (k *SigningKey) thumbprint() string {
+	var members string
+	if jwk, ok := k.PublicJWK(); ok {
+		switch jwk.Kty {
+		case "RSA":
+			members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+		case "EC":
+			members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+		case "OKP":
+			members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+		}
+	} else if secret, ok := k.verifyKey.([]byte); ok {
+		members = "hmac:" + string(secret)
+	}
+
+	digest := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
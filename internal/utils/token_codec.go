@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported token codecs
+const (
+	TokenCodecJWT          = "jwt"
+	TokenCodecPasetoLocal  = "paseto-v4-local"
+	TokenCodecPasetoPublic = "paseto-v4-public"
+)
+
+// TokenCodec turns claims into a signed or encrypted token and back. Decode only
+// establishes that a token is authentic; JWTManager validates the claims, so
+// every codec enforces the same expiry, issuer and audience rules.
+type TokenCodec interface {
+	// Handles reports whether token is in this codec's format
+	Handles(token string) bool
+	// Encode protects claims with key, recording the key's ID in the token
+	Encode(key *SigningKey, claims jwt.Claims) (string, error)
+	// Decode verifies token with the key it names and decodes it into claims
+	Decode(token string, keys KeySource, claims jwt.Claims) error
+}
+
+// NewTokenCodec returns the codec with the given name
+func NewTokenCodec(name string) (TokenCodec, error) {
+	switch name {
+	case "", TokenCodecJWT:
+		return jwtCodec{}, nil
+	case TokenCodecPasetoLocal:
+		return pasetoCodec{purpose: pasetoLocal}, nil
+	case TokenCodecPasetoPublic:
+		return pasetoCodec{purpose: pasetoPublic}, nil
+	default:
+		return nil, fmt.Errorf("unsupported token codec %q", name)
+	}
+}
+
+// checkCodecKey rejects a configured key the codec can't use
+func checkCodecKey(codec TokenCodec, key *SigningKey) error {
+	if key == nil {
+		return nil
+	}
+	return checkCodecAlgorithm(codec, key.Method.Alg())
+}
+
+func checkCodecAlgorithm(codec TokenCodec, algorithm string) error {
+	paseto, ok := codec.(pasetoCodec)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case paseto.purpose == pasetoLocal && algorithm != AlgorithmHS256:
+		return fmt.Errorf("%s needs an HS256 key, got %s", TokenCodecPasetoLocal, algorithm)
+	case paseto.purpose == pasetoPublic && algorithm != AlgorithmEdDSA:
+		return fmt.Errorf("%s needs an EdDSA key, got %s", TokenCodecPasetoPublic, algorithm)
+	}
+	return nil
+}
+
+// CheckSigningAlgorithm rejects a signing key algorithm that can't issue
+// tokens of purpose in the named codec. Under paseto-v4-public, refresh tokens
+// still use v4.local.
+func CheckSigningAlgorithm(codecName, purpose, algorithm string) error {
+	codec, err := NewTokenCodec(codecName)
+	if err != nil {
+		return err
+	}
+	if codecName == TokenCodecPasetoPublic && purpose == KeyPurposeRefresh {
+		codec = pasetoCodec{purpose: pasetoLocal}
+	}
+	return checkCodecAlgorithm(codec, algorithm)
+}
+
+// jwtCodec encodes tokens as JWS compact serialization with golang-jwt
+type jwtCodec struct{}
+
+func (jwtCodec) Handles(token string) bool {
+	return !strings.HasPrefix(token, pasetoVersion)
+}
+
+func (jwtCodec) Encode(key *SigningKey, claims jwt.Claims) (string, error) {
+	return key.Sign(claims)
+}
+
+func (jwtCodec) Decode(token string, keys KeySource, claims jwt.Claims) error {
+	parsed, err := jwt.ParseWithClaims(token, claims, keyfunc(keys), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return err
+	}
+	if !parsed.Valid {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+// keyfunc picks the verification key by the token's kid header
+func keyfunc(keys KeySource) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		return key.Keyfunc(token)
+	}
+}
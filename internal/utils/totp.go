@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238): the defaults every authenticator app supports
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew accepts codes from one step either side of now, for clock drift
+	// and codes typed just as they roll over
+	totpSkew = 1
+)
+
+// RecoveryCodeCount is how many recovery codes are issued at once
+const RecoveryCodeCount = 10
+
+// mfaChallengePrefix marks the token a login receives while its second factor is pending
+const mfaChallengePrefix = "mfa_"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160-bit secret in base32, as entered into
+// authenticator apps
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// NewMFAChallengeToken returns a random token identifying a pending MFA login
+func NewMFAChallengeToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate mfa challenge token: %w", err)
+	}
+	return mfaChallengePrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI authenticator apps scan as a QR code
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// ValidateTOTP checks code against secret at now and returns the time step it
+// matched. Callers must reject steps at or before the last one accepted so a
+// code can't be used twice.
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode is the HOTP value (RFC 4226) for step
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// GenerateRecoveryCodes returns RecoveryCodeCount random codes formatted as
+// xxxxx-xxxxx
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode accepts codes with any case, spacing or dashes
+func NormalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// MFASecrets seals TOTP secrets at rest and hashes recovery codes. Both keys
+// are derived from a single configured key.
+type MFASecrets struct {
+	aead    cipher.AEAD
+	hashKey []byte
+}
+
+// NewMFASecrets takes the base64-encoded 32-byte MFA_ENCRYPTION_KEY
+func NewMFASecrets(encodedKey string) (*MFASecrets, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MFA encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("MFA encryption key must be 32 bytes, got %d", len(key))
+	}
+
+	sealKey, err := hkdf.Key(sha256.New, key, nil, "aegis-core mfa secrets", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive MFA secret key: %w", err)
+	}
+	hashKey, err := hkdf.Key(sha256.New, key, nil, "aegis-core mfa recovery codes", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive MFA recovery code key: %w", err)
+	}
+
+	block, err := aes.NewCipher(sealKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MFA cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MFA cipher: %w", err)
+	}
+
+	return &MFASecrets{aead: aead, hashKey: hashKey}, nil
+}
+
+// Seal encrypts a TOTP secret, bound to the user it belongs to
+func (m *MFASecrets) Seal(userID, secret string) ([]byte, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return m.aead.Seal(nonce, nonce, []byte(secret), []byte(userID)), nil
+}
+
+// Open decrypts a TOTP secret sealed for userID
+func (m *MFASecrets) Open(userID string, sealed []byte) (string, error) {
+	nonceSize := m.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("sealed TOTP secret is truncated")
+	}
+
+	secret, err := m.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(userID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// HashRecoveryCode returns the keyed hash a normalized recovery code is stored as
+func (m *MFASecrets) HashRecoveryCode(code string) string {
+	mac := hmac.New(sha256.New, m.hashKey)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
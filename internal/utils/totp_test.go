@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA-1. The RFC lists 8-digit codes; 6-digit codes are
+// their last six digits.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, vector := range rfc6238Vectors {
+		if got := totpCode(key, vector.unix/30); got != vector.code {
+			t.Errorf("T=%d: got %s, want %s", vector.unix, got, vector.code)
+		}
+	}
+}
+
+func TestValidateTOTPRFC6238(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, vector := range rfc6238Vectors {
+		step, ok := ValidateTOTP(secret, vector.code, time.Unix(vector.unix, 0))
+		if !ok || step != vector.unix/30 {
+			t.Errorf("T=%d: step=%d ok=%v, want step %d", vector.unix, step, ok, vector.unix/30)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	if _, ok := ValidateTOTP(secret, "050471", now.Add(totpPeriod)); !ok {
+		t.Error("code from the previous step was rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "050471", now.Add(3*totpPeriod)); ok {
+		t.Error("code from three steps ago was accepted")
+	}
+	if _, ok := ValidateTOTP(secret, "05047", now); ok {
+		t.Error("short code was accepted")
+	}
+}
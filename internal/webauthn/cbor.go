@@ -0,0 +1,167 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth bounds nesting so hostile input can't exhaust the stack
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the first CBOR data item (RFC 8949) in data and returns
+// it along with the bytes that follow it. It covers what authenticators emit:
+// definite-length integers, byte and text strings, arrays, maps, tags and simple
+// values. Integers decode as int64, byte strings as []byte, text as string,
+// arrays as []interface{} and maps as map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: nesting too deep")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	// Simple values and floats carry their payload in the argument itself
+	if major == 7 {
+		return decodeCBORSimple(data, info)
+	}
+
+	argument, rest, err := decodeCBORArgument(data, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		return int64(argument), rest, nil
+
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		return -1 - int64(argument), rest, nil
+
+	case 2, 3:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		value := rest[:argument]
+		if major == 3 {
+			return string(value), rest[argument:], nil
+		}
+		return append([]byte(nil), value...), rest[argument:], nil
+
+	case 4:
+		// Every item takes at least one byte
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+
+	case 5:
+		if argument > uint64(len(rest))/2 {
+			return nil, nil, errCBORTruncated
+		}
+		entries := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+			if _, exists := entries[key]; exists {
+				return nil, nil, fmt.Errorf("cbor: duplicate map key %v", key)
+			}
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, rest, nil
+
+	default:
+		// Tags only annotate the item that follows
+		return decodeCBORItem(rest, depth+1)
+	}
+}
+
+// decodeCBORArgument reads the argument of a definite-length item
+func decodeCBORArgument(data []byte, info byte) (uint64, []byte, error) {
+	rest := data[1:]
+	switch {
+	case info < 24:
+		return uint64(info), rest, nil
+	case info == 24:
+		if len(rest) < 1 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(rest[0]), rest[1:], nil
+	case info == 25:
+		if len(rest) < 2 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(rest)), rest[2:], nil
+	case info == 26:
+		if len(rest) < 4 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(rest)), rest[4:], nil
+	case info == 27:
+		if len(rest) < 8 {
+			return 0, nil, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(rest), rest[8:], nil
+	default:
+		return 0, nil, errors.New("cbor: indefinite-length items are not supported")
+	}
+}
+
+func decodeCBORSimple(data []byte, info byte) (interface{}, []byte, error) {
+	rest := data[1:]
+	switch info {
+	case 20:
+		return false, rest, nil
+	case 21:
+		return true, rest, nil
+	case 22, 23:
+		return nil, rest, nil
+	case 25, 26, 27:
+		// Floats never appear in WebAuthn structures; skip over them
+		size := 2 << (info - 25)
+		if len(rest) < size {
+			return nil, nil, errCBORTruncated
+		}
+		return nil, rest[size:], nil
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+}
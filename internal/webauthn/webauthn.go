@@ -0,0 +1,517 @@
+// Package webauthn implements the relying party side of WebAuthn Level 2
+// (https://www.w3.org/TR/webauthn-2/): the options passed to
+// navigator.credentials.create() and get(), and verification of the
+// attestation and assertion responses they produce.
+//
+// Binary values travel as unpadded base64url, as in the JSON produced by
+// PublicKeyCredential.toJSON() and accepted by parseCreationOptionsFromJSON().
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// maxCredentialIDLength is the limit WebAuthn puts on credential IDs
+const maxCredentialIDLength = 1023
+
+// oidFIDOAAGUID is the certificate extension carrying the authenticator model
+// in packed attestation certificates
+var oidFIDOAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// RelyingParty verifies responses for one RP ID (a registrable domain such as
+// example.com) coming from the listed web origins
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+func NewRelyingParty(id, name string, origins []string) *RelyingParty {
+	return &RelyingParty{ID: id, Name: name, Origins: origins}
+}
+
+// UserEntity identifies the account a credential is created for. ID is an
+// opaque handle that authenticators return with discoverable credentials.
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type credentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int64  `json:"alg"`
+}
+
+// CredentialDescriptor names an existing credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	RequireResident  bool   `json:"requireResidentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions for registering a passkey
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions for signing in with a passkey
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is the JSON form of a PublicKeyCredential from create()
+type AttestationResponse struct {
+	ID       string `json:"id" binding:"required"`
+	Type     string `json:"type" binding:"required"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+		AttestationObject string `json:"attestationObject" binding:"required"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of a PublicKeyCredential from get()
+type AssertionResponse struct {
+	ID       string `json:"id" binding:"required"`
+	Type     string `json:"type" binding:"required"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+		AuthenticatorData string `json:"authenticatorData" binding:"required"`
+		Signature         string `json:"signature" binding:"required"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is a newly registered credential
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE_Key exactly as the authenticator sent it
+	PublicKey []byte
+	Algorithm int64
+	AAGUID    []byte
+	SignCount uint32
+}
+
+// Assertion is the verified result of a sign-in
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	UserHandle   []byte
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// present only when flagAttestedData is set
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns a random challenge for one ceremony
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to generate webauthn challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// CreationOptions returns the options for registering a credential for user
+func (rp *RelyingParty) CreationOptions(challenge []byte, user UserEntity, exclude [][]byte, timeoutMillis int64) CreationOptions {
+	params := make([]credentialParameter, len(SupportedAlgorithms))
+	for i, algorithm := range SupportedAlgorithms {
+		params[i] = credentialParameter{Type: "public-key", Algorithm: algorithm}
+	}
+
+	return CreationOptions{
+		Challenge:          encode(challenge),
+		RP:                 rpEntity{ID: rp.ID, Name: rp.Name},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            timeoutMillis,
+		ExcludeCredentials: descriptors(exclude),
+		// Passkeys are discoverable, so they can sign in without a username
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			RequireResident:  true,
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options for signing in. An empty allow list lets
+// the user pick any discoverable credential for this RP.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow [][]byte, userVerification string, timeoutMillis int64) RequestOptions {
+	return RequestOptions{
+		Challenge:        encode(challenge),
+		RPID:             rp.ID,
+		Timeout:          timeoutMillis,
+		AllowCredentials: descriptors(allow),
+		UserVerification: userVerification,
+	}
+}
+
+// Challenge returns the challenge a response claims to answer, so the caller
+// can look up what it issued. Nothing is verified yet.
+func Challenge(clientDataJSON string) (string, error) {
+	raw, err := decode(clientDataJSON)
+	if err != nil {
+		return "", fmt.Errorf("invalid clientDataJSON: %w", err)
+	}
+
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return "", fmt.Errorf("invalid clientDataJSON: %w", err)
+	}
+	return data.Challenge, nil
+}
+
+// VerifyRegistration checks a create() response against the challenge issued
+// for it (§7.1) and returns the new credential
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, response AttestationResponse, requireUserVerification bool) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("unexpected credential type %q", response.Type)
+	}
+
+	clientDataJSON, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := decode(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestationObject: %w", err)
+	}
+	decoded, rest, err := decodeCBOR(rawAttestation)
+	if err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed attestation object")
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("malformed attestation object")
+	}
+
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if statement == nil || rawAuthData == nil {
+		return nil, errors.New("malformed attestation object")
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+	if authData.credentialID == nil {
+		return nil, errors.New("no attested credential data")
+	}
+
+	if encode(authData.credentialID) != strings.TrimRight(response.ID, "=") {
+		return nil, errors.New("credential ID does not match the attested credential")
+	}
+
+	publicKey, err := ParsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := verifyAttestation(format, statement, rawAuthData, clientDataHash[:], publicKey, authData.aaguid); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		Algorithm: publicKey.Algorithm,
+		AAGUID:    authData.aaguid,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks a get() response against the challenge issued for it
+// and the stored public key of the credential it names (§7.2). Sign counts are
+// left to the caller, which knows the stored count.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, publicKey []byte, response AssertionResponse, requireUserVerification bool) (*Assertion, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("unexpected credential type %q", response.Type)
+	}
+
+	clientDataJSON, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	rawAuthData, err := decode(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authenticatorData: %w", err)
+	}
+	signature, err := decode(response.Response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	userHandle, err := decode(response.Response.UserHandle)
+	if err != nil {
+		return nil, fmt.Errorf("invalid userHandle: %w", err)
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err := key.Verify(signed, signature); err != nil {
+		return nil, err
+	}
+
+	return &Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+		UserHandle:   userHandle,
+	}, nil
+}
+
+// verifyClientData checks the type, challenge and origin the browser recorded
+// and returns the raw JSON, whose hash the authenticator signed
+func (rp *RelyingParty) verifyClientData(encoded, ceremony string, challenge []byte) ([]byte, error) {
+	raw, err := decode(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clientDataJSON: %w", err)
+	}
+
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("invalid clientDataJSON: %w", err)
+	}
+
+	if data.Type != ceremony {
+		return nil, fmt.Errorf("unexpected client data type %q", data.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(data.Challenge, "=")), []byte(encode(challenge))) != 1 {
+		return nil, errors.New("challenge mismatch")
+	}
+	if !slices.Contains(rp.Origins, data.Origin) {
+		return nil, fmt.Errorf("unexpected origin %q", data.Origin)
+	}
+	if data.CrossOrigin {
+		return nil, errors.New("cross-origin requests are not allowed")
+	}
+
+	return raw, nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData, requireUserVerification bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(authData.rpIDHash, rpIDHash[:]) != 1 {
+		return errors.New("RP ID hash mismatch")
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return errors.New("user not present")
+	}
+	if requireUserVerification && authData.flags&flagUserVerified == 0 {
+		return errors.New("user not verified")
+	}
+	return nil
+}
+
+// parseAuthenticatorData splits the authenticator data (§6.1)
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data is truncated")
+	}
+
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return authData, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data is truncated")
+	}
+	authData.aaguid = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength > maxCredentialIDLength || len(rest) < idLength {
+		return nil, errors.New("invalid credential ID length")
+	}
+	authData.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// The COSE key is followed by extension outputs, if any
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, errors.New("malformed credential public key")
+	}
+	authData.publicKey = rest[:len(rest)-len(after)]
+
+	return authData, nil
+}
+
+// verifyAttestation checks the attestation statement (§8). Only "none" and
+// "packed" are accepted: registrations request no attestation, so browsers
+// send "none" and the statement is not chained to a trusted root either way.
+func verifyAttestation(format string, statement map[interface{}]interface{}, authData, clientDataHash []byte, credentialKey *PublicKey, aaguid []byte) error {
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return errors.New("none attestation must have an empty statement")
+		}
+		return nil
+
+	case "packed":
+		algorithm, _ := statement["alg"].(int64)
+		signature, _ := statement["sig"].([]byte)
+		if signature == nil {
+			return errors.New("packed attestation has no signature")
+		}
+		signed := append(append([]byte(nil), authData...), clientDataHash...)
+
+		chain, hasCertificates := statement["x5c"].([]interface{})
+		if !hasCertificates {
+			// Self attestation: signed with the credential key itself
+			if algorithm != credentialKey.Algorithm {
+				return errors.New("self attestation algorithm does not match the credential")
+			}
+			if err := credentialKey.Verify(signed, signature); err != nil {
+				return fmt.Errorf("self attestation: %w", err)
+			}
+			return nil
+		}
+
+		if len(chain) == 0 {
+			return errors.New("packed attestation has an empty certificate chain")
+		}
+		der, _ := chain[0].([]byte)
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid attestation certificate: %w", err)
+		}
+		if certificate.IsCA {
+			return errors.New("attestation certificate must not be a CA")
+		}
+
+		var signatureAlgorithm x509.SignatureAlgorithm
+		switch algorithm {
+		case AlgorithmES256:
+			signatureAlgorithm = x509.ECDSAWithSHA256
+		case AlgorithmRS256:
+			signatureAlgorithm = x509.SHA256WithRSA
+		case AlgorithmEdDSA:
+			signatureAlgorithm = x509.PureEd25519
+		default:
+			return fmt.Errorf("unsupported attestation algorithm %d", algorithm)
+		}
+		if err := certificate.CheckSignature(signatureAlgorithm, signed, signature); err != nil {
+			return fmt.Errorf("attestation signature: %w", err)
+		}
+
+		for _, extension := range certificate.Extensions {
+			if !extension.Id.Equal(oidFIDOAAGUID) {
+				continue
+			}
+			var certificateAAGUID []byte
+			if _, err := asn1.Unmarshal(extension.Value, &certificateAAGUID); err != nil || !bytes.Equal(certificateAAGUID, aaguid) {
+				return errors.New("attestation certificate AAGUID mismatch")
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported attestation format %q", format)
+	}
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, len(ids))
+	for i, id := range ids {
+		list[i] = CredentialDescriptor{Type: "public-key", ID: encode(id)}
+	}
+	return list
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decode accepts base64url with or without padding
+func decode(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// EncodeID and DecodeID convert credential IDs and user handles to and from
+// their base64url form
+func EncodeID(id []byte) string {
+	return encode(id)
+}
+
+func DecodeID(value string) ([]byte, error) {
+	return decode(value)
+}
@@ -0,0 +1,5 @@
+-- Drop refresh_tokens table
+DROP TABLE IF EXISTS refresh_tokens;
+
+-- Drop users table
+DROP TABLE IF EXISTS users;
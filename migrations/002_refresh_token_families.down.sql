@@ -0,0 +1,9 @@
+-- Drop security_events table
+DROP TABLE IF EXISTS security_events;
+
+-- Rotated tokens are not tracked before this migration
+DELETE FROM refresh_tokens WHERE rotated_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS family_id;
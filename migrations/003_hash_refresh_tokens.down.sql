@@ -0,0 +1,6 @@
+-- Raw tokens cannot be recovered from their hashes; drop rows that only have a hash
+DELETE FROM refresh_tokens WHERE token IS NULL;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_token_hash;
+ALTER TABLE refresh_tokens ALTER COLUMN token SET NOT NULL;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS token_hash;
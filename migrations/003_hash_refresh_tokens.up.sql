@@ -0,0 +1,8 @@
+-- Store a keyed hash of each refresh token instead of the raw JWT
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token_hash VARCHAR(64);
+
+-- Raw tokens are only kept on rows written before this migration until they are rehashed
+ALTER TABLE refresh_tokens ALTER COLUMN token DROP NOT NULL;
+
+-- Create unique index on token_hash for lookups
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens(token_hash);
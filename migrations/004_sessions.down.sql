@@ -0,0 +1,4 @@
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS fk_refresh_tokens_session;
+
+-- Drop sessions table
+DROP TABLE IF EXISTS sessions;
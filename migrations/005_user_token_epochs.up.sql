@@ -0,0 +1,2 @@
+-- Access tokens issued to a user at or before this time are rejected
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_revoked_at TIMESTAMP;
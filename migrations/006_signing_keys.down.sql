@@ -0,0 +1,2 @@
+-- Drop signing_keys table
+DROP TABLE IF EXISTS signing_keys;
@@ -0,0 +1,15 @@
+-- Create signing_keys table for the rotating token signing keyring
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id VARCHAR(64) PRIMARY KEY,
+    purpose VARCHAR(20) NOT NULL,
+    algorithm VARCHAR(10) NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    encrypted_key BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    activated_at TIMESTAMP,
+    deactivated_at TIMESTAMP,
+    retired_at TIMESTAMP
+);
+
+-- At most one key per purpose signs new tokens
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_active_purpose ON signing_keys(purpose) WHERE status = 'active';
@@ -0,0 +1,2 @@
+DELETE FROM signing_keys WHERE purpose = 'encryption';
+ALTER TABLE signing_keys ALTER COLUMN algorithm TYPE VARCHAR(10);
@@ -0,0 +1,2 @@
+-- JWE key management algorithm names such as RSA-OAEP-256 are longer than signing algorithms
+ALTER TABLE signing_keys ALTER COLUMN algorithm TYPE VARCHAR(20);
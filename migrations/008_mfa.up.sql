@@ -0,0 +1,20 @@
+-- Create mfa_enrollments table: one TOTP authenticator per user
+CREATE TABLE IF NOT EXISTS mfa_enrollments (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    encrypted_secret BYTEA NOT NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    confirmed_at TIMESTAMP
+);
+
+-- Create mfa_recovery_codes table: one-time codes, stored as keyed hashes
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES mfa_enrollments(user_id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP
+);
+
+-- Create index on user_id and code_hash for redeeming recovery codes
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_code ON mfa_recovery_codes(user_id, code_hash);
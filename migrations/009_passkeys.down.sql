@@ -0,0 +1,2 @@
+-- Drop passkeys table
+DROP TABLE IF EXISTS passkeys;
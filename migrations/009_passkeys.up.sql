@@ -0,0 +1,15 @@
+-- Create passkeys table: WebAuthn credentials, keyed by credential ID
+CREATE TABLE IF NOT EXISTS passkeys (
+    id BYTEA PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    public_key BYTEA NOT NULL,
+    algorithm INTEGER NOT NULL,
+    aaguid BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+-- Create index on user_id for listing a user's passkeys
+CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys(user_id);
@@ -0,0 +1,11 @@
+// Package migrations embeds the versioned SQL schema migrations so they ship
+// inside the binary.
+//
+// Files are named NNN_description.up.sql and NNN_description.down.sql, where
+// NNN is the schema version the migration produces.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var Files embed.FS